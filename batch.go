@@ -0,0 +1,454 @@
+package CaskDB
+
+import (
+	"encoding/binary"
+	"github.com/k-si/CaskDB/util"
+	"sync/atomic"
+	"time"
+)
+
+// batch mark, shared by all data types.
+// entries of a batch are written in this way in every data type file:
+// begin(id, n) | entry 1 | ... | entry n | commit(id)
+// the commit entries of all data types are written after all the
+// entries, so a batch is committed as long as one commit entry exists.
+const (
+	BatchBegin  uint16 = 0xfe
+	BatchCommit uint16 = 0xff
+)
+
+// the order of locking indexes, same as GC
+var lockOrder = []uint16{Str, Hash, List, Set, ZSet}
+
+type WriteBatch struct {
+	db       *DB
+	entries  [DataTypeNum][]*Entry
+	deferred map[*Entry]bool // entries of Del and Expire, kept only in the types of their keys
+	err      error
+}
+
+func (db *DB) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+func (db *DB) nextBatchId() []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, atomic.AddUint64(&db.batchId, 1))
+	return buf
+}
+
+func (wb *WriteBatch) put(e *Entry) {
+	dt := e.GetDataType()
+	wb.entries[dt] = append(wb.entries[dt], e)
+}
+
+// put an entry in every data type, write keeps it only in the types
+// that the key is in by then
+func (wb *WriteBatch) putDeferred(key, value []byte, marks []uint16) {
+	if wb.deferred == nil {
+		wb.deferred = make(map[*Entry]bool)
+	}
+	for i := uint16(0); i < DataTypeNum; i++ {
+		e := NewEntry(key, value, i, marks[i], 0)
+		wb.deferred[e] = true
+		wb.put(e)
+	}
+}
+
+// remember the first error, Commit will return it
+func (wb *WriteBatch) check(key []byte, values ...[]byte) bool {
+	if wb.err != nil {
+		return false
+	}
+	if wb.err = wb.db.checkKeySize(key); wb.err != nil {
+		return false
+	}
+	if len(values) > 0 {
+		if wb.err = wb.db.checkValsSize(values...); wb.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (wb *WriteBatch) Set(key, value []byte) {
+	if wb.check(key, value) {
+		wb.put(NewEntry(key, value, Str, StrSet, 0))
+	}
+}
+
+func (wb *WriteBatch) Remove(key []byte) {
+	if wb.check(key) {
+		wb.put(NewEntry(key, nil, Str, StrRemove, 0))
+	}
+}
+
+func (wb *WriteBatch) LPush(key []byte, values ...[]byte) {
+	if wb.check(key, values...) {
+		for _, v := range values {
+			wb.put(NewEntry(key, v, List, ListLPush, 0))
+		}
+	}
+}
+
+func (wb *WriteBatch) RPush(key []byte, values ...[]byte) {
+	if wb.check(key, values...) {
+		for _, v := range values {
+			wb.put(NewEntry(key, v, List, ListRPush, 0))
+		}
+	}
+}
+
+func (wb *WriteBatch) HSet(key, k, v []byte) {
+	if wb.check(key, v) && wb.check(k) {
+		wb.put(NewEntry(wb.db.splice(key, k), v, Hash, HashHSet, uint32(len(key))))
+	}
+}
+
+func (wb *WriteBatch) HDel(key []byte, fields ...[]byte) {
+	if wb.check(key) {
+		for _, k := range fields {
+			if wb.check(k) {
+				wb.put(NewEntry(wb.db.splice(key, k), nil, Hash, HashHDel, uint32(len(key))))
+			}
+		}
+	}
+}
+
+func (wb *WriteBatch) SAdd(key []byte, values ...[]byte) {
+	if wb.check(key, values...) {
+		for _, v := range values {
+			wb.put(NewEntry(key, v, Set, SetSAdd, 0))
+		}
+	}
+}
+
+func (wb *WriteBatch) SRem(key []byte, values ...[]byte) {
+	if wb.check(key, values...) {
+		for _, v := range values {
+			wb.put(NewEntry(key, v, Set, SetSRem, 0))
+		}
+	}
+}
+
+func (wb *WriteBatch) ZAdd(key []byte, score float64, member []byte) {
+	if wb.check(key, member) {
+		keys := wb.db.splice(key, util.Float64ToBytes(score))
+		wb.put(NewEntry(keys, member, ZSet, ZSetZAdd, uint32(len(key))))
+	}
+}
+
+func (wb *WriteBatch) ZRem(key, member []byte) {
+	if wb.check(key, member) {
+		wb.put(NewEntry(key, member, ZSet, ZSetZRem, 0))
+	}
+}
+
+// remove keys in all data types which they are in when the batch is written
+func (wb *WriteBatch) Del(keys ...[]byte) {
+	for _, k := range keys {
+		if wb.check(k) {
+			wb.putDeferred(k, nil, clearMarks)
+		}
+	}
+}
+
+// set a timeout on key in all data types which it is in when the batch is written
+func (wb *WriteBatch) Expire(key []byte, ttl time.Duration) {
+	if wb.check(key) {
+		wb.putDeferred(key, util.IntToBytes(int(time.Now().Add(ttl).UnixNano())), expireMarks)
+	}
+}
+
+// write all entries of batch, either all of them take effect or none
+func (wb *WriteBatch) Commit() error {
+	if wb.err != nil {
+		return wb.err
+	}
+
+	db := wb.db
+	if err := db.writable(); err != nil {
+		return err
+	}
+	types := wb.types()
+	if len(types) == 0 {
+		return nil
+	}
+
+	// lock all involved types
+	for _, dt := range types {
+		db.lockerOf(dt).Lock()
+	}
+	defer func() {
+		for _, dt := range types {
+			db.lockerOf(dt).Unlock()
+		}
+	}()
+
+	return wb.write()
+}
+
+// data types which the batch has entries of, in lock order
+func (wb *WriteBatch) types() []uint16 {
+	var types []uint16
+	for _, dt := range lockOrder {
+		if len(wb.entries[dt]) > 0 {
+			types = append(types, dt)
+		}
+	}
+	return types
+}
+
+// bytes of the entries of dataType with the begin and commit of batch id
+func (wb *WriteBatch) size(dataType uint16, id []byte) int64 {
+	entries := wb.entries[dataType]
+	begin := NewEntry(id, util.IntToBytes(len(entries)), dataType, BatchBegin, 0)
+	commit := NewEntry(id, nil, dataType, BatchCommit, 0)
+	size := int64(begin.Size() + commit.Size())
+	for _, e := range entries {
+		size += int64(e.Size())
+	}
+	return size
+}
+
+// whether the batch of every type fits in one file
+func (wb *WriteBatch) fits() bool {
+	id := make([]byte, 8)
+	for _, dt := range wb.types() {
+		if wb.size(dt, id) > wb.db.config.MaxFileSize-FileHeaderSize {
+			return false
+		}
+	}
+	return true
+}
+
+// drop the entries of Del and Expire in the types that their keys are
+// neither in nor written in before by the batch, like db.Del does.
+// caller must hold the write locks of its types
+func (wb *WriteBatch) resolve() {
+	if len(wb.deferred) == 0 {
+		return
+	}
+	db := wb.db
+	for dt := uint16(0); dt < DataTypeNum; dt++ {
+		written := make(map[string]bool)
+		entries := wb.entries[dt][:0]
+		for _, e := range wb.entries[dt] {
+			if wb.deferred[e] {
+				db.expireIfNeeded(dt, e.key)
+				if !db.keyInIndex(dt, string(e.key)) && !written[string(e.key)] {
+					continue
+				}
+			}
+			for _, k := range entryKeys(e) {
+				written[k] = true
+			}
+			entries = append(entries, e)
+		}
+		wb.entries[dt] = entries
+	}
+	wb.deferred = nil
+}
+
+// write the batch, caller must hold the write locks of its types
+func (wb *WriteBatch) write() error {
+	db := wb.db
+	wb.resolve()
+	types := wb.types()
+	if len(types) == 0 {
+		return nil
+	}
+
+	// list elements take their sequences under the lock
+	wb.entries[List] = db.seqEntries(wb.entries[List])
+
+	id := db.nextBatchId()
+	commits := make([]*Entry, DataTypeNum)
+	indexes := make([][]*Index, DataTypeNum)
+
+	// size every type and rotate files before writing anything,
+	// so a batch which can not be written leaves nothing in any file
+	now := uint64(time.Now().UnixNano())
+	begins := make([]*Entry, DataTypeNum)
+	for _, dt := range types {
+		entries := wb.entries[dt]
+		for _, e := range entries {
+			e.timestamp = now
+		}
+		begins[dt] = NewEntry(id, util.IntToBytes(len(entries)), dt, BatchBegin, 0)
+		commits[dt] = NewEntry(id, nil, dt, BatchCommit, 0)
+
+		// the whole batch must be in one file
+		size := wb.size(dt, id)
+		if size > db.config.MaxFileSize-FileHeaderSize {
+			return ErrorWriteOverFlow
+		}
+		if db.activeFiles[dt].offset+size > db.config.MaxFileSize {
+			if err := db.rotateFile(dt); err != nil {
+				return err
+			}
+		}
+	}
+
+	// write begin and entries of every type
+	for _, dt := range types {
+		entries := wb.entries[dt]
+		begin := begins[dt]
+		f := db.activeFiles[dt]
+		if err := f.Write(begin); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := f.Write(e); err != nil {
+				return err
+			}
+			indexes[dt] = append(indexes[dt], &Index{
+				fileId: f.id,
+				offset: f.offset - int64(e.Size()),
+				size:   e.Size(),
+			})
+		}
+		if db.config.WriteSync {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// write commits
+	for _, dt := range types {
+		f := db.activeFiles[dt]
+		if err := f.Write(commits[dt]); err != nil {
+			return err
+		}
+		if db.config.WriteSync {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// all entries are on disk, update memory
+	for _, dt := range types {
+		for i, e := range wb.entries[dt] {
+			db.watch.touch(entryKeys(e)...)
+			db.snaps.save(dt, entryKeys(e)...)
+			db.buildIndex(e, indexes[dt][i])
+		}
+	}
+
+	return nil
+}
+
+// replay entries of one data type while loading,
+// entries of batch are held until the commit entry shows up
+type replayer struct {
+	db *DB
+
+	begin       *Entry // begin entry of the batch which is not committed yet
+	beginOffset int64
+	want        int
+	entries     []*Entry
+	indexes     []*Index
+	lastCommit  uint64 // id of the last committed batch
+}
+
+func newReplayer(db *DB) *replayer {
+	return &replayer{db: db}
+}
+
+func (r *replayer) reset() {
+	r.begin = nil
+	r.beginOffset = 0
+	r.want = 0
+	r.entries = nil
+	r.indexes = nil
+}
+
+func (r *replayer) replay(e *Entry, idx *Index, offset int64) {
+	mt := e.GetMarkType()
+
+	if r.begin != nil {
+		if mt != BatchBegin && mt != BatchCommit && len(r.entries) < r.want {
+			r.entries = append(r.entries, e)
+			r.indexes = append(r.indexes, idx)
+			return
+		}
+		if mt == BatchCommit && len(r.entries) == r.want && string(e.key) == string(r.begin.key) {
+			r.commit()
+			return
+		}
+
+		// the batch is not committed, throw it away
+		r.reset()
+	}
+
+	switch mt {
+	case BatchBegin:
+		r.begin = e
+		r.beginOffset = offset
+		r.want = util.BytesToInt(e.value)
+	case BatchCommit:
+		// commit without begin is useless
+	default:
+		r.db.buildIndex(e, idx)
+	}
+}
+
+func (r *replayer) commit() {
+	for i, e := range r.entries {
+		r.db.buildIndex(e, r.indexes[i])
+	}
+	r.lastCommit = binary.BigEndian.Uint64(r.begin.key)
+	r.reset()
+}
+
+// batch at the end of active file is not committed in its own type,
+// if it is committed in another type, the crash happened while writing
+// commits, so we finish it. otherwise, cut it off from the file.
+func (db *DB) resolveBatches(replayers []*replayer) error {
+	for i, r := range replayers {
+		if r.begin == nil {
+			continue
+		}
+		id := binary.BigEndian.Uint64(r.begin.key)
+		f := db.activeFiles[i]
+
+		committed := false
+		for j, o := range replayers {
+			if j != i && o.lastCommit == id {
+				committed = true
+			}
+		}
+
+		if db.config.ReadOnly {
+
+			// leave the file as it is, the writer may still be writing
+			if committed && len(r.entries) == r.want {
+				r.commit()
+			} else {
+				r.reset()
+			}
+			continue
+		}
+
+		if committed && len(r.entries) == r.want {
+			db.logger().Println("[finish broken batch]", FileNameSuffix[i], id)
+			if err := f.Write(NewEntry(r.begin.key, nil, uint16(i), BatchCommit, 0)); err != nil {
+				return err
+			}
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			r.commit()
+		} else {
+			db.logger().Println("[discard broken batch]", FileNameSuffix[i], id)
+			f.truncate(r.beginOffset)
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			r.reset()
+		}
+	}
+	return nil
+}
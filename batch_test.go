@@ -0,0 +1,272 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/k-si/CaskDB/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteBatch_Commit(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch()
+	wb.Set([]byte("k"), []byte("v"))
+	wb.LPush([]byte("l"), []byte("a"), []byte("b"))
+	wb.HSet([]byte("h"), []byte("f"), []byte("v"))
+	wb.SAdd([]byte("s"), []byte("a"))
+	wb.ZAdd([]byte("z"), 1, []byte("a"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		v, err := db.Get([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), v)
+		res, err := db.LRange([]byte("l"), 0, -1)
+		assert.Equal(t, [][]byte{[]byte("b"), []byte("a")}, res)
+		v, err = db.HGet([]byte("h"), []byte("f"))
+		assert.Equal(t, []byte("v"), v)
+		assert.True(t, db.SIsMember([]byte("s"), []byte("a")))
+		assert.True(t, db.ZIsMember([]byte("z"), []byte("a")))
+
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestWriteBatch_Check(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch()
+	wb.Set([]byte("k"), []byte("v"))
+	wb.Set(nil, []byte("v"))
+	err = wb.Commit()
+	assert.Equal(t, ErrorKeyNil, err)
+	assert.False(t, db.StrKeyExist([]byte("k")))
+
+	err = db.MSet([]byte("k1"), []byte("v1"), []byte{}, []byte("v2"))
+	assert.Equal(t, ErrorKeyEmpty, err)
+	assert.False(t, db.StrKeyExist([]byte("k1")))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+// write batch as Commit does, but stop before commit entries
+func writeBrokenBatch(t *testing.T, db *DB, wb *WriteBatch, commitTypes ...uint16) {
+	id := db.nextBatchId()
+	for _, dt := range lockOrder {
+		if len(wb.entries[dt]) == 0 {
+			continue
+		}
+		f := db.activeFiles[dt]
+		err := f.Write(NewEntry(id, util.IntToBytes(len(wb.entries[dt])), dt, BatchBegin, 0))
+		assert.Nil(t, err)
+		for _, e := range wb.entries[dt] {
+			assert.Nil(t, f.Write(e))
+		}
+	}
+	for _, dt := range commitTypes {
+		err := db.activeFiles[dt].Write(NewEntry(id, nil, dt, BatchCommit, 0))
+		assert.Nil(t, err)
+	}
+}
+
+func TestWriteBatch_Broken(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	err = db.Set([]byte("k0"), []byte("v0"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch()
+	wb.Set([]byte("k1"), []byte("v1"))
+	wb.HSet([]byte("h"), []byte("f"), []byte("v"))
+	writeBrokenBatch(t, db, wb)
+	off := db.activeFiles[Str].offset
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// no commit, the batch is discarded and cut off
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.True(t, db.StrKeyExist([]byte("k0")))
+	assert.False(t, db.StrKeyExist([]byte("k1")))
+	assert.False(t, db.HKeyExist([]byte("h")))
+	assert.True(t, db.activeFiles[Str].offset < off)
+
+	// new entries will not be taken as a part of broken batch
+	err = db.Set([]byte("k2"), []byte("v2"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	v, err := db.Get([]byte("k2"))
+	assert.Equal(t, []byte("v2"), v)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestWriteBatch_HalfCommitted(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch()
+	wb.Set([]byte("k"), []byte("v"))
+	wb.HSet([]byte("h"), []byte("f"), []byte("v"))
+	wb.SAdd([]byte("s"), []byte("a"))
+
+	// crash after str commit was written
+	writeBrokenBatch(t, db, wb, Str)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+		assert.True(t, db.StrKeyExist([]byte("k")))
+		assert.True(t, db.HExist([]byte("h"), []byte("f")))
+		assert.True(t, db.SIsMember([]byte("s"), []byte("a")))
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+}
+
+func TestWriteBatch_Rotate(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 120
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	err = db.Set([]byte("aa"), []byte("11")) // 30
+
+	// 34 + 30 + 30 + 34 = 128, too large
+	wb := db.NewWriteBatch()
+	wb.Set([]byte("bb"), []byte("22"))
+	wb.Set([]byte("cc"), []byte("33"))
+	err = wb.Commit()
+	assert.Equal(t, ErrorWriteOverFlow, err)
+
+	// 34 + 30 + 34 = 98, move to next file
+	wb = db.NewWriteBatch()
+	wb.Set([]byte("bb"), []byte("22"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), db.activeFiles[Str].id)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	v, err := db.Get([]byte("bb"))
+	assert.Equal(t, []byte("22"), v)
+	v, err = db.Get([]byte("aa"))
+	assert.Equal(t, []byte("11"), v)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestWriteBatch_OverflowGC(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// live entries only in the oldest files, so they are not merged
+	for i := 0; db.activeFiles[Str].id == 0 || db.activeFiles[Hash].id == 0; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v")))
+		assert.Nil(t, db.HSet([]byte("h"), []byte(fmt.Sprintf("f%d", i)), []byte("v")))
+	}
+
+	// set is too large, nothing is written in str and hash files
+	wb := db.NewWriteBatch()
+	wb.Remove([]byte("k0"))
+	wb.HDel([]byte("h"), []byte("f0"))
+	wb.SAdd([]byte("s"), make([]byte, 300))
+	assert.Equal(t, ErrorWriteOverFlow, wb.Commit())
+
+	// a batch broken in the middle of files
+	wb = db.NewWriteBatch()
+	wb.Remove([]byte("k1"))
+	wb.HDel([]byte("h"), []byte("f1"))
+	writeBrokenBatch(t, db, wb)
+
+	// garbage makes the files with the batches worth merging
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Set([]byte("x"), []byte("v")))
+		assert.Nil(t, db.HSet([]byte("h"), []byte("x"), []byte("v")))
+	}
+	assert.Nil(t, db.GC())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		assert.True(t, db.StrKeyExist([]byte(fmt.Sprintf("k%d", i))))
+		assert.True(t, db.HExist([]byte("h"), []byte(fmt.Sprintf("f%d", i))))
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestWriteBatch_Del(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k, k2 := []byte("k"), []byte("k2")
+	assert.Nil(t, db.Set(k, []byte("v")))
+	assert.Nil(t, db.HSet(k, []byte("f"), []byte("v")))
+
+	wb := db.NewWriteBatch()
+	wb.ZAdd(k, 1, []byte("m"))
+	wb.Del(k)
+	wb.Set(k2, []byte("v"))
+	wb.Expire(k2, time.Hour)
+	assert.Nil(t, wb.Commit())
+
+	// marks are written only in the types which the keys are in
+	assert.Equal(t, 3, len(wb.entries[Str]))
+	assert.Equal(t, 1, len(wb.entries[Hash]))
+	assert.Equal(t, 0, len(wb.entries[List]))
+	assert.Equal(t, 0, len(wb.entries[Set]))
+	assert.Equal(t, 2, len(wb.entries[ZSet]))
+
+	check := func() {
+		assert.Nil(t, db.Type(k))
+		ttl, err := db.TTL(k2)
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
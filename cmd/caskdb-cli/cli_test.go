@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"github.com/k-si/CaskDB"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`set  k "hello world" ""`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("k"), []byte("hello world"), {}}, args)
+
+	args, err = splitArgs(`set k "a\"b\n"`)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a\"b\n"), args[2])
+
+	_, err = splitArgs(`set k "v`)
+	assert.NotNil(t, err)
+}
+
+func TestComplete(t *testing.T) {
+	assert.Equal(t, []string{"zscore", "zscorerange"}, complete("zsc"))
+	assert.Equal(t, []string{"keys set", "keys str"}, complete("keys s"))
+	assert.Equal(t, []string{"help hgetall"}, complete("help hgeta"))
+	assert.Nil(t, complete("get k"))
+}
+
+func TestCli_Exec(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB-cli")
+	cfg := CaskDB.DefaultConfig()
+	cfg.DBDir = "/tmp/CaskDB-cli"
+	db, err := CaskDB.Open(cfg)
+	assert.Nil(t, err)
+
+	out := &bytes.Buffer{}
+	c := &cli{db: db, out: out}
+	run := func(line string) string {
+		out.Reset()
+		args, err := splitArgs(line)
+		assert.Nil(t, err)
+		c.exec(args)
+		return out.String()
+	}
+
+	assert.Equal(t, "OK\n", run("set k v"))
+	assert.Equal(t, "\"v\"\n", run("get k"))
+	assert.Equal(t, "(nil)\n", run("get none"))
+	assert.Equal(t, "(integer) 2\n", run("rpush l a b"))
+	assert.Equal(t, "1) \"a\"\n2) \"b\"\n", run("lrange l 0 -1"))
+	assert.Equal(t, "OK\n", run("zadd z 1.5 m"))
+	assert.Equal(t, "1) \"m\"\n2) \"1.5\"\n", run("zscorerange z 0 2"))
+	assert.Equal(t, "1) \"k\"\n", run("keys str"))
+	assert.Equal(t, "(error) unknown data type, use one of str, list, hash, set, zset\n", run("keys foo"))
+	assert.Equal(t, "(error) unknown command\n", run("foo"))
+	assert.Equal(t, "(error) wrong number of arguments\nusage: get key\n", run("get"))
+
+	c.readonly = true
+	assert.Equal(t, "(error) command not allowed in readonly mode\n", run("set k v2"))
+	assert.Equal(t, "\"v\"\n", run("get k"))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/k-si/CaskDB"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorUnknownCommand = errors.New("[unknown command]")
+	ErrorArgsNumber     = errors.New("[wrong number of arguments]")
+	ErrorNotInteger     = errors.New("[value is not an integer]")
+	ErrorNotFloat       = errors.New("[value is not a float]")
+	ErrorUnknownType    = errors.New("[unknown data type, use one of str, list, hash, set, zset]")
+	ErrorReadOnly       = errors.New("[command not allowed in readonly mode]")
+)
+
+// data type names used by keys and stats
+var typeNames = []string{"str", "list", "hash", "set", "zset"}
+
+type command struct {
+	fn    func(cli *cli, args [][]byte) error
+	min   int  // min number of arguments
+	max   int  // max number of arguments, -1 means no limit
+	write bool // not allowed in readonly mode
+	usage string
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		// string
+		"set":    {set, 2, 2, true, "set key value"},
+		"setex":  {setEx, 3, 3, true, "setex key seconds value"},
+		"setnx":  {setNx, 2, 2, true, "setnx key value"},
+		"get":    {get, 1, 1, false, "get key"},
+		"getset": {getSet, 2, 2, true, "getset key value"},
+		"mset":   {mSet, 2, -1, true, "mset key value [key value ...]"},
+		"mget":   {mGet, 1, -1, false, "mget key [key ...]"},
+		"remove": {remove, 1, 1, true, "remove key"},
+		"strlen": {strLen, 0, 0, false, "strlen"},
+
+		// list
+		"lpush":  {lPush, 2, -1, true, "lpush key value [value ...]"},
+		"rpush":  {rPush, 2, -1, true, "rpush key value [value ...]"},
+		"lpop":   {lPop, 1, 1, true, "lpop key"},
+		"rpop":   {rPop, 1, 1, true, "rpop key"},
+		"lindex": {lIndex, 2, 2, false, "lindex key index"},
+		"lset":   {lSet, 3, 3, true, "lset key index value"},
+		"lrem":   {lRem, 3, 3, true, "lrem key value count"},
+		"lrange": {lRange, 3, 3, false, "lrange key start stop"},
+		"llen":   {lLen, 1, 1, false, "llen key"},
+
+		// hash
+		"hset":    {hSet, 3, 3, true, "hset key field value"},
+		"hsetnx":  {hSetNx, 3, 3, true, "hsetnx key field value"},
+		"hget":    {hGet, 2, 2, false, "hget key field"},
+		"hdel":    {hDel, 2, -1, true, "hdel key field [field ...]"},
+		"hgetall": {hGetAll, 1, 1, false, "hgetall key"},
+		"hexist":  {hExist, 2, 2, false, "hexist key field"},
+		"hlen":    {hLen, 1, 1, false, "hlen key"},
+
+		// set
+		"sadd":      {sAdd, 2, -1, true, "sadd key member [member ...]"},
+		"srem":      {sRem, 2, -1, true, "srem key member [member ...]"},
+		"smove":     {sMove, 3, 3, true, "smove src dest member"},
+		"sunion":    {sUnion, 1, -1, false, "sunion key [key ...]"},
+		"sdiff":     {sDiff, 1, -1, false, "sdiff key [key ...]"},
+		"sscan":     {sScan, 1, 1, false, "sscan key"},
+		"sismember": {sIsMember, 2, 2, false, "sismember key member"},
+		"scard":     {sCard, 1, 1, false, "scard key"},
+
+		// sorted set
+		"zadd":        {zAdd, 3, 3, true, "zadd key score member"},
+		"zrem":        {zRem, 2, 2, true, "zrem key member"},
+		"zscore":      {zScore, 2, 2, false, "zscore key member"},
+		"zscorerange": {zScoreRange, 3, 3, false, "zscorerange key min max"},
+		"ztop":        {zTop, 2, 2, false, "ztop key n"},
+		"zcard":       {zCard, 1, 1, false, "zcard key"},
+		"zismember":   {zIsMember, 2, 2, false, "zismember key member"},
+
+		// key
+		"expire":  {expire, 2, 2, true, "expire key seconds"},
+		"ttl":     {ttl, 1, 1, false, "ttl key"},
+		"persist": {persist, 1, 1, true, "persist key"},
+
+		// tools
+		"keys":  {keys, 0, -1, false, "keys str|list|hash|set|zset"},
+		"stats": {stats, 0, 0, false, "stats"},
+		"gc":    {gc, 0, 0, true, "gc"},
+		"help":  {help, 0, 1, false, "help [command]"},
+	}
+}
+
+type cli struct {
+	db       *CaskDB.DB
+	out      io.Writer
+	readonly bool
+}
+
+// run a command line, errors are printed as replies
+func (c *cli) exec(args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.printErr(ErrorUnknownCommand)
+		return
+	}
+	n := len(args) - 1
+	if n < cmd.min || (cmd.max >= 0 && n > cmd.max) {
+		c.printErr(ErrorArgsNumber)
+		fmt.Fprintf(c.out, "usage: %s\n", cmd.usage)
+		return
+	}
+	if cmd.write && c.readonly {
+		c.printErr(ErrorReadOnly)
+		return
+	}
+	if err := cmd.fn(c, args[1:]); err != nil {
+		c.printErr(err)
+	}
+}
+
+// complete command names and data types of keys
+func complete(line string) []string {
+	fields := strings.Fields(line)
+	var cands []string
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(line, " ")):
+		prefix := ""
+		if len(fields) == 1 {
+			prefix = strings.ToLower(fields[0])
+		}
+		for name := range commands {
+			if strings.HasPrefix(name, prefix) {
+				cands = append(cands, name)
+			}
+		}
+	case strings.ToLower(fields[0]) == "keys" || strings.ToLower(fields[0]) == "help":
+		prefix := ""
+		if len(fields) == 2 && !strings.HasSuffix(line, " ") {
+			prefix = fields[1]
+		} else if len(fields) != 1 {
+			return nil
+		}
+		names := typeNames
+		if strings.ToLower(fields[0]) == "help" {
+			names = nil
+			for name := range commands {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				cands = append(cands, fields[0]+" "+name)
+			}
+		}
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+// split line into arguments, double quotes keep spaces
+func splitArgs(line string) ([][]byte, error) {
+	var args [][]byte
+	var cur []byte
+	inQuote, inArg := false, false
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case inQuote && ch == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				cur = append(cur, '\n')
+			case 't':
+				cur = append(cur, '\t')
+			default:
+				cur = append(cur, line[i])
+			}
+		case ch == '"':
+			inQuote = !inQuote
+			inArg = true
+		case !inQuote && (ch == ' ' || ch == '\t'):
+			if inArg {
+				args = append(args, cur)
+				cur, inArg = nil, false
+			}
+		default:
+			cur = append(cur, ch)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("[unbalanced quotes]")
+	}
+	if inArg {
+		if cur == nil {
+			cur = []byte{}
+		}
+		args = append(args, cur)
+	}
+	return args, nil
+}
+
+// output looks like redis-cli
+
+func (c *cli) printErr(err error) {
+	fmt.Fprintf(c.out, "(error) %s\n", strings.Trim(err.Error(), "[]"))
+}
+
+func (c *cli) printOK() {
+	fmt.Fprintln(c.out, "OK")
+}
+
+func (c *cli) printInt(n int) {
+	fmt.Fprintf(c.out, "(integer) %d\n", n)
+}
+
+func (c *cli) printBool(b bool) {
+	if b {
+		c.printInt(1)
+	} else {
+		c.printInt(0)
+	}
+}
+
+func (c *cli) printBulk(b []byte) {
+	if b == nil {
+		fmt.Fprintln(c.out, "(nil)")
+		return
+	}
+	fmt.Fprintln(c.out, strconv.Quote(string(b)))
+}
+
+func (c *cli) printList(bs [][]byte) {
+	if len(bs) == 0 {
+		fmt.Fprintln(c.out, "(empty array)")
+		return
+	}
+	w := len(strconv.Itoa(len(bs)))
+	for i, b := range bs {
+		fmt.Fprintf(c.out, "%*d) ", w, i+1)
+		c.printBulk(b)
+	}
+}
+
+// member and score are paired
+func (c *cli) printScores(res []interface{}) {
+	var bs [][]byte
+	for i := 0; i+1 < len(res); i += 2 {
+		bs = append(bs, []byte(res[i].(string)), []byte(strconv.FormatFloat(res[i+1].(float64), 'g', -1, 64)))
+	}
+	c.printList(bs)
+}
+
+func parseInt(b []byte) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, ErrorNotInteger
+	}
+	return n, nil
+}
+
+func parseFloat(b []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ErrorNotFloat
+	}
+	return f, nil
+}
+
+func parseType(b []byte) (uint16, error) {
+	for i, name := range typeNames {
+		if strings.ToLower(string(b)) == name {
+			return uint16(i), nil
+		}
+	}
+	return 0, ErrorUnknownType
+}
+
+// string
+
+func set(c *cli, args [][]byte) error {
+	if err := c.db.Set(args[0], args[1]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func setEx(c *cli, args [][]byte) error {
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if err = c.db.SetEx(args[0], args[2], time.Duration(n)*time.Second); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func setNx(c *cli, args [][]byte) error {
+	if err := c.db.SetNx(args[0], args[1]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func get(c *cli, args [][]byte) error {
+	v, err := c.db.Get(args[0])
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func getSet(c *cli, args [][]byte) error {
+	v, err := c.db.GetSet(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func mSet(c *cli, args [][]byte) error {
+	if err := c.db.MSet(args...); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func mGet(c *cli, args [][]byte) error {
+	vs, err := c.db.MGet(args...)
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func remove(c *cli, args [][]byte) error {
+	if err := c.db.Remove(args[0]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func strLen(c *cli, args [][]byte) error {
+	c.printInt(c.db.StrLen())
+	return nil
+}
+
+// list
+
+func lPush(c *cli, args [][]byte) error {
+	if err := c.db.LPush(args[0], args[1:]...); err != nil {
+		return err
+	}
+	c.printInt(c.db.LLen(args[0]))
+	return nil
+}
+
+func rPush(c *cli, args [][]byte) error {
+	if err := c.db.RPush(args[0], args[1:]...); err != nil {
+		return err
+	}
+	c.printInt(c.db.LLen(args[0]))
+	return nil
+}
+
+func lPop(c *cli, args [][]byte) error {
+	if c.db.LLen(args[0]) == 0 {
+		c.printBulk(nil)
+		return nil
+	}
+	v, err := c.db.LPop(args[0])
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func rPop(c *cli, args [][]byte) error {
+	if c.db.LLen(args[0]) == 0 {
+		c.printBulk(nil)
+		return nil
+	}
+	v, err := c.db.RPop(args[0])
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func lIndex(c *cli, args [][]byte) error {
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if c.db.LLen(args[0]) == 0 {
+		c.printBulk(nil)
+		return nil
+	}
+	v, err := c.db.LIndex(args[0], n)
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func lSet(c *cli, args [][]byte) error {
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if err = c.db.LSet(args[0], args[2], n); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func lRem(c *cli, args [][]byte) error {
+	n, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	if err = c.db.LRem(args[0], args[1], n); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func lRange(c *cli, args [][]byte) error {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	vs, err := c.db.LRange(args[0], start, stop)
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func lLen(c *cli, args [][]byte) error {
+	c.printInt(c.db.LLen(args[0]))
+	return nil
+}
+
+// hash
+
+func hSet(c *cli, args [][]byte) error {
+	if err := c.db.HSet(args[0], args[1], args[2]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func hSetNx(c *cli, args [][]byte) error {
+	if err := c.db.HSetNx(args[0], args[1], args[2]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func hGet(c *cli, args [][]byte) error {
+	v, err := c.db.HGet(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	c.printBulk(v)
+	return nil
+}
+
+func hDel(c *cli, args [][]byte) error {
+	if err := c.db.HDel(args[0], args[1:]...); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func hGetAll(c *cli, args [][]byte) error {
+	vs, err := c.db.HGetAll(args[0])
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func hExist(c *cli, args [][]byte) error {
+	c.printBool(c.db.HExist(args[0], args[1]))
+	return nil
+}
+
+func hLen(c *cli, args [][]byte) error {
+	c.printInt(c.db.HLen(args[0]))
+	return nil
+}
+
+// set
+
+func sAdd(c *cli, args [][]byte) error {
+	if err := c.db.SAdd(args[0], args[1:]...); err != nil {
+		return err
+	}
+	c.printInt(c.db.SCard(args[0]))
+	return nil
+}
+
+func sRem(c *cli, args [][]byte) error {
+	if err := c.db.SRem(args[0], args[1:]...); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func sMove(c *cli, args [][]byte) error {
+	if err := c.db.SMove(args[0], args[1], args[2]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func sUnion(c *cli, args [][]byte) error {
+	vs, err := c.db.SUnion(args...)
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func sDiff(c *cli, args [][]byte) error {
+	vs, err := c.db.SDiff(args...)
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func sScan(c *cli, args [][]byte) error {
+	vs, err := c.db.SMembers(args[0])
+	if err != nil {
+		return err
+	}
+	c.printList(vs)
+	return nil
+}
+
+func sIsMember(c *cli, args [][]byte) error {
+	c.printBool(c.db.SIsMember(args[0], args[1]))
+	return nil
+}
+
+func sCard(c *cli, args [][]byte) error {
+	c.printInt(c.db.SCard(args[0]))
+	return nil
+}
+
+// sorted set
+
+func zAdd(c *cli, args [][]byte) error {
+	score, err := parseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	if err = c.db.ZAdd(args[0], score, args[2]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func zRem(c *cli, args [][]byte) error {
+	if err := c.db.ZRem(args[0], args[1]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func zScore(c *cli, args [][]byte) error {
+	ok, score := c.db.ZScore(args[0], args[1])
+	if !ok {
+		c.printBulk(nil)
+		return nil
+	}
+	c.printBulk([]byte(strconv.FormatFloat(score, 'g', -1, 64)))
+	return nil
+}
+
+func zScoreRange(c *cli, args [][]byte) error {
+	min, err := parseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	max, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	res, err := c.db.ZScoreRange(args[0], min, max)
+	if err != nil {
+		return err
+	}
+	c.printScores(res)
+	return nil
+}
+
+func zTop(c *cli, args [][]byte) error {
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	res, err := c.db.ZTop(args[0], n)
+	if err != nil {
+		return err
+	}
+	c.printScores(res)
+	return nil
+}
+
+func zCard(c *cli, args [][]byte) error {
+	c.printInt(c.db.ZCard(args[0]))
+	return nil
+}
+
+func zIsMember(c *cli, args [][]byte) error {
+	c.printBool(c.db.ZIsMember(args[0], args[1]))
+	return nil
+}
+
+// key
+
+func expire(c *cli, args [][]byte) error {
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if err = c.db.Expire(args[0], time.Duration(n)*time.Second); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+func ttl(c *cli, args [][]byte) error {
+	d, err := c.db.TTL(args[0])
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		fmt.Fprintln(c.out, "no ttl")
+		return nil
+	}
+	fmt.Fprintln(c.out, d.Round(time.Millisecond))
+	return nil
+}
+
+func persist(c *cli, args [][]byte) error {
+	if err := c.db.Persist(args[0]); err != nil {
+		return err
+	}
+	c.printOK()
+	return nil
+}
+
+// tools
+
+// list keys of data types, all types if none is given
+func keys(c *cli, args [][]byte) error {
+	var types []uint16
+	for _, a := range args {
+		dt, err := parseType(a)
+		if err != nil {
+			return err
+		}
+		types = append(types, dt)
+	}
+
+	if len(types) == 1 {
+		c.printList(c.db.Keys(types[0]))
+		return nil
+	}
+	if len(types) == 0 {
+		types = []uint16{CaskDB.Str, CaskDB.List, CaskDB.Hash, CaskDB.Set, CaskDB.ZSet}
+	}
+	for _, dt := range types {
+		fmt.Fprintf(c.out, "# %s\n", typeNames[dt])
+		c.printList(c.db.Keys(dt))
+	}
+	return nil
+}
+
+// number of keys and elements of every data type
+func stats(c *cli, args [][]byte) error {
+	fmt.Fprintf(c.out, "str:  keys=%d strlen=%d\n", len(c.db.Keys(CaskDB.Str)), c.db.StrLen())
+
+	n, elems := 0, 0
+	for _, k := range c.db.Keys(CaskDB.List) {
+		n, elems = n+1, elems+c.db.LLen(k)
+	}
+	fmt.Fprintf(c.out, "list: keys=%d elements=%d\n", n, elems)
+
+	n, elems = 0, 0
+	for _, k := range c.db.Keys(CaskDB.Hash) {
+		n, elems = n+1, elems+c.db.HLen(k)
+	}
+	fmt.Fprintf(c.out, "hash: keys=%d fields=%d\n", n, elems)
+
+	n, elems = 0, 0
+	for _, k := range c.db.Keys(CaskDB.Set) {
+		n, elems = n+1, elems+c.db.SCard(k)
+	}
+	fmt.Fprintf(c.out, "set:  keys=%d members=%d\n", n, elems)
+
+	n, elems = 0, 0
+	for _, k := range c.db.Keys(CaskDB.ZSet) {
+		n, elems = n+1, elems+c.db.ZCard(k)
+	}
+	fmt.Fprintf(c.out, "zset: keys=%d members=%d\n", n, elems)
+	return nil
+}
+
+func gc(c *cli, args [][]byte) error {
+	start := time.Now()
+	if err := c.db.GC(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "OK (%v)\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func help(c *cli, args [][]byte) error {
+	if len(args) > 0 {
+		cmd, ok := commands[strings.ToLower(string(args[0]))]
+		if !ok {
+			return ErrorUnknownCommand
+		}
+		fmt.Fprintln(c.out, cmd.usage)
+		return nil
+	}
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(c.out, commands[name].usage)
+	}
+	fmt.Fprintln(c.out, "exit")
+	return nil
+}
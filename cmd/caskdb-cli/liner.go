@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrorInterrupted = errors.New("[interrupted]")
+
+const MaxHistory = 1000
+
+// key codes in raw mode
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyTab       = 9
+	keyEnter     = 13
+	keyNewLine   = 10
+	keyEsc       = 27
+	keyBackspace = 127
+	keyCtrlH     = 8
+)
+
+// a small line editor with history and tab completion,
+// falls back to plain line reading when stdin is not a terminal
+type liner struct {
+	in       *bufio.Reader
+	out      *bufio.Writer
+	fd       int
+	raw      bool
+	history  []string
+	complete func(line string) []string
+}
+
+func newLiner(complete func(line string) []string) *liner {
+	fd := int(os.Stdin.Fd())
+	return &liner{
+		in:       bufio.NewReader(os.Stdin),
+		out:      bufio.NewWriter(os.Stdout),
+		fd:       fd,
+		raw:      isTerminal(fd),
+		complete: complete,
+	}
+}
+
+func (l *liner) addHistory(line string) {
+	if line == "" || (len(l.history) > 0 && l.history[len(l.history)-1] == line) {
+		return
+	}
+	l.history = append(l.history, line)
+	if len(l.history) > MaxHistory {
+		l.history = l.history[len(l.history)-MaxHistory:]
+	}
+}
+
+func (l *liner) loadHistory(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		l.addHistory(line)
+	}
+}
+
+func (l *liner) saveHistory(path string) error {
+	return os.WriteFile(path, []byte(strings.Join(l.history, "\n")+"\n"), 0644)
+}
+
+// read a line, io.EOF on ctrl-d, ErrorInterrupted on ctrl-c.
+// no prompt is shown when input is not a terminal, such as a pipe
+func (l *liner) prompt(p string) (string, error) {
+	if !l.raw {
+		line, err := l.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(l.fd)
+	if err != nil {
+		l.raw = false
+		return l.prompt(p)
+	}
+	defer restore(l.fd, state)
+	return l.edit(p)
+}
+
+func (l *liner) edit(p string) (string, error) {
+	var buf []rune
+	pos := 0
+	hpos := len(l.history) // position in history, len means the new line
+	saved := ""            // the new line when browsing history
+	tabbed := false        // tab twice to show candidates
+
+	refresh := func() {
+		l.out.WriteString("\r\x1b[K")
+		l.out.WriteString(p)
+		l.out.WriteString(string(buf))
+		if back := len(buf) - pos; back > 0 {
+			l.out.WriteString("\x1b[" + strconv.Itoa(back) + "D")
+		}
+		l.out.Flush()
+	}
+	refresh()
+
+	for {
+		r, _, err := l.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if r != keyTab {
+			tabbed = false
+		}
+
+		switch r {
+		case keyEnter, keyNewLine:
+			l.out.WriteString("\r\n")
+			l.out.Flush()
+			return string(buf), nil
+		case keyCtrlC:
+			l.out.WriteString("^C\r\n")
+			l.out.Flush()
+			return "", ErrorInterrupted
+		case keyCtrlD:
+			if len(buf) == 0 {
+				l.out.WriteString("\r\n")
+				l.out.Flush()
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(buf)
+		case keyCtrlK:
+			buf = buf[:pos]
+		case keyCtrlU:
+			buf = buf[pos:]
+			pos = 0
+		case keyCtrlW:
+			i := pos
+			for i > 0 && buf[i-1] == ' ' {
+				i--
+			}
+			for i > 0 && buf[i-1] != ' ' {
+				i--
+			}
+			buf = append(buf[:i], buf[pos:]...)
+			pos = i
+		case keyCtrlL:
+			l.out.WriteString("\x1b[H\x1b[2J")
+		case keyTab:
+			line := string(buf[:pos])
+			cands := l.complete(line)
+			if len(cands) == 0 {
+				break
+			}
+			prefix := commonPrefix(cands)
+			if len(prefix) > len(line) {
+				if len(cands) == 1 {
+					prefix += " "
+				}
+				buf = append([]rune(prefix), buf[pos:]...)
+				pos = len([]rune(prefix))
+				break
+			}
+			if tabbed {
+				l.out.WriteString("\r\n" + strings.Join(cands, "  ") + "\r\n")
+			}
+			tabbed = true
+		case keyEsc:
+			// arrow keys: ESC [ A/B/C/D
+			if b, _ := l.in.ReadByte(); b != '[' && b != 'O' {
+				break
+			}
+			b, _ := l.in.ReadByte()
+			switch b {
+			case 'A', 'B':
+				if hpos == len(l.history) {
+					saved = string(buf)
+				}
+				if b == 'A' && hpos > 0 {
+					hpos--
+				} else if b == 'B' && hpos < len(l.history) {
+					hpos++
+				} else {
+					break
+				}
+				if hpos == len(l.history) {
+					buf = []rune(saved)
+				} else {
+					buf = []rune(l.history[hpos])
+				}
+				pos = len(buf)
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case '3':
+				// delete: ESC [ 3 ~
+				l.in.ReadByte()
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				break
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		refresh()
+	}
+}
+
+func commonPrefix(ss []string) string {
+	prefix := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
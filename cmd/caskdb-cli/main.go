@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/k-si/CaskDB"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	dir      = flag.String("dir", CaskDB.DefaultDBDir, "directory of data files")
+	readonly = flag.Bool("readonly", false, "open db in read-only mode, which can share the dir with other readers")
+	history  = flag.String("history", defaultHistory(), "history file, empty means no history")
+)
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".caskdb_history")
+}
+
+// usage: caskdb-cli [flags] [command args...]
+// without command, an interactive shell is started
+func main() {
+	flag.Parse()
+
+	cfg := CaskDB.DefaultConfig()
+	cfg.DBDir = *dir
+	cfg.ReadOnly = *readonly
+	db, err := CaskDB.Open(cfg)
+	if err != nil {
+		log.Fatalln("[open db err]", err)
+	}
+	c := &cli{db: db, out: os.Stdout, readonly: *readonly}
+
+	if flag.NArg() > 0 {
+		var args [][]byte
+		for _, a := range flag.Args() {
+			args = append(args, []byte(a))
+		}
+		c.exec(args)
+	} else {
+		c.repl()
+	}
+
+	if err = db.Close(); err != nil {
+		log.Fatalln("[close db err]", err)
+	}
+}
+
+func (c *cli) repl() {
+	l := newLiner(complete)
+	if *history != "" {
+		l.loadHistory(*history)
+	}
+
+	p := *dir + "> "
+	if c.readonly {
+		p = *dir + "(readonly)> "
+	}
+	for {
+		line, err := l.prompt(p)
+		if err == ErrorInterrupted {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(c.out, err)
+			}
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		l.addHistory(line)
+		if line == "exit" || line == "quit" {
+			break
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			c.printErr(err)
+			continue
+		}
+		c.exec(args)
+	}
+
+	if *history != "" {
+		if err := l.saveHistory(*history); err != nil {
+			log.Println("[save history err]", err)
+		}
+	}
+}
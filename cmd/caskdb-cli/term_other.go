@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+type termState struct{}
+
+// line editing is not supported, lines are read as they are
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("[raw mode not supported]")
+}
+
+func restore(fd int, state *termState) error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/k-si/CaskDB"
+	"github.com/k-si/CaskDB/util"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	dir     = flag.String("dir", CaskDB.DefaultDBDir, "directory of data files")
+	verbose = flag.Bool("v", false, "print header of every entry")
+	repair  = flag.Bool("repair", false, "truncate corrupted files at the last good entry")
+	salvage = flag.Bool("salvage", false, "with -repair, copy all good entries to a new file instead of truncating")
+)
+
+var markNames = map[uint16]map[uint16]string{
+	CaskDB.Str: {
+		CaskDB.StrSet:    "set",
+		CaskDB.StrRemove: "remove",
+		CaskDB.StrExpire: "expire",
+	},
+	CaskDB.List: {
+		CaskDB.ListLPush:   "lpush",
+		CaskDB.ListLPop:    "lpop",
+		CaskDB.ListRPush:   "rpush",
+		CaskDB.ListRPop:    "rpop",
+		CaskDB.ListLInsert: "linsert",
+		CaskDB.ListRInsert: "rinsert",
+		CaskDB.ListLSet:    "lset",
+		CaskDB.ListLRem:    "lrem",
+		CaskDB.ListExpire:  "expire",
+		CaskDB.ListSeqSet:  "seqset",
+		CaskDB.ListSeqDel:  "seqdel",
+		CaskDB.ListClear:   "clear",
+	},
+	CaskDB.Hash: {
+		CaskDB.HashHSet:   "hset",
+		CaskDB.HashHDel:   "hdel",
+		CaskDB.HashExpire: "expire",
+		CaskDB.HashClear:  "clear",
+	},
+	CaskDB.Set: {
+		CaskDB.SetSAdd:   "sadd",
+		CaskDB.SetSRem:   "srem",
+		CaskDB.SetSMove:  "smove",
+		CaskDB.SetExpire: "expire",
+		CaskDB.SetClear:  "clear",
+	},
+	CaskDB.ZSet: {
+		CaskDB.ZSetZAdd:   "zadd",
+		CaskDB.ZSetZRem:   "zrem",
+		CaskDB.ZSetExpire: "expire",
+		CaskDB.ZSetClear:  "clear",
+	},
+}
+
+func markName(dataType, markType uint16) string {
+	switch markType {
+	case CaskDB.BatchBegin:
+		return "batch-begin"
+	case CaskDB.BatchCommit:
+		return "batch-commit"
+	}
+	if name, ok := markNames[dataType][markType]; ok {
+		return name
+	}
+	return strconv.Itoa(int(markType))
+}
+
+// usage: caskdb-fsck [-v] [-repair [-salvage]] -dir path
+// the dir is locked while checking, read-only readers are allowed unless repairing
+func main() {
+	flag.Parse()
+
+	cfg := CaskDB.DefaultConfig()
+	cfg.DBDir = *dir
+
+	lock, err := util.LockFile(filepath.Join(*dir, CaskDB.LockFileName), !*repair)
+	if err == util.ErrorLocked {
+		err = CaskDB.ErrorDBLocked
+	}
+	if err != nil {
+		log.Fatalln("[lock dir err]", err)
+	}
+	defer lock.Unlock()
+
+	checks, err := CaskDB.CheckFiles(cfg)
+	if err != nil {
+		log.Fatalln("[check files err]", err)
+	}
+
+	var entries, live, corrupted int
+	for _, fc := range checks {
+		entries += len(fc.Entries)
+		live += fc.Live
+		printCheck(fc)
+
+		if !fc.Corrupted() {
+			continue
+		}
+		if !*repair {
+			corrupted++
+			continue
+		}
+		if err := CaskDB.RepairFile(cfg, fc, *salvage); err != nil {
+			log.Println("[repair err]", fc.Path, err)
+			corrupted++
+			continue
+		}
+		if *salvage {
+			fmt.Printf("    repaired: %d good entries copied\n", len(fc.Entries))
+		} else {
+			fmt.Printf("    repaired: truncated at %d\n", fc.GoodEnd())
+		}
+	}
+
+	fmt.Printf("files=%d entries=%d live=%d corrupted=%d\n", len(checks), entries, live, corrupted)
+	if corrupted > 0 {
+		os.Exit(1)
+	}
+}
+
+func printCheck(fc *CaskDB.FileCheck) {
+	n := len(fc.Entries)
+	liveInfo := "live=unknown"
+	if fc.LiveKnown {
+		ratio := 0.0
+		if n > 0 {
+			ratio = float64(fc.Live) / float64(n) * 100
+		}
+		liveInfo = fmt.Sprintf("live=%d dead=%d live-ratio=%.1f%%", fc.Live, n-fc.Live, ratio)
+	}
+	fmt.Printf("%s format=v%d entries=%d %s end=%d size=%d\n", filepath.Base(fc.Path), fc.Version, n, liveInfo, fc.End, fc.Size)
+
+	for _, c := range fc.Corrupts {
+		fmt.Printf("    corrupted at %d, %d bytes: %v\n", c.Offset, c.Size, c.Err)
+	}
+
+	if !*verbose {
+		return
+	}
+	for _, h := range fc.Entries {
+		state := "dead"
+		if h.Live {
+			state = "live"
+		} else if !fc.LiveKnown {
+			state = ""
+		}
+		fmt.Printf("    @%d size=%d time=%s type=%s mark=%s key-offset=%d key=%q value-size=%d crc=%08x %s\n",
+			h.Offset, h.Size(), time.Unix(0, int64(h.Timestamp)).Format(time.RFC3339Nano),
+			CaskDB.FileNameSuffix[h.DataType], markName(h.DataType, h.MarkType),
+			h.KeyOffset, h.Key, h.ValueSize, h.Crc, state)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"github.com/k-si/CaskDB"
+	"github.com/k-si/CaskDB/server"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	addr        = flag.String("addr", ":6379", "address to listen on")
+	dir         = flag.String("dir", CaskDB.DefaultDBDir, "directory of data files")
+	maxFileSize = flag.Int64("max-file-size", CaskDB.DefaultMaxFileSize, "max size of a data file")
+	writeSync   = flag.Bool("sync", CaskDB.DefaultWriteSync, "sync data file after every write")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := CaskDB.DefaultConfig()
+	cfg.DBDir = *dir
+	cfg.MaxFileSize = *maxFileSize
+	cfg.WriteSync = *writeSync
+	cfg.RedisSDiff = true
+
+	db, err := CaskDB.Open(cfg)
+	if err != nil {
+		log.Fatalln("[open db err]", err)
+	}
+
+	s := server.New(db)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		s.Close()
+	}()
+
+	log.Println("[caskdb server listening]", *addr)
+	if err = s.ListenAndServe(*addr); err != nil && err != server.ErrorServerClosed {
+		log.Println("[serve err]", err)
+	}
+
+	if err = db.Close(); err != nil {
+		log.Fatalln("[close db err]", err)
+	}
+}
@@ -6,32 +6,55 @@ import (
 
 const (
 	DefaultDBDir         = "/tmp/CaskDB"
-	DefaultBackupDir     = "/tmp/caskdb-backup"
 	DefaultMaxKeySize    = 1 * 1024 * 1024  // 1mb
 	DefaultMaxValueSize  = 4 * 1024 * 1024  // 4mb
 	DefaultMaxFileSize   = 16 * 1024 * 1024 // 16mb
 	DefaultMergeInterval = 24 * time.Hour
 	DefaultWriteSync     = false
+	DefaultSweepInterval = time.Second
+	DefaultCRC32C        = false
+	DefaultReadOnly      = false
+	DefaultRedisSDiff    = false
+	DefaultMergeRatio    = 0.5
+	DefaultMergeCheck    = time.Minute
 )
 
 type Config struct {
 	DBDir         string        `json:"db_dir" yaml:"db_dir" toml:"db_dir"`
-	BackupDir     string        `json:"back_up_dir" yaml:"back_up_dir" toml:"back_up_dir"`
 	MaxKeySize    uint32        `json:"max_key_size" yaml:"max_key_size" toml:"max_key_size"`
 	MaxValueSize  uint32        `json:"max_val_size" yaml:"max_val_size" toml:"max_val_size"`
 	MaxFileSize   int64         `json:"max_file_size" yaml:"max_file_size" toml:"max_file_size"`
 	MergeInterval time.Duration `json:"gc_interval" yaml:"host" toml:"gc_interval"`
 	WriteSync     bool          `json:"sync_now" yaml:"sync_now" toml:"sync_now"`
+	SweepInterval time.Duration `json:"sweep_interval" yaml:"sweep_interval" toml:"sweep_interval"` // 0 means no background sweeper
+	CRC32C        bool          `json:"crc32c" yaml:"crc32c" toml:"crc32c"`                         // use crc32c in new files, faster with hardware support
+	ReadOnly      bool          `json:"read_only" yaml:"read_only" toml:"read_only"`                // never change the files, other read-only processes can share the dir
+	RedisSDiff    bool          `json:"redis_sdiff" yaml:"redis_sdiff" toml:"redis_sdiff"`          // SDiff returns members of the first set which are not in others like redis
+
+	// gc starts when a file has enough garbage, or there is too much garbage in total
+	MergeRatio         float64       `json:"gc_ratio" yaml:"gc_ratio" toml:"gc_ratio"`                            // a file is worth merging when its garbage ratio reaches it
+	MergeDeadBytes     int64         `json:"gc_dead_bytes" yaml:"gc_dead_bytes" toml:"gc_dead_bytes"`             // merge all files with garbage when total garbage reaches it, 0 means never
+	MergeCheckInterval time.Duration `json:"gc_check_interval" yaml:"gc_check_interval" toml:"gc_check_interval"` // how often to check garbage, 0 means only MergeInterval triggers gc
+
+	// not saved in config files
+	Logger    Logger      `json:"-" yaml:"-" toml:"-"` // nil means the standard logger
+	OnGCError func(error) `json:"-" yaml:"-" toml:"-"` // called when background gc fails, the db may be degraded by then
 }
 
 func DefaultConfig() Config {
 	return Config{
 		DBDir:         DefaultDBDir,
-		BackupDir:     DefaultBackupDir,
 		MaxKeySize:    DefaultMaxKeySize,
 		MaxValueSize:  DefaultMaxValueSize,
 		MaxFileSize:   DefaultMaxFileSize,
 		MergeInterval: DefaultMergeInterval,
 		WriteSync:     DefaultWriteSync,
+		SweepInterval: DefaultSweepInterval,
+		CRC32C:        DefaultCRC32C,
+		ReadOnly:      DefaultReadOnly,
+		RedisSDiff:    DefaultRedisSDiff,
+
+		MergeRatio:         DefaultMergeRatio,
+		MergeCheckInterval: DefaultMergeCheck,
 	}
 }
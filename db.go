@@ -6,12 +6,13 @@ import (
 	"errors"
 	"github.com/k-si/CaskDB/util"
 	"io/ioutil"
-	"log"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -29,17 +30,41 @@ var (
 	ErrorEmptyHeader    = errors.New("[read an empty entry header, maybe read 0]")
 	ErrorNilPointer     = errors.New("[nil variable]")
 	ErrorMSetParams     = errors.New("[MSet needs paired parameters]")
+	ErrorHintBroken     = errors.New("[hint file is broken]")
+	ErrorKeyNotExist    = errors.New("[key does not exist]")
+	ErrorBadEntry       = errors.New("[entry header is not reasonable]")
+	ErrorFileFormat     = errors.New("[unknown data file format]")
+	ErrorDBLocked       = errors.New("[db dir is used by another process]")
+	ErrorReadOnly       = errors.New("[db is opened in read-only mode]")
+	ErrorMergeOverflow  = errors.New("[merged files outnumber the old files]")
+	ErrorMergeBroken    = errors.New("[a committed merge is not finished, reopen db to recover it]")
+	ErrorDegraded       = errors.New("[db is degraded by a failed merge, reopen db to recover it]")
+	ErrorBadCursor      = errors.New("[scan cursor is not valid]")
+	ErrorTxnConflict    = errors.New("[watched keys are changed, transaction is aborted]")
+	ErrorTxnDone        = errors.New("[transaction is finished]")
+	ErrorTxnReadOnly    = errors.New("[write in a read-only transaction]")
+	ErrorSnapshotClosed = errors.New("[snapshot is closed]")
+	ErrorScoreNaN       = errors.New("[score is not a number]")
+	ErrorNoKeys         = errors.New("[at least one key is needed]")
+	ErrorBadWeights     = errors.New("[number of weights is not the number of keys]")
+	ErrorHashNotInt     = errors.New("[hash value is not an integer]")
+	ErrorHashNotFloat   = errors.New("[hash value is not a float]")
+	ErrorIncrOverflow   = errors.New("[increment or decrement would overflow]")
+	ErrorIncrNaN        = errors.New("[increment would produce NaN or Infinity]")
+	ErrorRenameOverflow = errors.New("[key is too large to rename in one file]")
 )
 
 const (
 	MergeDirName   = "merged"
 	ConfigFileName = "latest.cfg"
+	LockFileName   = "LOCK"
 	PathSeparator  = string(os.PathSeparator)
 	DataTypeNum    = 5
 )
 
 type DB struct {
-	config Config
+	batchId uint64 // id of the last batch, keep it 64-bit aligned for atomic
+	config  Config
 
 	activeFiles []*File            // activeFiles[dataType] = file
 	archedFiles []map[uint32]*File // archedFiles[dataType] = fileId -> file
@@ -48,37 +73,84 @@ type DB struct {
 	hashIndex   *HashIndex
 	setIndex    *SetIndex
 	zsetIndex   *ZSetIndex
+	lives       []*liveSet  // lives[dataType] = where live entries are
+	pending     []*manifest // merges not finished, only in read-only mode
+	gcStat      *gcStat     // progress of the running or last gc
+	health      *health
+	watch       *watchList // keys watched by transactions
+	snaps       *snapshots // open snapshots
 
 	isMerging  uint32 // 0: not merge 1: merging
 	isClosed   uint32 // 0: not close 1: closed
-	mergeChan  chan struct{}
+	mergeStop  uint32 // 1: running merge should stop
+	mergeMu    sync.Mutex
 	listenChan chan struct{}
+	expireChan chan struct{}
+	listenOnce sync.Once
+
+	dirLock *util.FileLock // held until Close, so no other process can open the dir
 }
 
 // get a DB instance
 func Open(config Config) (*DB, error) {
 
-	// check db path
-	if err := util.CheckAndMakeDir(config.DBDir); err != nil {
+	// check db path, read-only mode never creates it
+	if config.ReadOnly {
+		if _, err := os.Stat(config.DBDir); err != nil {
+			return nil, err
+		}
+	} else if err := util.CheckAndMakeDir(config.DBDir); err != nil {
 		return nil, err
 	}
 
-	// check merged path
-	if err := util.CheckAndMakeDir(config.DBDir + PathSeparator + MergeDirName); err != nil {
+	// only one process can write the dir, and no one reads it meanwhile
+	dirLock, err := util.LockFile(config.DBDir+PathSeparator+LockFileName, config.ReadOnly)
+	if err == util.ErrorLocked {
+		return nil, ErrorDBLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(config, dirLock)
+	if err != nil {
+		dirLock.Unlock()
 		return nil, err
 	}
+	return db, nil
+}
+
+func open(config Config, dirLock *util.FileLock) (*DB, error) {
+
+	// check merged path
+	if !config.ReadOnly {
+		if err := util.CheckAndMakeDir(config.DBDir + PathSeparator + MergeDirName); err != nil {
+			return nil, err
+		}
+	}
 
 	db := &DB{
+		batchId:    uint64(time.Now().UnixNano()),
 		config:     config,
 		strIndex:   NewStrIndex(),
 		listIndex:  NewListIndex(),
 		hashIndex:  NewHashIndex(),
 		setIndex:   NewSetIndex(),
 		zsetIndex:  NewZSetIndex(),
+		lives:      newLiveSets(),
+		gcStat:     &gcStat{},
+		health:     &health{},
+		watch:      newWatchList(),
+		snaps:      newSnapshots(),
+		dirLock:    dirLock,
 		isMerging:  0,
 		isClosed:   0,
-		mergeChan:  make(chan struct{}, DataTypeNum),
 		listenChan: make(chan struct{}),
+		expireChan: make(chan struct{}),
+	}
+
+	// a crash may interrupt merging
+	if err := db.recoverMerge(); err != nil {
+		return nil, err
 	}
 
 	// load db files fd from disk
@@ -96,7 +168,14 @@ func Open(config Config) (*DB, error) {
 	}
 
 	// start timed merge goroutine
-	go db.listeningGC()
+	if !db.config.ReadOnly {
+		go db.listeningGC()
+	}
+
+	// start expired keys sweeper
+	if db.config.SweepInterval > 0 {
+		go db.listeningExpire()
+	}
 
 	return db, nil
 }
@@ -104,38 +183,47 @@ func Open(config Config) (*DB, error) {
 func (db *DB) Close() error {
 
 	// check status
-	if atomic.LoadUint32(&db.isClosed) == 1 {
+	if !atomic.CompareAndSwapUint32(&db.isClosed, 0, 1) {
 		return ErrorClosedDB
 	}
-	if atomic.LoadUint32(&db.isMerging) == 1 {
-		if err := db.StopGC(); err != nil {
-			return err
-		}
-	} else {
-		db.listenChan <- struct{}{}
-		return nil
+	if db.config.SweepInterval > 0 {
+		db.expireChan <- struct{}{}
+	}
+	db.stopMerge()
+	db.stopListen()
+
+	// wait for merging to stop
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	// wait for running operations and gc
+	for _, dt := range lockOrder {
+		db.lockerOf(dt).Lock()
+		defer db.lockerOf(dt).Unlock()
 	}
 
 	// save configuration
-	if err := db.saveConfig(); err != nil {
-		return err
+	if !db.config.ReadOnly {
+		if err := db.saveConfig(); err != nil {
+			return err
+		}
 	}
 
 	// close fd
+	flush := !db.config.ReadOnly
 	for i := 0; i < DataTypeNum; i++ {
-		if err := db.activeFiles[i].Close(true); err != nil {
+		if err := db.activeFiles[i].Close(flush); err != nil {
 			return err
 		}
 		for _, f := range db.archedFiles[i] {
-			if err := f.Close(true); err != nil {
+			if err := f.Close(flush); err != nil {
 				return err
 			}
 		}
 	}
 
-	atomic.StoreUint32(&db.isClosed, 1)
-
-	return nil
+	// let other processes open the dir
+	return db.dirLock.Unlock()
 }
 
 // save configuration
@@ -161,14 +249,15 @@ func (db *DB) loadFiles() ([]*File, []map[uint32]*File, map[int][]int, error) {
 	for i := 0; i < DataTypeNum; i++ {
 
 		// sort ids, maximum id of active file
-		sort.Ints(dataTypeIds[i])
-		ids := dataTypeIds[i]
+		ids := db.pendingIds(uint16(i), dataTypeIds[i])
+		sort.Ints(ids)
+		dataTypeIds[i] = ids
 
 		archedFiles[i] = make(map[uint32]*File)
 
 		// 0th - (n-2)th is arched file id
 		for j := 0; j < len(ids)-1; j++ {
-			f, err := NewFile(db.config.DBDir, uint32(ids[j]), uint16(i), db.config.MaxFileSize)
+			f, err := db.openFile(uint32(ids[j]), uint16(i))
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -181,9 +270,9 @@ func (db *DB) loadFiles() ([]*File, []map[uint32]*File, map[int][]int, error) {
 			id = uint32(ids[len(ids)-1])
 		}
 
-		f, err := NewFile(db.config.DBDir, id, uint16(i), db.config.MaxFileSize)
+		f, err := db.openFile(id, uint16(i))
 		if err != nil {
-			log.Println("[NewFile err]", err)
+			db.logger().Println("[NewFile err]", err)
 			return nil, nil, nil, err
 		}
 		activeFiles[i] = f
@@ -227,33 +316,47 @@ func loadFilesId(dir string) (map[int][]int, error) {
 	return dataTypeIds, nil
 }
 
+func (db *DB) openFile(fileId uint32, dataType uint16) (*File, error) {
+	if db.config.ReadOnly {
+		return NewReadOnlyFile(db.fileDir(dataType, fileId), fileId, dataType)
+	}
+	return NewFile(db.config.DBDir, fileId, dataType, db.config.MaxFileSize, db.checksum())
+}
+
+// checksum of new files
+func (db *DB) checksum() uint8 {
+	if db.config.CRC32C {
+		return ChecksumCastagnoli
+	}
+	return ChecksumIEEE
+}
+
 // write entry to disk
 func (db *DB) StoreFile(e *Entry) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	f := db.activeFiles[e.GetDataType()]
 
 	// check active file size
 	if f.offset+int64(e.Size()) > db.config.MaxFileSize {
-
-		// flush current active file to disk
-		if err := f.Sync(); err != nil {
+		if err := db.rotateFile(e.GetDataType()); err != nil {
 			return err
 		}
-
-		// create new file as active file
-		newId := f.id + 1
-		newf, err := NewFile(db.config.DBDir, newId, e.GetDataType(), db.config.MaxFileSize)
-		if err != nil {
-			return err
-		}
-		db.archedFiles[e.GetDataType()][f.id] = f
-		db.activeFiles[e.GetDataType()] = newf
-		f = newf
+		f = db.activeFiles[e.GetDataType()]
 	}
 
 	// write entry in active file
 	if err := f.Write(e); err != nil {
 		return err
 	}
+	db.watch.touch(entryKeys(e)...)
+	db.snaps.save(e.GetDataType(), entryKeys(e)...)
+	db.track(e, &Index{
+		fileId: f.id,
+		offset: f.offset - int64(e.Size()),
+		size:   e.Size(),
+	})
 
 	// sync buffer with disk
 	if db.config.WriteSync {
@@ -265,20 +368,32 @@ func (db *DB) StoreFile(e *Entry) error {
 	return nil
 }
 
-func (db *DB) FilesBackup() error {
-	if err := util.CheckAndMakeDir(db.config.BackupDir); err != nil {
+// turn the active file into arched file, and create a new active file
+func (db *DB) rotateFile(dataType uint16) error {
+	f := db.activeFiles[dataType]
+
+	// flush current active file to disk
+	if err := f.Sync(); err != nil {
 		return err
 	}
-	if err := util.CopyDir(db.config.DBDir, db.config.BackupDir); err != nil {
+
+	// create new file as active file
+	newId := f.id + 1
+	newf, err := NewFile(db.config.DBDir, newId, dataType, db.config.MaxFileSize, db.checksum())
+	if err != nil {
 		return err
 	}
-	return nil
-}
+	db.archedFiles[dataType][f.id] = f
+	db.activeFiles[dataType] = newf
 
-func (db *DB) FilesRollback() error {
-	if err := util.CopyDir(db.config.BackupDir, db.config.DBDir); err != nil {
-		return err
+	// the file will never change, so it is time to write its hint,
+	// a missing hint only makes the next Open slower
+	if _, ok := HintNameFormat[dataType]; ok {
+		if err := db.writeHintFile(f, dataType); err != nil {
+			db.logger().Println("[write hint err]", err)
+		}
 	}
+
 	return nil
 }
 
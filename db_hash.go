@@ -2,18 +2,23 @@ package CaskDB
 
 import (
 	"github.com/k-si/CaskDB/ds"
+	"github.com/k-si/CaskDB/util"
+	"math"
+	"strconv"
 	"sync"
 )
 
 type HashIndex struct {
 	mu  *sync.RWMutex
 	idx *ds.Hash
+	exp expires
 }
 
 func NewHashIndex() *HashIndex {
 	return &HashIndex{
 		mu:  &sync.RWMutex{},
 		idx: ds.NewHash(),
+		exp: make(expires),
 	}
 }
 
@@ -31,6 +36,8 @@ func (db *DB) HSet(key, k, v []byte) error {
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	db.expireIfNeeded(Hash, key)
+
 	if err := db.hSetVal(key, k, v); err != nil {
 		return err
 	}
@@ -66,15 +73,22 @@ func (db *DB) HGet(key, k []byte) ([]byte, error) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
 	v := db.hashIndex.idx.Get(string(key), string(k))
 
 	return v, nil
 }
 
-func (db *DB) HDel(key, k []byte) error {
+// remove fields of key, fields which do not exist are ignored
+func (db *DB) HDel(key []byte, fields ...[]byte) error {
 
 	// check size
-	if err := db.checkKeysSize(key, k); err != nil {
+	if err := db.checkKeySize(key); err != nil {
+		return err
+	}
+	if err := db.checkKeysSize(fields...); err != nil {
 		return err
 	}
 
@@ -82,8 +96,15 @@ func (db *DB) HDel(key, k []byte) error {
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
-	if err := db.hDelVal(key, k); err != nil {
-		return err
+	db.expireIfNeeded(Hash, key)
+
+	for _, k := range fields {
+		if !db.hashIndex.idx.FieldExist(string(key), string(k)) {
+			continue
+		}
+		if err := db.hDelVal(key, k); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -100,6 +121,7 @@ func (db *DB) hDelVal(key, k []byte) error {
 
 	// delete from index
 	db.hashIndex.idx.Remove(string(key), string(k))
+	db.forgetEmpty(Hash, string(key))
 	return nil
 }
 
@@ -117,6 +139,8 @@ func (db *DB) HSetNx(key, k, v []byte) error {
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	db.expireIfNeeded(Hash, key)
+
 	val := db.hashIndex.idx.Get(string(key), string(k))
 	if val == nil {
 		if err := db.hSetVal(key, k, v); err != nil {
@@ -126,6 +150,116 @@ func (db *DB) HSetNx(key, k, v []byte) error {
 	return nil
 }
 
+// set field value pairs of key in a batch
+func (db *DB) HMSet(key []byte, pairs ...[]byte) error {
+
+	// check
+	if pairs == nil {
+		return ErrorNilPointer
+	}
+	if len(pairs)%2 != 0 {
+		return ErrorMSetParams
+	}
+
+	// write in a batch, so that no half HMSet will be seen after crash
+	wb := db.NewWriteBatch()
+	for i := 0; i < len(pairs); i += 2 {
+		wb.HSet(key, pairs[i], pairs[i+1])
+	}
+
+	return wb.Commit()
+}
+
+// values of fields, nil for fields which do not exist
+func (db *DB) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+	if err := db.checkKeysSize(fields...); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	res := make([][]byte, len(fields))
+	if db.hashIndex.exp.expired(string(key)) {
+		return res, nil
+	}
+	for i, k := range fields {
+		res[i] = db.hashIndex.idx.Get(string(key), string(k))
+	}
+	return res, nil
+}
+
+// add incr to the integer value of field, a new field starts from 0
+func (db *DB) HIncrBy(key, k []byte, incr int64) (int64, error) {
+
+	// check size
+	if err := db.checkKeysSize(key, k); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	db.expireIfNeeded(Hash, key)
+
+	var n int64
+	if v := db.hashIndex.idx.Get(string(key), string(k)); v != nil {
+		var err error
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			return 0, ErrorHashNotInt
+		}
+	}
+	if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+		return 0, ErrorIncrOverflow
+	}
+	n += incr
+
+	if err := db.hSetVal(key, k, []byte(strconv.FormatInt(n, 10))); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// add incr to the float value of field, a new field starts from 0
+func (db *DB) HIncrByFloat(key, k []byte, incr float64) (float64, error) {
+
+	// check size
+	if err := db.checkKeysSize(key, k); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	db.expireIfNeeded(Hash, key)
+
+	var f float64
+	if v := db.hashIndex.idx.Get(string(key), string(k)); v != nil {
+		var err error
+		if f, err = strconv.ParseFloat(string(v), 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, ErrorHashNotFloat
+		}
+	}
+	f += incr
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrorIncrNaN
+	}
+
+	if err := db.hSetVal(key, k, []byte(strconv.FormatFloat(f, 'f', -1, 64))); err != nil {
+		return 0, err
+	}
+	return f, nil
+}
+
+// fields and values in pairs, HGetAllMap tells them apart
 func (db *DB) HGetAll(key []byte) ([][]byte, error) {
 
 	// check size
@@ -137,18 +271,123 @@ func (db *DB) HGetAll(key []byte) ([][]byte, error) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
 	res := db.hashIndex.idx.GetAll(string(key))
 	return res, nil
 }
 
+// fields and values of key in a map
+func (db *DB) HGetAllMap(key []byte) (map[string][]byte, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	return db.hashIndex.idx.Map(string(key)), nil
+}
+
+func (db *DB) HKeys(key []byte) ([][]byte, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	var res [][]byte
+	for _, f := range db.hashIndex.idx.Fields(string(key)) {
+		res = append(res, []byte(f))
+	}
+	return res, nil
+}
+
+func (db *DB) HVals(key []byte) ([][]byte, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	return db.hashIndex.idx.Values(string(key)), nil
+}
+
+// a page of fields and values from cursor like Scan,
+// cursor is nil at first, and nil is returned after the last page
+func (db *DB) HScan(key, cursor []byte, match string, count int) ([][]byte, []byte, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, nil, err
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	// lock
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.hashIndex.exp.expired(string(key)) {
+		return nil, nil, nil
+	}
+	fields, next := scanPage(db.hashIndex.idx.FieldsFrom(string(key), string(cursor), count+1), count)
+
+	var res [][]byte
+	for _, f := range fields {
+		if match == "" || util.GlobMatch(match, f) {
+			res = append(res, []byte(f), db.hashIndex.idx.Get(string(key), f))
+		}
+	}
+	return res, next, nil
+}
+
 func (db *DB) HExist(key, k []byte) bool {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
+	if db.hashIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.hashIndex.idx.FieldExist(string(key), string(k))
 }
 
 func (db *DB) HLen(key []byte) int {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
+	if db.hashIndex.exp.expired(string(key)) {
+		return 0
+	}
 	return db.hashIndex.idx.Len(string(key))
 }
+
+// length of the value of field, 0 if it does not exist
+func (db *DB) HStrLen(key, k []byte) int {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+	if db.hashIndex.exp.expired(string(key)) {
+		return 0
+	}
+	return db.hashIndex.idx.StrLen(string(key), string(k))
+}
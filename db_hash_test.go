@@ -3,7 +3,9 @@ package CaskDB
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -124,3 +126,125 @@ func TestDB_HExist(t *testing.T) {
 	err = db.Close()
 	assert.Nil(t, err)
 }
+
+func TestDB_HScan(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	k := []byte("k")
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, db.HSet(k, []byte(fmt.Sprintf("f%02d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+
+	res, cursor, err := db.HScan(k, nil, "", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(res))
+	assert.Equal(t, []byte("f00"), res[0])
+	assert.Equal(t, []byte("v0"), res[1])
+	assert.Equal(t, []byte("f05"), cursor)
+
+	res, cursor, err = db.HScan(k, cursor, "f1*", 10)
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Equal(t, [][]byte{[]byte("f10"), []byte("v10"), []byte("f11"), []byte("v11")}, res)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_HMSet_HMGet(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	key := []byte("key")
+	assert.Equal(t, ErrorMSetParams, db.HMSet(key, []byte("k1")))
+	assert.Nil(t, db.HMSet(key, []byte("k1"), []byte("v1"), []byte("k2"), []byte("value2")))
+
+	vs, err := db.HMGet(key, []byte("k1"), []byte("k3"), []byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), nil, []byte("value2")}, vs)
+
+	m, err := db.HGetAllMap(key)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"k1": []byte("v1"), "k2": []byte("value2")}, m)
+
+	fields, err := db.HKeys(key)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("k1"), []byte("k2")}, fields)
+	vals, err := db.HVals(key)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("v1"), []byte("value2")}, vals)
+
+	assert.Equal(t, 6, db.HStrLen(key, []byte("k2")))
+	assert.Equal(t, 0, db.HStrLen(key, []byte("k3")))
+
+	assert.Nil(t, db.HDel(key, []byte("k1"), []byte("k2"), []byte("k3")))
+	assert.Equal(t, 0, db.HLen(key))
+	assert.Empty(t, db.Type(key))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_HIncrBy(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	key, k, f := []byte("key"), []byte("n"), []byte("f")
+	for i := 0; i < 10; i++ {
+		_, err = db.HIncrBy(key, k, 2)
+		assert.Nil(t, err)
+	}
+	n, err := db.HIncrBy(key, k, -5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), n)
+
+	_, err = db.HIncrBy(key, k, math.MaxInt64)
+	assert.Equal(t, ErrorIncrOverflow, err)
+
+	x, err := db.HIncrByFloat(key, f, 10.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.5, x)
+	x, err = db.HIncrByFloat(key, f, -0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.25, x)
+	_, err = db.HIncrByFloat(key, f, math.Inf(1))
+	assert.Equal(t, ErrorIncrNaN, err)
+	_, err = db.HIncrByFloat(key, []byte("small"), 0.1)
+	assert.Nil(t, err)
+	small, err := db.HGet(key, []byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, "0.1", string(small))
+	_, err = db.HIncrByFloat(key, []byte("big"), 1e21)
+	assert.Nil(t, err)
+	big, err := db.HGet(key, []byte("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1"+strings.Repeat("0", 21), string(big))
+
+	_, err = db.HIncrBy(key, f, 1)
+	assert.Equal(t, ErrorHashNotInt, err)
+	assert.Nil(t, db.HSet(key, []byte("s"), []byte("abc")))
+	_, err = db.HIncrByFloat(key, []byte("s"), 1)
+	assert.Equal(t, ErrorHashNotFloat, err)
+
+	// old values are merged away, the last ones stay
+	assert.Nil(t, db.GC())
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+
+	v, err := db.HGet(key, k)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("15"), v)
+	v, err = db.HGet(key, f)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10.25"), v)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
@@ -1,8 +1,22 @@
 package CaskDB
 
+import (
+	"bytes"
+	"github.com/k-si/CaskDB/ds"
+	"github.com/k-si/CaskDB/util"
+	"sort"
+	"sync/atomic"
+)
+
+// keys visited by a scan if count is not given
+const DefaultScanCount = 10
+
 func (db *DB) StrKeyExist(key []byte) bool {
 	db.strIndex.mu.RLock()
 	defer db.strIndex.mu.RUnlock()
+	if db.strIndex.exp.expired(string(key)) {
+		return false
+	}
 	v := db.strIndex.idx.Get(key)
 	if v == nil {
 		return false
@@ -13,23 +27,469 @@ func (db *DB) StrKeyExist(key []byte) bool {
 func (db *DB) HKeyExist(key []byte) bool {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
+	if db.hashIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.hashIndex.idx.KeyExist(string(key))
 }
 
 func (db *DB) LKeyExist(key []byte) bool {
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
+	if db.listIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.listIndex.idx.KeyExist(string(key))
 }
 
 func (db *DB) SKeyExist(key []byte) bool {
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
+	if db.setIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.setIndex.idx.KeyExist(string(key))
 }
 
 func (db *DB) ZKeyExist(key []byte) bool {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.zsetIndex.idx.KeyExist(string(key))
 }
+
+// all keys of a data type in order, expired keys are not included
+func (db *DB) Keys(dataType uint16) [][]byte {
+	mu := db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	keys := db.allKeys(dataType)
+	sort.Strings(keys)
+
+	exp := db.expiresOf(dataType)
+	var res [][]byte
+	for _, k := range keys {
+		if !exp.expired(k) {
+			res = append(res, []byte(k))
+		}
+	}
+	return res
+}
+
+// a page of keys from cursor, keys of str, list, hash, set and zset are
+// walked in turn, and in order in each type. cursor is nil at first, and the
+// returned cursor is nil after the last page. count keys are visited at most,
+// keys not matching the glob pattern are skipped, empty pattern matches all.
+// only keys of types are walked if types are given. a key which is there
+// during the whole scan is returned once, the lock is only held in a page
+func (db *DB) Scan(cursor []byte, match string, count int, types ...uint16) ([][]byte, []byte, error) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	// cursor is the data type and the key to go on with
+	dt, from := uint16(0), ""
+	if len(cursor) > 0 {
+		if cursor[0] >= DataTypeNum {
+			return nil, nil, ErrorBadCursor
+		}
+		dt, from = uint16(cursor[0]), string(cursor[1:])
+	}
+
+	wanted := func(dt uint16) bool {
+		if len(types) == 0 {
+			return true
+		}
+		for _, t := range types {
+			if t == dt {
+				return true
+			}
+		}
+		return false
+	}
+
+	var res [][]byte
+	for ; dt < DataTypeNum; dt, from = dt+1, "" {
+		if !wanted(dt) {
+			continue
+		}
+		if count == 0 {
+			return res, []byte{byte(dt)}, nil
+		}
+
+		keys, visited, next := db.keysFrom(dt, from, count)
+		for _, k := range keys {
+			if match == "" || util.GlobMatch(match, k) {
+				res = append(res, []byte(k))
+			}
+		}
+		if next != "" {
+			return res, append([]byte{byte(dt)}, next...), nil
+		}
+		count -= visited
+	}
+	return res, nil, nil
+}
+
+// n keys of a data type not less than from, expired keys are visited but
+// not returned. next is the key after them, empty if there is none
+func (db *DB) keysFrom(dataType uint16, from string, n int) (keys []string, visited int, next string) {
+	mu := db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var all []string
+	switch dataType {
+	case Str:
+		k := []byte(from)
+		for strict := false; len(all) <= n; strict = true {
+			if k, _ = db.strIndex.idx.Ceiling(k, strict); k == nil {
+				break
+			}
+			all = append(all, string(k))
+		}
+	case List:
+		all = db.listIndex.idx.KeysFrom(from, n+1)
+	case Hash:
+		all = db.hashIndex.idx.KeysFrom(from, n+1)
+	case Set:
+		all = db.setIndex.idx.KeysFrom(from, n+1)
+	case ZSet:
+		all = db.zsetIndex.idx.KeysFrom(from, n+1)
+	}
+	if len(all) > n {
+		next = all[n]
+		all = all[:n]
+	}
+
+	exp := db.expiresOf(dataType)
+	for _, k := range all {
+		if !exp.expired(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, len(all), next
+}
+
+// a page of members from cursor, cursor is the member to go on with
+func scanPage(members []string, count int) ([]string, []byte) {
+	if len(members) > count {
+		return members[:count], []byte(members[count])
+	}
+	return members, nil
+}
+
+// lock all data types in order, the returned function unlocks them
+func (db *DB) lockAll() func() {
+	for _, dt := range lockOrder {
+		db.lockerOf(dt).Lock()
+	}
+	return func() {
+		for _, dt := range lockOrder {
+			db.lockerOf(dt).Unlock()
+		}
+	}
+}
+
+// key is in the index of dataType and not expired,
+// caller must hold the lock of dataType
+func (db *DB) keyExist(dataType uint16, key string) bool {
+	return db.keyInIndex(dataType, key) && !db.expiresOf(dataType).expired(key)
+}
+
+// data types that key exists in. keys of different types are apart,
+// so a key may be in more than one type
+func (db *DB) Type(key []byte) []uint16 {
+	var types []uint16
+	for i := uint16(0); i < DataTypeNum; i++ {
+		mu := db.lockerOf(i)
+		mu.RLock()
+		if db.keyExist(i, string(key)) {
+			types = append(types, i)
+		}
+		mu.RUnlock()
+	}
+	return types
+}
+
+// number of keys that exist in any data type, a key is counted
+// as many times as it is given
+func (db *DB) Exists(keys ...[]byte) int {
+	n := 0
+	for _, k := range keys {
+		if len(db.Type(k)) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// number of keys of all data types
+func (db *DB) DBSize() int {
+	n := 0
+	for i := uint16(0); i < DataTypeNum; i++ {
+		mu := db.lockerOf(i)
+		mu.RLock()
+		switch i {
+		case Str:
+			n += db.strIndex.idx.Size()
+		case List:
+			n += db.listIndex.idx.KeyNum()
+		case Hash:
+			n += db.hashIndex.idx.KeyNum()
+		case Set:
+			n += db.setIndex.idx.KeyNum()
+		case ZSet:
+			n += db.zsetIndex.idx.KeyNum()
+		}
+		n -= db.expiresOf(i).expiredNum()
+		mu.RUnlock()
+	}
+	return n
+}
+
+// remove keys of all data types, a tombstone is written for every key
+// in every type it is in. all of them are written in a batch.
+// the number of removed keys is returned
+func (db *DB) Del(keys ...[]byte) (int, error) {
+	if err := db.checkKeysSize(keys...); err != nil {
+		return 0, err
+	}
+	if err := db.writable(); err != nil {
+		return 0, err
+	}
+	defer db.lockAll()()
+
+	wb := db.NewWriteBatch()
+	removed := make(map[string]bool)
+	for i := uint16(0); i < DataTypeNum; i++ {
+		db.expireIfNeeded(i, keys...)
+		for _, k := range keys {
+			if db.keyInIndex(i, string(k)) {
+				wb.put(NewEntry(k, nil, i, clearMarks[i], 0))
+				removed[string(k)] = true
+			}
+		}
+	}
+	if err := wb.write(); err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// rename src to dst in all data types that src is in, dst is removed
+// from all types first. it is written in a batch, so the data of src
+// must fit in one file
+func (db *DB) Rename(src, dst []byte) error {
+	_, err := db.rename(src, dst, false)
+	return err
+}
+
+// rename src to dst only if dst does not exist in any data type
+func (db *DB) RenameNx(src, dst []byte) (bool, error) {
+	return db.rename(src, dst, true)
+}
+
+func (db *DB) rename(src, dst []byte, nx bool) (bool, error) {
+	if err := db.checkKeysSize(src, dst); err != nil {
+		return false, err
+	}
+	if err := db.writable(); err != nil {
+		return false, err
+	}
+	defer db.lockAll()()
+
+	var from []uint16
+	dstExist := false
+	for i := uint16(0); i < DataTypeNum; i++ {
+		db.expireIfNeeded(i, src, dst)
+		if db.keyInIndex(i, string(src)) {
+			from = append(from, i)
+		}
+		dstExist = dstExist || db.keyInIndex(i, string(dst))
+	}
+	if len(from) == 0 {
+		return false, ErrorKeyNotExist
+	}
+	if bytes.Equal(src, dst) {
+		return !nx, nil
+	}
+	if nx && dstExist {
+		return false, nil
+	}
+
+	wb := db.NewWriteBatch()
+	for i := uint16(0); i < DataTypeNum; i++ {
+		if db.keyInIndex(i, string(dst)) {
+			wb.put(NewEntry(dst, nil, i, clearMarks[i], 0))
+		}
+	}
+	for _, dt := range from {
+		entries, err := db.copyEntries(dt, src, dst)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range entries {
+			wb.put(e)
+		}
+		wb.put(NewEntry(src, nil, dt, clearMarks[dt], 0))
+	}
+
+	// the move is one batch, it must fit in one file of every type
+	if !wb.fits() {
+		return false, ErrorRenameOverflow
+	}
+	if err := wb.write(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// entries that write the data and the deadline of src to dst,
+// caller must hold the lock of dataType
+func (db *DB) copyEntries(dataType uint16, src, dst []byte) ([]*Entry, error) {
+	var entries []*Entry
+	k := string(src)
+	switch dataType {
+	case Str:
+		v, err := db.readValue(Str, db.strIndex.idx.Get(src).(*Index))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, NewEntry(dst, v, Str, StrSet, 0))
+	case List:
+		for _, el := range db.listIndex.idx.Range(k, 0, -1) {
+			v, err := db.elemValue(el)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, NewEntry(db.splice(dst, []byte(el.Seq)), v, List, ListSeqSet, uint32(len(dst))))
+		}
+	case Hash:
+		all := db.hashIndex.idx.GetAll(k)
+		for i := 0; i < len(all); i += 2 {
+			entries = append(entries, NewEntry(db.splice(dst, all[i]), all[i+1], Hash, HashHSet, uint32(len(dst))))
+		}
+	case Set:
+		for _, m := range db.setIndex.idx.Scan(k) {
+			entries = append(entries, NewEntry(dst, m, Set, SetSAdd, 0))
+		}
+	case ZSet:
+		all := db.zsetIndex.idx.Top(k, db.zsetIndex.idx.GetCard(k))
+		for i := 0; i < len(all); i += 2 {
+			keys := db.splice(dst, util.Float64ToBytes(all[i+1].(float64)))
+			entries = append(entries, NewEntry(keys, []byte(all[i].(string)), ZSet, ZSetZAdd, uint32(len(dst))))
+		}
+	}
+
+	if d, ok := db.expiresOf(dataType)[k]; ok {
+		entries = append(entries, NewEntry(dst, util.IntToBytes(int(d)), dataType, expireMarks[dataType], 0))
+	}
+	return entries, nil
+}
+
+// remove all keys. all data files are replaced with empty files, removing
+// them is committed by manifests like merging, so it is finished on Open
+// if it is broken off by a crash
+func (db *DB) FlushDB() error {
+	if atomic.LoadUint32(&db.isClosed) == 1 {
+		return ErrorClosedDB
+	}
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	// stop merging, and nothing is written meanwhile
+	db.stopMerge()
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	defer db.lockAll()()
+
+	mergePath := db.config.DBDir + PathSeparator + MergeDirName
+	if err := util.CheckAndMakeDir(mergePath); err != nil {
+		return err
+	}
+
+	// open snapshots keep the data in memory
+	if err := db.snaps.detach(); err != nil {
+		return err
+	}
+
+	mans := make([]*manifest, DataTypeNum)
+	for i := uint16(0); i < DataTypeNum; i++ {
+		man := &manifest{DataType: i}
+		for id := range db.archedFiles[i] {
+			man.Old = append(man.Old, id)
+		}
+		man.Old = append(man.Old, db.activeFiles[i].id)
+		if err := man.write(mergePath); err != nil {
+			if i > 0 {
+				db.degrade(err)
+			}
+			return err
+		}
+		mans[i] = man
+	}
+
+	// every watched key is changed
+	db.watch.touchAll()
+	for i := uint16(0); i < DataTypeNum; i++ {
+		if err := db.flushFiles(mans[i]); err != nil {
+			db.degrade(err)
+			return err
+		}
+		db.resetIndex(i)
+	}
+	return nil
+}
+
+// remove the files of a manifest, and open a new active file
+func (db *DB) flushFiles(man *manifest) error {
+	dt := man.DataType
+	last := db.activeFiles[dt].id
+	for _, f := range db.archedFiles[dt] {
+		if err := f.Close(false); err != nil {
+			return err
+		}
+	}
+	if err := db.activeFiles[dt].Close(false); err != nil {
+		return err
+	}
+	db.archedFiles[dt] = make(map[uint32]*File)
+
+	if err := man.apply(db.config.DBDir, db.config.DBDir+PathSeparator+MergeDirName); err != nil {
+		return err
+	}
+	f, err := NewFile(db.config.DBDir, last+1, dt, db.config.MaxFileSize, db.checksum())
+	if err != nil {
+		return err
+	}
+	db.activeFiles[dt] = f
+	return nil
+}
+
+// empty the index of dataType, caller must hold the write lock
+func (db *DB) resetIndex(dataType uint16) {
+	switch dataType {
+	case Str:
+		db.strIndex.idx = ds.NewAVLTree()
+		db.strIndex.exp = make(expires)
+	case List:
+		db.listIndex.idx = ds.NewSeqList()
+		db.listIndex.legacy = ds.NewList()
+		db.listIndex.exp = make(expires)
+	case Hash:
+		db.hashIndex.idx = ds.NewHash()
+		db.hashIndex.exp = make(expires)
+	case Set:
+		db.setIndex.idx = ds.NewSet()
+		db.setIndex.exp = make(expires)
+	case ZSet:
+		db.zsetIndex.idx = ds.NewSortedSet()
+		db.zsetIndex.exp = make(expires)
+	}
+	db.lives[dataType] = newLiveSet()
+}
@@ -0,0 +1,259 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Keys(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Set([]byte("b"), []byte("1")))
+	assert.Nil(t, db.Set([]byte("a"), []byte("1")))
+	assert.Nil(t, db.SetEx([]byte("c"), []byte("1"), time.Millisecond))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Nil(t, db.ZAdd([]byte("z2"), 1, []byte("m")))
+	assert.Nil(t, db.ZAdd([]byte("z1"), 1, []byte("m")))
+	time.Sleep(2 * time.Millisecond)
+
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, db.Keys(Str))
+	assert.Equal(t, [][]byte{[]byte("h")}, db.Keys(Hash))
+	assert.Equal(t, [][]byte{[]byte("z1"), []byte("z2")}, db.Keys(ZSet))
+	assert.Nil(t, db.Keys(List))
+	assert.Nil(t, db.Keys(Set))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Scan(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("user:%02d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.Set([]byte("order:1"), []byte("v")))
+	assert.Nil(t, db.SetEx([]byte("user:99"), []byte("v"), time.Millisecond))
+	assert.Nil(t, db.LPush([]byte("user:list"), []byte("v")))
+	assert.Nil(t, db.HSet([]byte("user:hash"), []byte("f"), []byte("v")))
+	assert.Nil(t, db.SAdd([]byte("set"), []byte("m")))
+	assert.Nil(t, db.ZAdd([]byte("user:zset"), 1, []byte("m")))
+	time.Sleep(2 * time.Millisecond)
+
+	scanAll := func(match string, count int, types ...uint16) (res []string, pages int) {
+		var cursor []byte
+		for {
+			keys, next, err := db.Scan(cursor, match, count, types...)
+			assert.Nil(t, err)
+			for _, k := range keys {
+				res = append(res, string(k))
+			}
+			pages++
+			if next == nil {
+				return
+			}
+			cursor = next
+		}
+	}
+
+	keys, pages := scanAll("", 10)
+	assert.Equal(t, 30, len(keys))
+	assert.Equal(t, 4, pages)
+	assert.Equal(t, "order:1", keys[0])
+	assert.Equal(t, []string{"user:list", "user:hash", "set", "user:zset"}, keys[26:])
+
+	keys, _ = scanAll("user:1?", 7)
+	assert.Equal(t, 10, len(keys))
+	keys, _ = scanAll("user:[^0-1]*", 100)
+	assert.Equal(t, []string{"user:20", "user:21", "user:22", "user:23", "user:24", "user:list", "user:hash", "user:zset"}, keys)
+	keys, _ = scanAll("*", 3, Hash, ZSet)
+	assert.Equal(t, []string{"user:hash", "user:zset"}, keys)
+
+	// keys there during the whole scan are returned once
+	page, cursor, err := db.Scan(nil, "", 5, Str)
+	assert.Nil(t, err)
+	assert.Equal(t, "user:03", string(page[4]))
+	assert.Nil(t, db.Remove([]byte("user:04")))
+	assert.Nil(t, db.Remove([]byte("user:05")))
+	assert.Nil(t, db.Set([]byte("user:00"), []byte("v2")))
+	page, _, err = db.Scan(cursor, "", 2, Str)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user:06"), []byte("user:07")}, page)
+
+	_, _, err = db.Scan([]byte{9}, "", 10)
+	assert.Equal(t, ErrorBadCursor, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Del(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k := []byte("k")
+	assert.Nil(t, db.Set(k, []byte("v")))
+	assert.Nil(t, db.RPush(k, []byte("a"), []byte("b")))
+	assert.Nil(t, db.HSet(k, []byte("f"), []byte("v")))
+	assert.Nil(t, db.SAdd([]byte("s"), []byte("m")))
+	assert.Nil(t, db.ZAdd([]byte("z"), 1, []byte("m")))
+	assert.Nil(t, db.Expire([]byte("z"), time.Hour))
+	assert.Equal(t, []uint16{Str, List, Hash}, db.Type(k))
+	assert.Equal(t, 3, db.Exists(k, []byte("s"), []byte("z"), []byte("none")))
+	assert.Equal(t, 5, db.DBSize())
+
+	n, err := db.Del(k, []byte("z"), []byte("none"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Nil(t, db.Type(k))
+	assert.Equal(t, 1, db.DBSize())
+
+	// a new key does not take the deadline of the removed one
+	assert.Nil(t, db.ZAdd([]byte("z"), 2, []byte("m2")))
+	ttl, err := db.TTL([]byte("z"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+	assert.Equal(t, 2, db.DBSize())
+
+	// a collection is removed with its last element
+	assert.Nil(t, db.SRem([]byte("s"), []byte("m")))
+	assert.Equal(t, 0, db.Exists([]byte("s")))
+
+	check := func() {
+		assert.Nil(t, db.Type(k))
+		assert.Equal(t, 0, db.LLen(k))
+		assert.Equal(t, []uint16{ZSet}, db.Type([]byte("z")))
+		assert.False(t, db.ZIsMember([]byte("z"), []byte("m")))
+		assert.Equal(t, 1, db.DBSize())
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_Rename(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	src, dst := []byte("src"), []byte("dst")
+	assert.Nil(t, db.Set(src, []byte("v")))
+	assert.Nil(t, db.RPush(src, []byte("a"), []byte("b")))
+	assert.Nil(t, db.HSet(src, []byte("f"), []byte("v")))
+	assert.Nil(t, db.SAdd(src, []byte("m")))
+	assert.Nil(t, db.ZAdd(src, 1.5, []byte("m")))
+	assert.Nil(t, db.Expire(src, time.Hour))
+	assert.Nil(t, db.SAdd(dst, []byte("old")))
+	assert.Nil(t, db.Set([]byte("other"), []byte("v")))
+
+	ok, err := db.RenameNx(src, dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.RenameNx(src, []byte("other"))
+	assert.False(t, ok)
+	assert.Equal(t, ErrorKeyNotExist, db.Rename([]byte("none"), dst))
+	assert.Nil(t, db.Rename(src, dst))
+
+	check := func() {
+		assert.Nil(t, db.Type(src))
+		v, err := db.Get(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), v)
+		vs, err := db.LRange(dst, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, vs)
+		v, err = db.HGet(dst, []byte("f"))
+		assert.Equal(t, []byte("v"), v)
+		assert.Equal(t, 1, db.SCard(dst))
+		assert.True(t, db.SIsMember(dst, []byte("m")))
+		ok, score := db.ZScore(dst, []byte("m"))
+		assert.True(t, ok)
+		assert.Equal(t, 1.5, score)
+		ttl, err := db.TTL(dst)
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+		assert.Equal(t, 6, db.DBSize())
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_Rename_TooLarge(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// fields of a hash are in several files, the move is in one
+	src, dst := []byte("src"), []byte("dst")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.HSet(src, []byte(fmt.Sprintf("f%d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.Set(src, []byte("v")))
+	assert.Equal(t, ErrorRenameOverflow, db.Rename(src, dst))
+
+	// src is untouched, even after merging
+	assert.Nil(t, db.GC())
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, db.HLen(src))
+	assert.True(t, db.StrKeyExist(src))
+	assert.Nil(t, db.Type(dst))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_FlushDB(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v")))
+		assert.Nil(t, db.HSet([]byte("h"), []byte(fmt.Sprintf("f%d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.LPush([]byte("l"), []byte("v")))
+	assert.Nil(t, db.Expire([]byte("l"), time.Hour))
+
+	assert.Nil(t, db.FlushDB())
+	assert.Equal(t, 0, db.DBSize())
+	for _, s := range db.FileStats() {
+		assert.True(t, s.Active)
+		assert.Equal(t, int64(0), s.Size)
+	}
+	assert.Nil(t, db.Set([]byte("k0"), []byte("v2")))
+	assert.Nil(t, db.LPush([]byte("l"), []byte("v2")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, db.DBSize())
+	v, err := db.Get([]byte("k0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), v)
+	ttl, err := db.TTL([]byte("l"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+	assert.Nil(t, db.Close())
+}
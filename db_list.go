@@ -1,24 +1,40 @@
 package CaskDB
 
 import (
+	"bytes"
 	"github.com/k-si/CaskDB/ds"
-	"github.com/k-si/CaskDB/util"
 	"sync"
 )
 
+// every element of a list is an entry with a sequence in its key:
+// key | seq -> value. the sequence of an element never changes,
+// so an element entry is live until the element is popped, removed
+// or set again, and list files are merged like other types
 type ListIndex struct {
-	mu  *sync.RWMutex
-	idx *ds.List
+	mu     *sync.RWMutex
+	idx    *ds.SeqList // key -> seq -> index of element entry
+	legacy *ds.List    // lists of positional entries, only used while loading old files
+	exp    expires
 }
 
 func NewListIndex() *ListIndex {
 	return &ListIndex{
-		mu:  &sync.RWMutex{},
-		idx: ds.NewList(),
+		mu:     &sync.RWMutex{},
+		idx:    ds.NewSeqList(),
+		legacy: ds.NewList(),
+		exp:    make(expires),
 	}
 }
 
 func (db *DB) LPush(key []byte, values ...[]byte) error {
+	return db.push(true, key, values...)
+}
+
+func (db *DB) RPush(key []byte, values ...[]byte) error {
+	return db.push(false, key, values...)
+}
+
+func (db *DB) push(front bool, key []byte, values ...[]byte) error {
 
 	// check size
 	if err := db.checkKeySize(key); err != nil {
@@ -32,97 +48,55 @@ func (db *DB) LPush(key []byte, values ...[]byte) error {
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
-	k := string(key)
+	db.expireIfNeeded(List, key)
 
 	for _, v := range values {
-
-		// store disk
-		e := NewEntry(key, v, List, ListLPush, 0)
-		if err := db.StoreFile(e); err != nil {
+		if err := db.storeElem(key, db.pushSeq(string(key), front), v); err != nil {
 			return err
 		}
-
-		// store index
-		db.listIndex.idx.Push(true, k, v)
 	}
 
 	return nil
 }
 
 func (db *DB) LPop(key []byte) ([]byte, error) {
+	return db.pop(true, key)
+}
 
-	// check size
-	if err := db.checkKeySize(key); err != nil {
-		return nil, err
-	}
-
-	// lock
-	db.listIndex.mu.Lock()
-	defer db.listIndex.mu.Unlock()
-
-	// store disk
-	e := NewEntry(key, nil, List, ListLPop, 0)
-	if err := db.StoreFile(e); err != nil {
-		return nil, err
-	}
-
-	// pop from index
-	v := db.listIndex.idx.Pop(true, string(key))
-
-	return v, nil
+func (db *DB) RPop(key []byte) ([]byte, error) {
+	return db.pop(false, key)
 }
 
-func (db *DB) RPush(key []byte, values ...[]byte) error {
+func (db *DB) pop(front bool, key []byte) ([]byte, error) {
 
 	// check size
 	if err := db.checkKeySize(key); err != nil {
-		return err
-	}
-	if err := db.checkValsSize(values...); err != nil {
-		return err
+		return nil, err
 	}
 
 	// lock
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
-	k := string(key)
-
-	for _, v := range values {
-
-		// store disk
-		e := NewEntry(key, v, List, ListRPush, 0)
-		if err := db.StoreFile(e); err != nil {
-			return err
-		}
+	db.expireIfNeeded(List, key)
 
-		// store index
-		db.listIndex.idx.Push(false, k, v)
+	var el *ds.SeqElem
+	if front {
+		el = db.listIndex.idx.First(string(key))
+	} else {
+		el = db.listIndex.idx.Last(string(key))
+	}
+	if el == nil {
+		return nil, nil
 	}
 
-	return nil
-}
-
-func (db *DB) RPop(key []byte) ([]byte, error) {
-
-	// check size
-	if err := db.checkKeySize(key); err != nil {
+	v, err := db.elemValue(el)
+	if err != nil {
 		return nil, err
 	}
-
-	// lock
-	db.listIndex.mu.Lock()
-	defer db.listIndex.mu.Unlock()
-
-	// store disk
-	e := NewEntry(key, nil, List, ListRPop, 0)
-	if err := db.StoreFile(e); err != nil {
+	if err := db.removeElem(key, el.Seq); err != nil {
 		return nil, err
 	}
-
-	// pop from index
-	v := db.listIndex.idx.Pop(false, string(key))
-
 	return v, nil
 }
 
@@ -144,16 +118,34 @@ func (db *DB) LRem(key, value []byte, n int) error {
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
-	// append remove entry
-	// keys = key | n
-	keys := db.splice(key, util.IntToBytes(n))
-	e := NewEntry(keys, value, List, ListLRem, uint32(len(key)))
-	if err := db.StoreFile(e); err != nil {
-		return err
+	db.expireIfNeeded(List, key)
+
+	els := db.listIndex.idx.Range(string(key), 0, -1)
+	if n < 0 {
+		for i, j := 0, len(els)-1; i < j; i, j = i+1, j-1 {
+			els[i], els[j] = els[j], els[i]
+		}
+		n = -n
 	}
 
-	// remove all items that equal to value from index
-	db.listIndex.idx.Remove(string(key), value, n)
+	// remove elements one by one
+	removed := 0
+	for _, el := range els {
+		if n > 0 && removed == n {
+			break
+		}
+		v, err := db.elemValue(el)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, value) {
+			continue
+		}
+		if err := db.removeElem(key, el.Seq); err != nil {
+			return err
+		}
+		removed++
+	}
 
 	return nil
 }
@@ -170,35 +162,28 @@ func (db *DB) LIndex(key []byte, n int) ([]byte, error) {
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
 
-	v := db.listIndex.idx.Get(string(key), n)
-	return v, nil
-}
-
-func (db *DB) LInsert(key, value []byte, n int) error {
-	// check size
-	if err := db.checkKeySize(key); err != nil {
-		return err
+	if db.listIndex.exp.expired(string(key)) {
+		return nil, nil
 	}
-	if err := db.checkValSize(value); err != nil {
-		return err
-	}
-
-	// lock
-	db.listIndex.mu.Lock()
-	defer db.listIndex.mu.Unlock()
-
-	// store disk
-	keys := db.splice(key, util.IntToBytes(n))
-	e := NewEntry(keys, value, List, ListLInsert, uint32(len(key)))
-	if err := db.StoreFile(e); err != nil {
-		return err
+	el := db.nthElem(string(key), n)
+	if el == nil {
+		return nil, nil
 	}
+	return db.elemValue(el)
+}
 
-	db.listIndex.idx.Insert(string(key), 0, n, value)
-	return nil
+// insert value before Nth element
+func (db *DB) LInsert(key, value []byte, n int) error {
+	return db.insert(key, value, n, false)
 }
 
+// insert value after Nth element
 func (db *DB) RInsert(key, value []byte, n int) error {
+	return db.insert(key, value, n, true)
+}
+
+func (db *DB) insert(key, value []byte, n int, after bool) error {
+
 	// check size
 	if err := db.checkKeySize(key); err != nil {
 		return err
@@ -211,15 +196,32 @@ func (db *DB) RInsert(key, value []byte, n int) error {
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
-	// store disk
-	keys := db.splice(key, util.IntToBytes(n))
-	e := NewEntry(keys, value, List, ListRInsert, uint32(len(key)))
-	if err := db.StoreFile(e); err != nil {
-		return err
+	db.expireIfNeeded(List, key)
+
+	// the new element is between its neighbours
+	k := string(key)
+	el := db.nthElem(k, n)
+	if el == nil {
+		return db.storeElem(key, ds.FirstSeq(), value)
+	}
+	pos := db.clampIdx(k, n)
+
+	var seq string
+	if after {
+		if next := db.listIndex.idx.At(k, pos+1); next != nil {
+			seq = ds.SeqBetween(el.Seq, next.Seq)
+		} else {
+			seq = ds.SeqAfter(el.Seq)
+		}
+	} else {
+		if prev := db.listIndex.idx.At(k, pos-1); prev != nil {
+			seq = ds.SeqBetween(prev.Seq, el.Seq)
+		} else {
+			seq = ds.SeqBefore(el.Seq)
+		}
 	}
 
-	db.listIndex.idx.Insert(string(key), 1, n, value)
-	return nil
+	return db.storeElem(key, seq, value)
 }
 
 // cover the value of Nth element
@@ -237,15 +239,13 @@ func (db *DB) LSet(key, value []byte, n int) error {
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
-	// store disk
-	keys := db.splice(key, util.IntToBytes(n))
-	e := NewEntry(keys, value, List, ListLSet, uint32(len(key)))
-	if err := db.StoreFile(e); err != nil {
-		return err
-	}
+	db.expireIfNeeded(List, key)
 
-	db.listIndex.idx.Put(string(key), value, n)
-	return nil
+	seq := ds.FirstSeq()
+	if el := db.nthElem(string(key), n); el != nil {
+		seq = el.Seq
+	}
+	return db.storeElem(key, seq, value)
 }
 
 func (db *DB) LRange(key []byte, start, stop int) ([][]byte, error) {
@@ -259,18 +259,141 @@ func (db *DB) LRange(key []byte, start, stop int) ([][]byte, error) {
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
 
-	res := db.listIndex.idx.Range(string(key), start, stop)
+	if db.listIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+
+	var res [][]byte
+	for _, el := range db.listIndex.idx.Range(string(key), start, stop) {
+		v, err := db.elemValue(el)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
 	return res, nil
 }
 
 func (db *DB) LExist(key, value []byte) bool {
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
-	return db.listIndex.idx.ValExist(string(key), value)
+	if db.listIndex.exp.expired(string(key)) {
+		return false
+	}
+	for _, el := range db.listIndex.idx.Range(string(key), 0, -1) {
+		if v, err := db.elemValue(el); err == nil && bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
 }
 
 func (db *DB) LLen(key []byte) int {
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
-	return db.listIndex.idx.LLen(string(key))
+	if db.listIndex.exp.expired(string(key)) {
+		return 0
+	}
+	return db.listIndex.idx.Len(string(key))
+}
+
+// change n to valid range
+func (db *DB) clampIdx(key string, n int) int {
+	if l := db.listIndex.idx.Len(key); n >= l {
+		n = l - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// Nth element, n out of range means the first or the last one
+func (db *DB) nthElem(key string, n int) *ds.SeqElem {
+	return db.listIndex.idx.At(key, db.clampIdx(key, n))
+}
+
+// sequence of a new element at the head or the tail
+func (db *DB) pushSeq(key string, front bool) string {
+	if front {
+		if el := db.listIndex.idx.First(key); el != nil {
+			return ds.SeqBefore(el.Seq)
+		}
+	} else if el := db.listIndex.idx.Last(key); el != nil {
+		return ds.SeqAfter(el.Seq)
+	}
+	return ds.FirstSeq()
+}
+
+// turn pushes of a batch into element entries, an element is pushed beside
+// the elements in memory and the elements pushed before it in the same batch.
+// caller must hold the write lock
+func (db *DB) seqEntries(entries []*Entry) []*Entry {
+	ends := make(map[string][]string) // key -> seq of head and tail
+
+	res := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		mt := e.GetMarkType()
+		if mt != ListLPush && mt != ListRPush {
+			res = append(res, e)
+			continue
+		}
+
+		k := string(e.key)
+		if _, ok := ends[k]; !ok && db.listIndex.idx.KeyExist(k) {
+			ends[k] = []string{db.listIndex.idx.First(k).Seq, db.listIndex.idx.Last(k).Seq}
+		}
+
+		var seq string
+		if end, ok := ends[k]; !ok {
+			seq = ds.FirstSeq()
+			ends[k] = []string{seq, seq}
+		} else if mt == ListLPush {
+			seq = ds.SeqBefore(end[0])
+			end[0] = seq
+		} else {
+			seq = ds.SeqAfter(end[1])
+			end[1] = seq
+		}
+		res = append(res, NewEntry(db.splice(e.key, []byte(seq)), e.value, List, ListSeqSet, uint32(len(e.key))))
+	}
+	return res
+}
+
+// write element at seq in disk and memory, caller must hold the write lock
+func (db *DB) storeElem(key []byte, seq string, value []byte) error {
+	e := NewEntry(db.splice(key, []byte(seq)), value, List, ListSeqSet, uint32(len(key)))
+	if err := db.StoreFile(e); err != nil {
+		return err
+	}
+
+	f := db.activeFiles[List]
+	idx := &Index{
+		fileId: f.id,
+		offset: f.offset - int64(e.Size()),
+		size:   e.Size(),
+	}
+	db.listIndex.idx.Put(string(key), seq, idx)
+	return nil
+}
+
+// remove element at seq in disk and memory, caller must hold the write lock
+func (db *DB) removeElem(key []byte, seq string) error {
+	e := NewEntry(db.splice(key, []byte(seq)), nil, List, ListSeqDel, uint32(len(key)))
+	if err := db.StoreFile(e); err != nil {
+		return err
+	}
+	db.listIndex.idx.Remove(string(key), seq)
+	db.forgetEmpty(List, string(key))
+	return nil
+}
+
+// value of element is read from disk, except lists loaded
+// from old files, which are in memory until they are rewritten
+func (db *DB) elemValue(el *ds.SeqElem) ([]byte, error) {
+	idx := el.Value.(*Index)
+	if idx.size == 0 {
+		return idx.value, nil
+	}
+	return db.readValue(List, idx)
 }
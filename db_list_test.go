@@ -1,6 +1,7 @@
 package CaskDB
 
 import (
+	"github.com/k-si/CaskDB/util"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -237,3 +238,100 @@ func TestDB_List(t *testing.T) {
 		}
 	}
 }
+
+// lists written in positional entries are rewritten on Open
+func TestDB_ListUpgrade(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// old entries, and the same operations in an old list
+	k := []byte("k")
+	pos := func(n int) []byte { return db.splice(k, util.IntToBytes(n)) }
+	for _, e := range []*Entry{
+		NewEntry(k, []byte("a"), List, ListRPush, 0),
+		NewEntry(k, []byte("b"), List, ListRPush, 0),
+		NewEntry(k, []byte("c"), List, ListRPush, 0),
+		NewEntry(k, []byte("z"), List, ListLPush, 0),
+		NewEntry(pos(2), []byte("x"), List, ListLInsert, uint32(len(k))),
+		NewEntry(pos(3), []byte("y"), List, ListRInsert, uint32(len(k))),
+		NewEntry(pos(0), []byte("w"), List, ListLSet, uint32(len(k))),
+		NewEntry(pos(1), []byte("c"), List, ListLRem, uint32(len(k))),
+		NewEntry(k, nil, List, ListRPop, 0),
+		NewEntry([]byte("gone"), []byte("a"), List, ListRPush, 0),
+		NewEntry([]byte("gone"), nil, List, ListLPop, 0),
+	} {
+		assert.Nil(t, db.StoreFile(e))
+		db.buildLegacyList(e)
+	}
+	want := db.listIndex.legacy.Range("k", 0, -1)
+	assert.Equal(t, 4, len(want))
+	assert.Nil(t, db.Close())
+
+	check := func() {
+		res, err := db.LRange(k, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, want, res)
+		assert.False(t, db.LKeyExist([]byte("gone")))
+	}
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+
+	// only sequence entries are left
+	files := append(db.archedFilesOf(List), db.activeFiles[List])
+	for _, f := range files {
+		for offset := f.start(); offset < f.offset; {
+			e, err := f.Read(offset)
+			assert.Nil(t, err)
+			assert.Equal(t, ListSeqSet, e.GetMarkType())
+			offset += int64(e.Size())
+		}
+	}
+	assert.Nil(t, db.RPush(k, []byte("d")))
+	want = append(want, []byte("d"))
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_ListBatch(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k := []byte("k")
+	assert.Nil(t, db.RPush(k, []byte("b")))
+	wb := db.NewWriteBatch()
+	wb.LPush(k, []byte("a"))
+	wb.RPush(k, []byte("c"), []byte("d"))
+	wb.LPush([]byte("n"), []byte("2"))
+	wb.RPush([]byte("n"), []byte("3"))
+	wb.LPush([]byte("n"), []byte("1"))
+	assert.Nil(t, wb.Commit())
+
+	check := func() {
+		res, err := db.LRange(k, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, res)
+		res, err = db.LRange([]byte("n"), 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, res)
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
@@ -2,18 +2,21 @@ package CaskDB
 
 import (
 	"github.com/k-si/CaskDB/ds"
+	"github.com/k-si/CaskDB/util"
 	"sync"
 )
 
 type SetIndex struct {
 	mu  *sync.RWMutex
 	idx *ds.Set
+	exp expires
 }
 
 func NewSetIndex() *SetIndex {
 	return &SetIndex{
 		mu:  &sync.RWMutex{},
 		idx: ds.NewSet(),
+		exp: make(expires),
 	}
 }
 
@@ -31,12 +34,14 @@ func (db *DB) SAdd(key []byte, values ...[]byte) error {
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
+	db.expireIfNeeded(Set, key)
+
 	for _, v := range values {
 
 		// write disk
 		e := NewEntry(key, v, Set, SetSAdd, 0)
 		if err := db.StoreFile(e); err != nil {
-			return nil
+			return err
 		}
 
 		// index
@@ -46,13 +51,14 @@ func (db *DB) SAdd(key []byte, values ...[]byte) error {
 	return nil
 }
 
-func (db *DB) SRem(key, value []byte) error {
+// remove values from the set, values which are not in it are ignored
+func (db *DB) SRem(key []byte, values ...[]byte) error {
 
 	// check size
 	if err := db.checkKeySize(key); err != nil {
 		return err
 	}
-	if err := db.checkValSize(value); err != nil {
+	if err := db.checkValsSize(values...); err != nil {
 		return err
 	}
 
@@ -60,18 +66,65 @@ func (db *DB) SRem(key, value []byte) error {
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
-	// store disk
-	e := NewEntry(key, value, Set, SetSRem, 0)
-	if err := db.StoreFile(e); err != nil {
-		return err
-	}
+	db.expireIfNeeded(Set, key)
 
-	// store index
-	db.setIndex.idx.Remove(string(key), string(value))
+	for _, v := range values {
+		if !db.setIndex.idx.ValExist(string(key), string(v)) {
+			continue
+		}
+
+		// store disk
+		e := NewEntry(key, v, Set, SetSRem, 0)
+		if err := db.StoreFile(e); err != nil {
+			return err
+		}
+
+		// store index
+		db.setIndex.idx.Remove(string(key), string(v))
+	}
+	db.forgetEmpty(Set, string(key))
 
 	return nil
 }
 
+// remove count random members, the removed members are returned
+func (db *DB) SPop(key []byte, count int) ([][]byte, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+
+	// lock
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	db.expireIfNeeded(Set, key)
+
+	members := db.setIndex.idx.Random(string(key), count)
+	if len(members) == 0 {
+		return nil, nil
+	}
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	// all of them are removed in a batch, or none
+	wb := db.NewWriteBatch()
+	res := make([][]byte, len(members))
+	for i, m := range members {
+		res[i] = []byte(m)
+		wb.put(NewEntry(key, res[i], Set, SetSRem, 0))
+	}
+	if err := wb.write(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (db *DB) SMove(src, dest, value []byte) error {
 
 	// check size
@@ -86,6 +139,8 @@ func (db *DB) SMove(src, dest, value []byte) error {
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
+	db.expireIfNeeded(Set, src, dest)
+
 	// store disk
 	keys := db.splice(src, dest)
 	e := NewEntry(keys, value, Set, SetSMove, uint32(len(src)))
@@ -95,40 +150,75 @@ func (db *DB) SMove(src, dest, value []byte) error {
 
 	// store index
 	db.setIndex.idx.Move(string(src), string(dest), string(value))
+	db.forgetEmpty(Set, string(src))
 
 	return nil
 }
 
+// ways to combine sets
+const (
+	sUnion = iota
+	sInter
+	sDiff
+)
+
 func (db *DB) SUnion(keys ...[]byte) ([][]byte, error) {
+	return db.sCombine(sUnion, keys)
+}
 
-	// check
+// members in all the sets
+func (db *DB) SInter(keys ...[]byte) ([][]byte, error) {
+	return db.sCombine(sInter, keys)
+}
+
+// size of SInter, counting stops at limit, 0 means no limit
+func (db *DB) SInterCard(limit int, keys ...[]byte) (int, error) {
+
+	// check size
 	if err := db.checkKeysSize(keys...); err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	// lock
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
-	// store index
 	ks := make([]string, len(keys))
-	for i := 0; i < len(keys); i++ {
-		ks[i] = string(keys[i])
-	}
-	values := db.setIndex.idx.Union(ks...)
-
-	// return union
-	res := make([][]byte, len(values))
-	for i := 0; i < len(values); i++ {
-		res[i] = []byte(values[i])
+	for i, k := range keys {
+		if db.setIndex.exp.expired(string(k)) {
+			return 0, nil
+		}
+		ks[i] = string(k)
 	}
-	return res, nil
+	return db.setIndex.idx.InterCard(limit, ks...), nil
 }
 
 // set1 = a, b
 // set2 = a, b, c, d
 // SDiff(s1, s2) = c, d
+// or SDiff(s2, s1) = c, d if Config.RedisSDiff is set, it returns
+// members of the first set which are not in others like redis
 func (db *DB) SDiff(keys ...[]byte) ([][]byte, error) {
+	return db.sCombine(sDiff, keys)
+}
+
+// store SUnion in dst, dst is replaced in a batch.
+// the number of members in dst is returned
+func (db *DB) SUnionStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.sStore(sUnion, dst, keys)
+}
+
+// store SInter in dst like SUnionStore
+func (db *DB) SInterStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.sStore(sInter, dst, keys)
+}
+
+// store SDiff in dst like SUnionStore
+func (db *DB) SDiffStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.sStore(sDiff, dst, keys)
+}
+
+func (db *DB) sCombine(op int, keys [][]byte) ([][]byte, error) {
 
 	// check size
 	if err := db.checkKeysSize(keys...); err != nil {
@@ -139,14 +229,7 @@ func (db *DB) SDiff(keys ...[]byte) ([][]byte, error) {
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
-	// store index
-	ks := make([]string, len(keys))
-	for i := 0; i < len(keys); i++ {
-		ks[i] = string(keys[i])
-	}
-	values := db.setIndex.idx.Diff(ks...)
-
-	// return diff
+	values := db.sCombined(op, keys)
 	res := make([][]byte, len(values))
 	for i := 0; i < len(values); i++ {
 		res[i] = []byte(values[i])
@@ -154,8 +237,72 @@ func (db *DB) SDiff(keys ...[]byte) ([][]byte, error) {
 	return res, nil
 }
 
+func (db *DB) sStore(op int, dst []byte, keys [][]byte) (int, error) {
+
+	// check size
+	if err := db.checkKeySize(dst); err != nil {
+		return 0, err
+	}
+	if err := db.checkKeysSize(keys...); err != nil {
+		return 0, err
+	}
+	if err := db.writable(); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	db.expireIfNeeded(Set, dst)
+	values := db.sCombined(op, keys)
+
+	// dst is removed and written again at once
+	wb := db.NewWriteBatch()
+	if db.setIndex.idx.KeyExist(string(dst)) {
+		wb.put(NewEntry(dst, nil, Set, SetClear, 0))
+	}
+	for _, v := range values {
+		wb.put(NewEntry(dst, []byte(v), Set, SetSAdd, 0))
+	}
+	if err := wb.write(); err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+// combine keys by op, expired sets are empty. caller must hold the lock
+func (db *DB) sCombined(op int, keys [][]byte) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+	ks := make([]string, 0, len(keys))
+	for i, k := range keys {
+		if db.setIndex.exp.expired(string(k)) {
+			if op == sInter || (op == sDiff && i == 0) {
+				return nil
+			}
+			continue
+		}
+		ks = append(ks, string(k))
+	}
+
+	idx := db.setIndex.idx
+	switch op {
+	case sUnion:
+		return idx.Union(ks...)
+	case sInter:
+		return idx.Inter(ks...)
+	default:
+		if db.config.RedisSDiff {
+			return idx.Subtract(ks...)
+		}
+		return idx.Diff(ks...)
+	}
+}
+
 // get all members
-func (db *DB) SScan(key []byte) ([][]byte, error) {
+func (db *DB) SMembers(key []byte) ([][]byte, error) {
 
 	// check
 	if err := db.checkKeySize(key); err != nil {
@@ -166,20 +313,98 @@ func (db *DB) SScan(key []byte) ([][]byte, error) {
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
+	if db.setIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
 	res := db.setIndex.idx.Scan(string(key))
 	return res, nil
 }
 
+// a page of members from cursor like Scan,
+// cursor is nil at first, and nil is returned after the last page
+func (db *DB) SScan(key, cursor []byte, match string, count int) ([][]byte, []byte, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, nil, err
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	// lock
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.setIndex.exp.expired(string(key)) {
+		return nil, nil, nil
+	}
+	members, next := scanPage(db.setIndex.idx.MembersFrom(string(key), string(cursor), count+1), count)
+
+	var res [][]byte
+	for _, m := range members {
+		if match == "" || util.GlobMatch(match, m) {
+			res = append(res, []byte(m))
+		}
+	}
+	return res, next, nil
+}
+
 func (db *DB) SIsMember(key, value []byte) bool {
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
+	if db.setIndex.exp.expired(string(key)) {
+		return false
+	}
 	b := db.setIndex.idx.ValExist(string(key), string(value))
 	return b
 }
 
+// whether every value is a member of the set
+func (db *DB) SMIsMember(key []byte, values ...[]byte) []bool {
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	res := make([]bool, len(values))
+	if db.setIndex.exp.expired(string(key)) {
+		return res
+	}
+	for i, v := range values {
+		res[i] = db.setIndex.idx.ValExist(string(key), string(v))
+	}
+	return res
+}
+
+// random members like redis, count members at most are distinct if
+// count is positive, and -count members may repeat if it is negative
+func (db *DB) SRandMember(key []byte, count int) ([][]byte, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.setIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	members := db.setIndex.idx.Random(string(key), count)
+	res := make([][]byte, len(members))
+	for i, m := range members {
+		res[i] = []byte(m)
+	}
+	return res, nil
+}
+
 func (db *DB) SCard(key []byte) int {
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
+	if db.setIndex.exp.expired(string(key)) {
+		return 0
+	}
 	return db.setIndex.idx.Len(string(key))
 }
@@ -1,6 +1,7 @@
 package CaskDB
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -127,7 +128,96 @@ func TestDB_SDiff(t *testing.T) {
 	assert.Nil(t, err)
 }
 
-func TestDB_SScan(t *testing.T) {
+func TestDB_SInter_Store(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.RedisSDiff = true
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	k1, k2, dst := []byte("set1"), []byte("set2"), []byte("dst")
+	assert.Nil(t, db.SAdd(k1, []byte("a"), []byte("b"), []byte("c")))
+	assert.Nil(t, db.SAdd(k2, []byte("b"), []byte("c"), []byte("d")))
+	assert.Nil(t, db.SAdd(dst, []byte("x")))
+
+	res, err := db.SInter(k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+	n, err := db.SInterCard(1, k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	res, err = db.SDiff(k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, res)
+
+	n, err = db.SInterStore(dst, k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []bool{false, true, true}, db.SMIsMember(dst, []byte("x"), []byte("b"), []byte("c")))
+
+	n, err = db.SDiffStore(dst, dst, k1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, db.Type(dst))
+
+	n, err = db.SUnionStore(dst, k1, k2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	// dst is kept after reopen
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, db.SCard(dst))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_SPop_SRandMember(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k := []byte("set")
+	assert.Nil(t, db.SAdd(k, []byte("a"), []byte("b"), []byte("c")))
+
+	res, err := db.SRandMember(k, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+	res, err = db.SRandMember(k, -5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(res))
+
+	res, err = db.SPop(k, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, 1, db.SCard(k))
+	assert.False(t, db.SIsMember(k, res[0]))
+
+	assert.Nil(t, db.SAdd(k, []byte("x"), []byte("y")))
+	assert.Nil(t, db.SRem(k, []byte("x"), []byte("y"), []byte("z")))
+	assert.Equal(t, 1, db.SCard(k))
+
+	res, err = db.SPop(k, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res))
+	assert.Empty(t, db.Type(k))
+
+	// popped members are gone after reopen
+	assert.Nil(t, db.Close())
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.SCard(k))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_SMembers(t *testing.T) {
 	os.RemoveAll("/tmp/CaskDB")
 
 	db, err := Open(DefaultConfig())
@@ -135,7 +225,7 @@ func TestDB_SScan(t *testing.T) {
 	k := []byte("k")
 
 	err = db.SAdd(k, []byte("v1"), []byte("v2"))
-	res, err := db.SScan(k)
+	res, err := db.SMembers(k)
 	//fmt.Print(string(res[0]), string(res[1]))
 	assert.Equal(t, 2, len(res))
 
@@ -175,3 +265,56 @@ func TestDB_SSet(t *testing.T) {
 		}
 	}
 }
+
+func TestDB_SScan(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	k := []byte("k")
+	assert.Nil(t, db.SAdd(k, []byte("a"), []byte("b"), []byte("c"), []byte("ab")))
+
+	res, cursor, err := db.SScan(k, nil, "a*", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("ab")}, res)
+	assert.Equal(t, []byte("b"), cursor)
+
+	res, cursor, err = db.SScan(k, cursor, "", 2)
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, res)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_SPop_Batch(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	k := []byte("s")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.SAdd(k, []byte(fmt.Sprintf("m%d", i))))
+	}
+
+	// too many members for one file, none of them is removed
+	_, err = db.SPop(k, 10)
+	assert.Equal(t, ErrorWriteOverFlow, err)
+	assert.Equal(t, 10, db.SCard(k))
+
+	res, err := db.SPop(k, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, db.SCard(k))
+	for _, m := range res {
+		assert.False(t, db.SIsMember(k, m))
+	}
+	assert.Nil(t, db.Close())
+}
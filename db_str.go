@@ -2,12 +2,15 @@ package CaskDB
 
 import (
 	"github.com/k-si/CaskDB/ds"
+	"github.com/k-si/CaskDB/util"
 	"sync"
+	"time"
 )
 
 type StrIndex struct {
 	mu *sync.RWMutex
 	idx *ds.AVLTree
+	exp expires
 }
 
 func NewStrIndex() *StrIndex {
@@ -15,6 +18,7 @@ func NewStrIndex() *StrIndex {
 		mu: &sync.RWMutex{},
 		//idx: ds.NewSkipList(),
 		idx: ds.NewAVLTree(),
+		exp: make(expires),
 	}
 }
 
@@ -52,12 +56,30 @@ func (db *DB) setVal(key, value []byte) error {
 		//valueSize: e.valueSize,
 		fileId: f.id,
 		offset: f.offset - int64(e.Size()), // offset is the entry start position
+		size:   e.Size(),
 	}
 	db.strIndex.idx.Put(e.key, idx)
 
+	// set a new value will clear the timeout
+	delete(db.strIndex.exp, string(key))
+
 	return nil
 }
 
+// set kv with a timeout
+func (db *DB) SetEx(key, value []byte, ttl time.Duration) error {
+
+	// write in a batch, so that the key is never left without timeout
+	wb := db.NewWriteBatch()
+	wb.Set(key, value)
+	if wb.err == nil {
+		deadline := time.Now().Add(ttl).UnixNano()
+		wb.put(NewEntry(key, util.IntToBytes(int(deadline)), Str, StrExpire, 0))
+	}
+
+	return wb.Commit()
+}
+
 // set if not exist
 func (db *DB) SetNx(key, value []byte) error {
 
@@ -113,6 +135,11 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 // get key from Adele
 func (db *DB) getVal(key []byte) ([]byte, error) {
 
+	// expired key is invisible
+	if db.strIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+
 	// get index and find value from disk
 	v := db.strIndex.idx.Get(key)
 	if v == nil {
@@ -164,24 +191,13 @@ func (db *DB) MSet(values ...[]byte) error {
 		return ErrorMSetParams
 	}
 
-	// lock
-	db.strIndex.mu.Lock()
-	defer db.strIndex.mu.Unlock()
-
+	// write in a batch, so that no half MSet will be seen after crash
+	wb := db.NewWriteBatch()
 	for i := 0; i < len(values); i += 2 {
-		k, v := values[i], values[i+1]
-		if err := db.checkKeySize(k); err != nil {
-			return err
-		}
-		if err := db.checkValSize(v); err != nil {
-			return err
-		}
-		if err := db.setVal(k, v); err != nil {
-			return err
-		}
+		wb.Set(values[i], values[i+1])
 	}
 
-	return nil
+	return wb.Commit()
 }
 
 func (db *DB) MGet(keys ...[]byte) ([][]byte, error) {
@@ -262,6 +278,7 @@ func (db *DB) removeVal(key []byte) error {
 
 	// remove index
 	db.strIndex.idx.Remove(key)
+	delete(db.strIndex.exp, string(key))
 
 	return nil
 }
@@ -271,5 +288,5 @@ func (db *DB) StrLen() int {
 	db.strIndex.mu.RLock()
 	defer db.strIndex.mu.RUnlock()
 
-	return db.strIndex.idx.Size()
+	return db.strIndex.idx.Size() - db.strIndex.exp.expiredNum()
 }
@@ -160,6 +160,67 @@ func TestDB_StrLen(t *testing.T) {
 //PASS
 //ok      github.com/k-si/CaskDB  2.740s
 
+func TestDB_Iterator(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+	for _, k := range []string{"c", "a", "ab", "abc", "b", "ac"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("v"+k)))
+	}
+	assert.Nil(t, db.Set([]byte("ad"), []byte("vad")))
+	assert.Nil(t, db.Expire([]byte("ad"), time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+
+	keys := func(it *Iterator) (res []string) {
+		for ; it.Valid(); it.Next() {
+			res = append(res, string(it.Key()))
+		}
+		return
+	}
+	assert.Equal(t, []string{"a", "ab", "abc", "ac", "b", "c"}, keys(db.NewIterator(IteratorOptions{})))
+	assert.Equal(t, []string{"a", "ab", "abc", "ac"}, keys(db.NewIterator(IteratorOptions{Prefix: []byte("a")})))
+	assert.Equal(t, []string{"ac", "abc", "ab", "a"}, keys(db.NewIterator(IteratorOptions{Prefix: []byte("a"), Reverse: true})))
+	assert.Equal(t, []string{"ab", "abc", "ac", "b"}, keys(db.NewIterator(IteratorOptions{Start: []byte("ab"), End: []byte("c")})))
+	assert.Equal(t, []string{"b", "ac", "abc"}, keys(db.NewIterator(IteratorOptions{Start: []byte("abc"), End: []byte("c"), Reverse: true})))
+
+	it := db.NewIterator(IteratorOptions{})
+	it.Seek([]byte("aba"))
+	assert.Equal(t, []byte("abc"), it.Key())
+	v, err := it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("vabc"), v)
+	it.Prev()
+	assert.Equal(t, []byte("ab"), it.Key())
+
+	// changing the key does not change the index
+	k := it.Key()
+	k[0] = 'z'
+	assert.True(t, db.StrKeyExist([]byte("ab")))
+	assert.Equal(t, []byte("ab"), it.Key())
+
+	// writes and merging go on while iterating
+	assert.Nil(t, db.Remove([]byte("abc")))
+	assert.Nil(t, db.Set([]byte("abd"), []byte("vabd")))
+	assert.Nil(t, db.Set([]byte("b"), []byte("vb2")))
+	assert.Nil(t, db.GC())
+	it.Next()
+	assert.Equal(t, []byte("abd"), it.Key())
+	it.Seek([]byte("b"))
+	v, err = it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("vb2"), v)
+	it.Close()
+	assert.False(t, it.Valid())
+
+	it = db.NewIterator(IteratorOptions{Prefix: []byte("c")})
+	assert.Nil(t, db.Close())
+	_, err = it.Value()
+	assert.Equal(t, ErrorClosedDB, err)
+}
+
 func BenchmarkDB_Set(b *testing.B) {
 	b.ReportAllocs()
 
@@ -0,0 +1,134 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/k-si/CaskDB/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_DirLock(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	// the dir is held by db
+	_, err = Open(DefaultConfig())
+	assert.Equal(t, ErrorDBLocked, err)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrorClosedDB, db.Close())
+
+	// released by Close
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+func readDataFiles(t *testing.T, dir string) map[string][]byte {
+	infos, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	files := make(map[string][]byte)
+	for _, i := range infos {
+		if strings.Contains(i.Name(), ".data") || strings.Contains(i.Name(), ".hint") {
+			b, err := ioutil.ReadFile(dir + PathSeparator + i.Name())
+			assert.Nil(t, err)
+			files[i.Name()] = b
+		}
+	}
+	return files
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Set([]byte{'k', byte('0' + i)}, []byte("value")))
+	}
+	assert.Nil(t, db.LPush([]byte("l"), []byte("a")))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Nil(t, db.SAdd([]byte("s"), []byte("m")))
+	assert.Nil(t, db.ZAdd([]byte("z"), 1, []byte("m")))
+	assert.Nil(t, db.Close())
+	before := readDataFiles(t, cfg.DBDir)
+
+	cfg.ReadOnly = true
+	r1, err := Open(cfg)
+	assert.Nil(t, err)
+	r2, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// readers keep the writer out
+	cfg.ReadOnly = false
+	_, err = Open(cfg)
+	assert.Equal(t, ErrorDBLocked, err)
+
+	v, err := r1.Get([]byte("k9"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), v)
+	v, err = r2.HGet([]byte("h"), []byte("f"))
+	assert.Equal(t, []byte("v"), v)
+	assert.Equal(t, 1, r2.LLen([]byte("l")))
+	assert.True(t, r2.SIsMember([]byte("s"), []byte("m")))
+	assert.True(t, r2.ZIsMember([]byte("z"), []byte("m")))
+
+	assert.Equal(t, ErrorReadOnly, r1.Set([]byte("k0"), []byte("v")))
+	assert.Equal(t, ErrorReadOnly, r1.LPush([]byte("l"), []byte("b")))
+	assert.Equal(t, ErrorReadOnly, r1.HSet([]byte("h"), []byte("f"), []byte("v2")))
+	assert.Equal(t, ErrorReadOnly, r1.SAdd([]byte("s"), []byte("n")))
+	assert.Equal(t, ErrorReadOnly, r1.ZAdd([]byte("z"), 2, []byte("n")))
+	assert.Equal(t, ErrorReadOnly, r1.Expire([]byte("k0"), time.Second))
+	assert.Equal(t, ErrorReadOnly, r1.GC())
+	wb := r1.NewWriteBatch()
+	wb.Set([]byte("k0"), []byte("v"))
+	assert.Equal(t, ErrorReadOnly, wb.Commit())
+	v, err = r1.Get([]byte("k0"))
+	assert.Equal(t, []byte("value"), v)
+
+	assert.Nil(t, r1.Close())
+	assert.Nil(t, r2.Close())
+	assert.Equal(t, before, readDataFiles(t, cfg.DBDir))
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+// files copied from a running db may end with half written entries,
+// and the copy may not keep the preallocated size
+func TestDB_ReadOnlyCopy(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	os.RemoveAll("/tmp/CaskDB-copy")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+	assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+	end := db.activeFiles[Str].offset
+	writeTorn(t, db, NewEntry([]byte("k2"), []byte("v2"), Str, StrSet, 0), 10)
+	assert.Nil(t, util.CopyDir("/tmp/CaskDB", "/tmp/CaskDB-copy"))
+	assert.Nil(t, db.Close())
+
+	name := "/tmp/CaskDB-copy" + PathSeparator + fmt.Sprintf(FileNameFormat[Str], 0)
+	assert.Nil(t, os.Truncate(name, end+10))
+	before := readDataFiles(t, "/tmp/CaskDB-copy")
+
+	cfg := DefaultConfig()
+	cfg.DBDir = "/tmp/CaskDB-copy"
+	cfg.ReadOnly = true
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	v, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), v)
+	v, err = db.Get([]byte("k2"))
+	assert.Nil(t, v)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, before, readDataFiles(t, "/tmp/CaskDB-copy"))
+}
@@ -3,18 +3,43 @@ package CaskDB
 import (
 	"github.com/k-si/CaskDB/ds"
 	"github.com/k-si/CaskDB/util"
+	"math"
 	"sync"
 )
 
+// score and member intervals of range queries
+type (
+	ScoreRange = ds.ScoreRange
+	LexRange   = ds.LexRange
+)
+
+// how the scores of a member in several sorted sets are combined
+type Aggregate = ds.Aggregate
+
+const (
+	AggregateSum = ds.AggregateSum
+	AggregateMin = ds.AggregateMin
+	AggregateMax = ds.AggregateMax
+)
+
+// ways to combine sorted sets
+const (
+	zUnion = iota
+	zInter
+	zDiff
+)
+
 type ZSetIndex struct {
 	mu  *sync.RWMutex
 	idx *ds.SortedSet
+	exp expires
 }
 
 func NewZSetIndex() *ZSetIndex {
 	return &ZSetIndex{
 		mu:  &sync.RWMutex{},
 		idx: ds.NewSortedSet(),
+		exp: make(expires),
 	}
 }
 
@@ -32,6 +57,13 @@ func (db *DB) ZAdd(key []byte, score float64, member []byte) error {
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	db.expireIfNeeded(ZSet, key)
+	return db.zAdd(key, score, member)
+}
+
+// caller must hold the write lock
+func (db *DB) zAdd(key []byte, score float64, member []byte) error {
+
 	// store disk
 	keys := db.splice(key, util.Float64ToBytes(score))
 	e := NewEntry(keys, member, ZSet, ZSetZAdd, uint32(len(key)))
@@ -58,16 +90,144 @@ func (db *DB) ZRem(key, member []byte) error {
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	db.expireIfNeeded(ZSet, key)
+	return db.zRem(key, member)
+}
+
+// caller must hold the write lock
+func (db *DB) zRem(key, member []byte) error {
+
 	// store disk
 	e := NewEntry(key, member, ZSet, ZSetZRem, 0)
 	if err := db.StoreFile(e); err != nil {
 		return err
 	}
 	db.zsetIndex.idx.Remove(string(key), string(member))
+	db.forgetEmpty(ZSet, string(key))
 
 	return nil
 }
 
+// add incr to the score of member, a new member starts from 0
+func (db *DB) ZIncrBy(key []byte, incr float64, member []byte) (float64, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return 0, err
+	}
+	if err := db.checkValSize(member); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.expireIfNeeded(ZSet, key)
+
+	_, score := db.zsetIndex.idx.GetScore(string(key), string(member))
+	score += incr
+	if math.IsNaN(score) {
+		return 0, ErrorScoreNaN
+	}
+	if err := db.zAdd(key, score, member); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+// remove count members with the lowest scores, members and scores are returned
+func (db *DB) ZPopMin(key []byte, count int) ([]interface{}, error) {
+	return db.zPop(key, count, false)
+}
+
+// remove count members with the highest scores, members and scores are returned
+func (db *DB) ZPopMax(key []byte, count int) ([]interface{}, error) {
+	return db.zPop(key, count, true)
+}
+
+func (db *DB) zPop(key []byte, count int, max bool) ([]interface{}, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+
+	// lock
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.expireIfNeeded(ZSet, key)
+
+	res := db.zsetIndex.idx.RangeByRank(string(key), 0, count-1, max)
+	if err := db.zRemAll(key, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// remove members with scores in r, the number of them is returned
+func (db *DB) ZRemRangeByScore(key []byte, r ScoreRange) (int, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.expireIfNeeded(ZSet, key)
+
+	res := db.zsetIndex.idx.RangeByScoreRange(string(key), r, false, 0, -1)
+	if err := db.zRemAll(key, res); err != nil {
+		return 0, err
+	}
+	return len(res) / 2, nil
+}
+
+// remove members from rank start to stop, the number of them is returned
+func (db *DB) ZRemRangeByRank(key []byte, start, stop int) (int, error) {
+
+	// check size
+	if err := db.checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.expireIfNeeded(ZSet, key)
+
+	res := db.zsetIndex.idx.RangeByRank(string(key), start, stop, false)
+	if err := db.zRemAll(key, res); err != nil {
+		return 0, err
+	}
+	return len(res) / 2, nil
+}
+
+// remove the members of member and score pairs, caller must hold the write lock
+func (db *DB) zRemAll(key []byte, pairs []interface{}) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	// all of them are removed in a batch, or none
+	wb := db.NewWriteBatch()
+	for i := 0; i < len(pairs); i += 2 {
+		wb.put(NewEntry(key, []byte(pairs[i].(string)), ZSet, ZSetZRem, 0))
+	}
+	return wb.write()
+}
+
 func (db *DB) ZScoreRange(key []byte, from, to float64) ([]interface{}, error) {
 
 	// check
@@ -79,6 +239,9 @@ func (db *DB) ZScoreRange(key []byte, from, to float64) ([]interface{}, error) {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
 
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
 	res := db.zsetIndex.idx.RangeByScore(string(key), from, to)
 
 	return res, nil
@@ -95,25 +258,297 @@ func (db *DB) ZTop(key []byte, n int) ([]interface{}, error) {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
 
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
 	res := db.zsetIndex.idx.Top(string(key), n)
 
 	return res, nil
 }
 
+// rank of member from 0, ordered by score from low to high
+func (db *DB) ZRank(key, member []byte) (bool, int) {
+	return db.zRank(key, member, false)
+}
+
+// rank of member from 0, ordered by score from high to low
+func (db *DB) ZRevRank(key, member []byte) (bool, int) {
+	return db.zRank(key, member, true)
+}
+
+func (db *DB) zRank(key, member []byte, reverse bool) (bool, int) {
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return false, 0
+	}
+	return db.zsetIndex.idx.Rank(string(key), string(member), reverse)
+}
+
+// members and scores from rank start to stop, ordered by score from low
+// to high. negative ranks count from the end, -1 is the last member
+func (db *DB) ZRange(key []byte, start, stop int) ([]interface{}, error) {
+	return db.zRange(key, start, stop, false)
+}
+
+// members and scores from rank start to stop, ordered by score from high to low
+func (db *DB) ZRevRange(key []byte, start, stop int) ([]interface{}, error) {
+	return db.zRange(key, start, stop, true)
+}
+
+func (db *DB) zRange(key []byte, start, stop int, reverse bool) ([]interface{}, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	return db.zsetIndex.idx.RangeByRank(string(key), start, stop, reverse), nil
+}
+
+// members and scores in r from low to high, offset of them are skipped
+// and count of them are returned like LIMIT, negative count means all
+func (db *DB) ZRangeByScore(key []byte, r ScoreRange, offset, count int) ([]interface{}, error) {
+	return db.zRangeByScore(key, r, offset, count, false)
+}
+
+// members and scores in r from high to low, with LIMIT like ZRangeByScore
+func (db *DB) ZRevRangeByScore(key []byte, r ScoreRange, offset, count int) ([]interface{}, error) {
+	return db.zRangeByScore(key, r, offset, count, true)
+}
+
+func (db *DB) zRangeByScore(key []byte, r ScoreRange, offset, count int, reverse bool) ([]interface{}, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	return db.zsetIndex.idx.RangeByScoreRange(string(key), r, reverse, offset, count), nil
+}
+
+// number of members with scores in r
+func (db *DB) ZCount(key []byte, r ScoreRange) int {
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return 0
+	}
+	return db.zsetIndex.idx.Count(string(key), r)
+}
+
+// members in r with LIMIT like ZRangeByScore,
+// all members must have the same score like redis
+func (db *DB) ZRangeByLex(key []byte, r LexRange, offset, count int) ([][]byte, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil
+	}
+	var res [][]byte
+	for _, m := range db.zsetIndex.idx.RangeByLex(string(key), r, offset, count) {
+		res = append(res, []byte(m))
+	}
+	return res, nil
+}
+
+// members and scores in the union of keys, ordered by score. the score of
+// a key is multiplied by its weight, nil weights mean 1, and the scores of a
+// member are combined by agg
+func (db *DB) ZUnion(keys [][]byte, weights []float64, agg Aggregate) ([]interface{}, error) {
+	return db.zCombine(zUnion, keys, weights, agg)
+}
+
+// members and scores in the intersection of keys, like ZUnion
+func (db *DB) ZInter(keys [][]byte, weights []float64, agg Aggregate) ([]interface{}, error) {
+	return db.zCombine(zInter, keys, weights, agg)
+}
+
+// members and scores of the first key which are not in other keys
+func (db *DB) ZDiff(keys ...[]byte) ([]interface{}, error) {
+	return db.zCombine(zDiff, keys, nil, AggregateSum)
+}
+
+// store ZUnion in dst, dst is replaced in a batch.
+// the number of members in dst is returned
+func (db *DB) ZUnionStore(dst []byte, keys [][]byte, weights []float64, agg Aggregate) (int, error) {
+	return db.zStore(zUnion, dst, keys, weights, agg)
+}
+
+// store ZInter in dst like ZUnionStore
+func (db *DB) ZInterStore(dst []byte, keys [][]byte, weights []float64, agg Aggregate) (int, error) {
+	return db.zStore(zInter, dst, keys, weights, agg)
+}
+
+// store ZDiff in dst like ZUnionStore
+func (db *DB) ZDiffStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.zStore(zDiff, dst, keys, nil, AggregateSum)
+}
+
+func (db *DB) checkCombine(keys [][]byte, weights []float64) error {
+	if len(keys) == 0 {
+		return ErrorNoKeys
+	}
+	if weights != nil && len(weights) != len(keys) {
+		return ErrorBadWeights
+	}
+	return db.checkKeysSize(keys...)
+}
+
+func (db *DB) zCombine(op int, keys [][]byte, weights []float64, agg Aggregate) ([]interface{}, error) {
+
+	// check
+	if err := db.checkCombine(keys, weights); err != nil {
+		return nil, err
+	}
+
+	// lock
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	return db.zCombined(op, keys, weights, agg), nil
+}
+
+func (db *DB) zStore(op int, dst []byte, keys [][]byte, weights []float64, agg Aggregate) (int, error) {
+
+	// check
+	if err := db.checkCombine(keys, weights); err != nil {
+		return 0, err
+	}
+	if err := db.checkKeySize(dst); err != nil {
+		return 0, err
+	}
+	if err := db.writable(); err != nil {
+		return 0, err
+	}
+
+	// lock
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.expireIfNeeded(ZSet, dst)
+	res := db.zCombined(op, keys, weights, agg)
+
+	// dst is removed and written again at once
+	wb := db.NewWriteBatch()
+	if db.zsetIndex.idx.KeyExist(string(dst)) {
+		wb.put(NewEntry(dst, nil, ZSet, ZSetClear, 0))
+	}
+	for i := 0; i < len(res); i += 2 {
+		keys := db.splice(dst, util.Float64ToBytes(res[i+1].(float64)))
+		wb.put(NewEntry(keys, []byte(res[i].(string)), ZSet, ZSetZAdd, uint32(len(dst))))
+	}
+	if err := wb.write(); err != nil {
+		return 0, err
+	}
+	return len(res) / 2, nil
+}
+
+// combine keys by op, expired keys are empty. caller must hold the lock
+func (db *DB) zCombined(op int, keys [][]byte, weights []float64, agg Aggregate) []interface{} {
+	ks := make([]string, 0, len(keys))
+	var ws []float64
+	for i, k := range keys {
+		if db.zsetIndex.exp.expired(string(k)) {
+			if op == zInter || (op == zDiff && i == 0) {
+				return nil
+			}
+			continue
+		}
+		ks = append(ks, string(k))
+		if weights != nil {
+			ws = append(ws, weights[i])
+		}
+	}
+
+	idx := db.zsetIndex.idx
+	switch op {
+	case zUnion:
+		return idx.Union(ks, ws, agg)
+	case zInter:
+		return idx.Inter(ks, ws, agg)
+	default:
+		return idx.Diff(ks...)
+	}
+}
+
+// a page of members and scores from cursor like Scan, members are
+// walked in their order, not in score order. cursor is nil at first,
+// and nil is returned after the last page
+func (db *DB) ZScan(key, cursor []byte, match string, count int) ([]interface{}, []byte, error) {
+
+	// check
+	if err := db.checkKeySize(key); err != nil {
+		return nil, nil, err
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	// lock
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.zsetIndex.exp.expired(string(key)) {
+		return nil, nil, nil
+	}
+	members, next := scanPage(db.zsetIndex.idx.MembersFrom(string(key), string(cursor), count+1), count)
+
+	var res []interface{}
+	for _, m := range members {
+		if match == "" || util.GlobMatch(match, m) {
+			_, score := db.zsetIndex.idx.GetScore(string(key), m)
+			res = append(res, m, score)
+		}
+	}
+	return res, next, nil
+}
+
 func (db *DB) ZScore(key, member []byte) (bool, float64) {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return false, 0
+	}
 	return db.zsetIndex.idx.GetScore(string(key), string(member))
 }
 
 func (db *DB) ZCard(key []byte) int {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return 0
+	}
 	return db.zsetIndex.idx.GetCard(string(key))
 }
 
 func (db *DB) ZIsMember(key, member []byte) bool {
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
+	if db.zsetIndex.exp.expired(string(key)) {
+		return false
+	}
 	return db.zsetIndex.idx.MemberExist(string(key), string(member))
 }
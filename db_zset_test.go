@@ -1,7 +1,9 @@
 package CaskDB
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"os"
 	"testing"
 )
@@ -89,3 +91,218 @@ func TestDB_ZScore(t *testing.T) {
 	err = db.Close()
 	assert.Nil(t, err)
 }
+
+func TestDB_ZScan(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	k := []byte("k")
+	assert.Nil(t, db.ZAdd(k, 3, []byte("a")))
+	assert.Nil(t, db.ZAdd(k, 1, []byte("b")))
+	assert.Nil(t, db.ZAdd(k, 2, []byte("c")))
+
+	res, cursor, err := db.ZScan(k, nil, "", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", float64(3), "b", float64(1)}, res)
+	assert.Equal(t, []byte("c"), cursor)
+
+	res, cursor, err = db.ZScan(k, cursor, "[a-b]", 2)
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Nil(t, res)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ZRank_ZRange(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	k := []byte("k")
+	for i, m := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, db.ZAdd(k, float64(i), []byte(m)))
+	}
+
+	ok, r := db.ZRank(k, []byte("c"))
+	assert.True(t, ok)
+	assert.Equal(t, 2, r)
+	_, r = db.ZRevRank(k, []byte("c"))
+	assert.Equal(t, 1, r)
+	ok, _ = db.ZRank(k, []byte("e"))
+	assert.False(t, ok)
+
+	res, err := db.ZRange(k, 1, -2)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"b", float64(1), "c", float64(2)}, res)
+	res, err = db.ZRevRange(k, 0, 0)
+	assert.Equal(t, []interface{}{"d", float64(3)}, res)
+
+	res, err = db.ZRevRangeByScore(k, ScoreRange{Min: 0, Max: 3, MaxEx: true}, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"b", float64(1)}, res)
+	res, err = db.ZRangeByScore(k, ScoreRange{Min: 0, Max: 3, MinEx: true}, 0, -1)
+	assert.Equal(t, []interface{}{"b", float64(1), "c", float64(2), "d", float64(3)}, res)
+	assert.Equal(t, 2, db.ZCount(k, ScoreRange{Min: 1, Max: 2}))
+
+	l := []byte("l")
+	for _, m := range []string{"a", "b", "c"} {
+		assert.Nil(t, db.ZAdd(l, 0, []byte(m)))
+	}
+	ms, err := db.ZRangeByLex(l, LexRange{Min: "a", MinEx: true, NoMax: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, ms)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ZIncrBy_ZPop(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	k := []byte("k")
+	for i, m := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(t, db.ZAdd(k, float64(i), []byte(m)))
+	}
+
+	score, err := db.ZIncrBy(k, 10, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), score)
+	score, err = db.ZIncrBy(k, 1.5, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, score)
+	_, err = db.ZIncrBy(k, math.Inf(1), []byte("g"))
+	assert.Nil(t, err)
+	_, err = db.ZIncrBy(k, math.Inf(-1), []byte("g"))
+	assert.Equal(t, ErrorScoreNaN, err)
+
+	// b 1, f 1.5, c 2, d 3, e 4, a 10, g +inf
+	res, err := db.ZPopMin(k, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"b", float64(1), "f", 1.5}, res)
+	res, err = db.ZPopMax(k, 1)
+	assert.Equal(t, []interface{}{"g", math.Inf(1)}, res)
+	n, err := db.ZRemRangeByScore(k, ScoreRange{Min: 2, Max: 4, MaxEx: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	n, err = db.ZRemRangeByRank(k, -1, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// written entries are replayed the same way
+	for i := 0; i < 2; i++ {
+		res, err = db.ZRange(k, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, []interface{}{"e", float64(4)}, res)
+
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+	}
+
+	// popping all members removes the key
+	res, err = db.ZPopMin(k, 5)
+	assert.Equal(t, 2, len(res))
+	assert.False(t, db.ZKeyExist(k))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ZUnionStore(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	z1, z2, dst := []byte("z1"), []byte("z2"), []byte("dst")
+	assert.Nil(t, db.ZAdd(z1, 1, []byte("a")))
+	assert.Nil(t, db.ZAdd(z1, 2, []byte("b")))
+	assert.Nil(t, db.ZAdd(z2, 3, []byte("b")))
+	assert.Nil(t, db.ZAdd(z2, 4, []byte("c")))
+	assert.Nil(t, db.ZAdd(dst, 100, []byte("old")))
+
+	res, err := db.ZUnion([][]byte{z1, z2}, []float64{2, 1}, AggregateSum)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", float64(2), "c", float64(4), "b", float64(7)}, res)
+	res, err = db.ZInter([][]byte{z1, z2}, nil, AggregateMin)
+	assert.Equal(t, []interface{}{"b", float64(2)}, res)
+	res, err = db.ZDiff(z2, z1)
+	assert.Equal(t, []interface{}{"c", float64(4)}, res)
+	_, err = db.ZUnion([][]byte{z1, z2}, []float64{1}, AggregateSum)
+	assert.Equal(t, ErrorBadWeights, err)
+	_, err = db.ZInter(nil, nil, AggregateSum)
+	assert.Equal(t, ErrorNoKeys, err)
+
+	// dst is replaced
+	n, err := db.ZUnionStore(dst, [][]byte{z1, z2}, nil, AggregateMax)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	for i := 0; i < 2; i++ {
+		res, err = db.ZRange(dst, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, []interface{}{"a", float64(1), "b", float64(3), "c", float64(4)}, res)
+
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+	}
+
+	n, err = db.ZInterStore(dst, [][]byte{z1, z2}, []float64{1, 0}, AggregateSum)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	res, _ = db.ZRange(dst, 0, -1)
+	assert.Equal(t, []interface{}{"b", float64(2)}, res)
+
+	// an empty result removes dst
+	n, err = db.ZDiffStore(dst, z1, z1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, db.ZKeyExist(dst))
+
+	// the source can be the destination
+	n, err = db.ZUnionStore(z1, [][]byte{z1, z2}, nil, AggregateSum)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	_, score := db.ZScore(z1, []byte("b"))
+	assert.Equal(t, float64(5), score)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ZRemRange_Batch(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 200
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	k := []byte("z")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.ZAdd(k, float64(i), []byte(fmt.Sprintf("m%d", i))))
+	}
+
+	// too many members for one file, none of them is removed
+	_, err = db.ZRemRangeByRank(k, 0, -1)
+	assert.Equal(t, ErrorWriteOverFlow, err)
+	assert.Equal(t, 10, db.ZCard(k))
+
+	n, err := db.ZRemRangeByRank(k, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	res, err := db.ZPopMax(k, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"m9", float64(9), "m8", float64(8)}, res)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, db.ZCard(k))
+	assert.Nil(t, db.Close())
+}
@@ -16,8 +16,10 @@ func NewAVLTree() *AVLTree {
 }
 
 func (t *AVLTree) Put(key []byte, value interface{}) {
+	if find(t.root, key) == nil {
+		t.size++
+	}
 	t.root = insert(t.root, key, value)
-	t.size++
 }
 
 func (t *AVLTree) Remove(key []byte) {
@@ -39,6 +41,21 @@ func (t *AVLTree) Size() int {
 	return t.size
 }
 
+// all keys in order
+func (t *AVLTree) GetAllKeys() (res [][]byte) {
+	var walk func(n *aVLTreeNode)
+	walk = func(n *aVLTreeNode) {
+		if n == nil {
+			return
+		}
+		walk(n.left)
+		res = append(res, n.key)
+		walk(n.right)
+	}
+	walk(t.root)
+	return
+}
+
 type aVLTreeNode struct {
 	key    []byte
 	value  interface{}
@@ -268,3 +285,55 @@ func max(v1, v2 int) int {
 		return v2
 	}
 }
+
+// the smallest key greater than key, or equal to key if not strict
+func (t *AVLTree) Ceiling(key []byte, strict bool) ([]byte, interface{}) {
+	var res *aVLTreeNode
+	for cur := t.root; cur != nil; {
+		c := bytes.Compare(cur.key, key)
+		if c > 0 || c == 0 && !strict {
+			res = cur
+			cur = cur.left
+		} else {
+			cur = cur.right
+		}
+	}
+	if res == nil {
+		return nil, nil
+	}
+	return res.key, res.value
+}
+
+// the greatest key less than key, or equal to key if not strict
+func (t *AVLTree) Floor(key []byte, strict bool) ([]byte, interface{}) {
+	var res *aVLTreeNode
+	for cur := t.root; cur != nil; {
+		c := bytes.Compare(cur.key, key)
+		if c < 0 || c == 0 && !strict {
+			res = cur
+			cur = cur.right
+		} else {
+			cur = cur.left
+		}
+	}
+	if res == nil {
+		return nil, nil
+	}
+	return res.key, res.value
+}
+
+func (t *AVLTree) First() ([]byte, interface{}) {
+	if t.root == nil {
+		return nil, nil
+	}
+	n := getMin(t.root)
+	return n.key, n.value
+}
+
+func (t *AVLTree) Last() ([]byte, interface{}) {
+	if t.root == nil {
+		return nil, nil
+	}
+	n := getMax(t.root)
+	return n.key, n.value
+}
@@ -128,6 +128,31 @@ func PrepareAVL() *AVLTree {
 	return avl
 }
 
+func TestAVLTree_Ceiling_Floor(t *testing.T) {
+	avl := NewAVLTree()
+	for _, k := range []string{"b", "d", "f", "h"} {
+		avl.Put([]byte(k), k)
+	}
+
+	k, v := avl.Ceiling([]byte("d"), false)
+	assert.Equal(t, []byte("d"), k)
+	assert.Equal(t, "d", v)
+	k, _ = avl.Ceiling([]byte("d"), true)
+	assert.Equal(t, []byte("f"), k)
+	k, _ = avl.Ceiling([]byte("i"), false)
+	assert.Nil(t, k)
+
+	k, _ = avl.Floor([]byte("e"), false)
+	assert.Equal(t, []byte("d"), k)
+	k, _ = avl.Floor([]byte("b"), true)
+	assert.Nil(t, k)
+
+	k, _ = avl.First()
+	assert.Equal(t, []byte("b"), k)
+	k, _ = avl.Last()
+	assert.Equal(t, []byte("h"), k)
+}
+
 //goos: darwin
 //goarch: arm64
 //pkg: CaskDB/ds
@@ -2,12 +2,14 @@ package ds
 
 type Hash struct {
 	record map[string]hkv
+	keys   *KeySet
+	fields map[string]*KeySet // fields of every key in order
 }
 
 type hkv map[string][]byte
 
 func NewHash() *Hash {
-	return &Hash{record: make(map[string]hkv)}
+	return &Hash{record: make(map[string]hkv), keys: NewKeySet(), fields: make(map[string]*KeySet)}
 }
 
 func (h *Hash) Get(key, k string) []byte {
@@ -20,16 +22,33 @@ func (h *Hash) Get(key, k string) []byte {
 func (h *Hash) Put(key, k string, v []byte) {
 	if _, ok := h.record[key]; !ok {
 		h.record[key] = make(hkv)
+		h.keys.Add(key)
+		h.fields[key] = NewKeySet()
+	}
+	if _, ok := h.record[key][k]; !ok {
+		h.fields[key].Add(k)
 	}
 	h.record[key][k] = v
 }
 
+// the hash is removed with its last field
 func (h *Hash) Remove(key, k string) {
-	if _, ok := h.record[key]; ok {
+	if _, ok := h.record[key][k]; ok {
 		delete(h.record[key], k)
+		h.fields[key].Remove(k)
+		if len(h.record[key]) == 0 {
+			h.Clear(key)
+		}
 	}
 }
 
+// remove the whole hash of key
+func (h *Hash) Clear(key string) {
+	delete(h.record, key)
+	delete(h.fields, key)
+	h.keys.Remove(key)
+}
+
 func (h *Hash) GetAll(key string) (res [][]byte) {
 	if !h.KeyExist(key) {
 		return
@@ -40,6 +59,37 @@ func (h *Hash) GetAll(key string) (res [][]byte) {
 	return
 }
 
+// fields and values of key in a new map
+func (h *Hash) Map(key string) map[string][]byte {
+	if !h.KeyExist(key) {
+		return nil
+	}
+	res := make(map[string][]byte, len(h.record[key]))
+	for k, v := range h.record[key] {
+		res[k] = v
+	}
+	return res
+}
+
+func (h *Hash) Fields(key string) (res []string) {
+	for k := range h.record[key] {
+		res = append(res, k)
+	}
+	return
+}
+
+func (h *Hash) Values(key string) (res [][]byte) {
+	for _, v := range h.record[key] {
+		res = append(res, v)
+	}
+	return
+}
+
+// length of the value of field k, 0 if it does not exist
+func (h *Hash) StrLen(key, k string) int {
+	return len(h.Get(key, k))
+}
+
 func (h *Hash) KeyExist(key string) bool {
 	if _, ok := h.record[key]; ok {
 		return true
@@ -61,4 +111,29 @@ func (h *Hash) Len(key string) int {
 		return len(h.record[key])
 	}
 	return 0
-}
\ No newline at end of file
+}
+
+// n keys not less than key in order
+func (h *Hash) KeysFrom(key string, n int) []string {
+	return h.keys.From(key, n)
+}
+
+// n fields of key not less than field in order
+func (h *Hash) FieldsFrom(key, field string, n int) []string {
+	if !h.KeyExist(key) {
+		return nil
+	}
+	return h.fields[key].From(field, n)
+}
+
+// number of keys
+func (h *Hash) KeyNum() int {
+	return h.keys.Len()
+}
+
+func (h *Hash) GetAllKeys() (res []string) {
+	for k := range h.record {
+		res = append(res, k)
+	}
+	return
+}
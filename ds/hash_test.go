@@ -32,3 +32,29 @@ func TestHash(t *testing.T) {
 	v = h.Get("person", "li si")
 	assert.Nil(t, v)
 }
+
+func TestHash_Clear(t *testing.T) {
+	h := NewHash()
+
+	h.Put("person", "zhang san", []byte("18"))
+	h.Clear("person")
+	assert.False(t, h.KeyExist("person"))
+	assert.Nil(t, h.Get("person", "zhang san"))
+}
+
+func TestHash_Map(t *testing.T) {
+	h := NewHash()
+	assert.Nil(t, h.Map("person"))
+
+	h.Put("person", "zhang san", []byte("18"))
+	m := h.Map("person")
+	assert.Equal(t, map[string][]byte{"zhang san": []byte("18")}, m)
+	assert.Equal(t, []string{"zhang san"}, h.Fields("person"))
+	assert.Equal(t, [][]byte{[]byte("18")}, h.Values("person"))
+	assert.Equal(t, 2, h.StrLen("person", "zhang san"))
+	assert.Equal(t, 0, h.StrLen("person", "li si"))
+
+	// the map is a copy
+	delete(m, "zhang san")
+	assert.True(t, h.FieldExist("person", "zhang san"))
+}
@@ -0,0 +1,56 @@
+package ds
+
+import (
+	"math/rand"
+)
+
+// ordered set of keys, so keys of a map can be walked in pages.
+// it is a treap like SeqList
+type KeySet struct {
+	root *seqNode
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{}
+}
+
+func (s *KeySet) Add(key string) {
+	if findSeq(s.root, key) != nil {
+		return
+	}
+	left, right := splitSeq(s.root, key)
+	n := &seqNode{seq: key, priority: rand.Uint32(), size: 1}
+	s.root = mergeSeq(mergeSeq(left, n), right)
+}
+
+func (s *KeySet) Remove(key string) {
+	left, right := splitSeq(s.root, key)
+	if right != nil && minSeq(right).seq == key {
+		right = removeMin(right)
+	}
+	s.root = mergeSeq(left, right)
+}
+
+func (s *KeySet) Len() int {
+	return nodeSize(s.root)
+}
+
+// n keys not less than key in order
+func (s *KeySet) From(key string, n int) []string {
+	var res []string
+	var walk func(nd *seqNode)
+	walk = func(nd *seqNode) {
+		if nd == nil || len(res) >= n {
+			return
+		}
+		if nd.seq >= key {
+			walk(nd.left)
+			if len(res) < n {
+				res = append(res, nd.seq)
+			}
+		}
+		walk(nd.right)
+	}
+	walk(s.root)
+	return res
+}
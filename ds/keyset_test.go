@@ -0,0 +1,59 @@
+package ds
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestKeySet_From(t *testing.T) {
+	s := NewKeySet()
+	for i := 9; i >= 0; i-- {
+		s.Add(strconv.Itoa(i))
+	}
+	s.Add("5")
+	assert.Equal(t, 10, s.Len())
+
+	assert.Equal(t, []string{"0", "1", "2"}, s.From("", 3))
+	assert.Equal(t, []string{"5", "6"}, s.From("5", 2))
+	assert.Equal(t, []string{"6", "7"}, s.From("55", 2))
+	assert.Equal(t, []string{"9"}, s.From("9", 5))
+	assert.Nil(t, s.From("a", 5))
+
+	s.Remove("6")
+	s.Remove("x")
+	assert.Equal(t, []string{"5", "7"}, s.From("5", 2))
+	assert.Equal(t, 9, s.Len())
+}
+
+func TestHash_KeysFrom(t *testing.T) {
+	h := NewHash()
+	h.Put("b", "f2", nil)
+	h.Put("a", "f1", nil)
+	h.Put("b", "f1", nil)
+	h.Put("c", "f1", nil)
+	h.Clear("c")
+	assert.Equal(t, []string{"a", "b"}, h.KeysFrom("", 5))
+	assert.Equal(t, []string{"f2"}, h.FieldsFrom("b", "f11", 5))
+}
+
+func TestMembersFrom(t *testing.T) {
+	s := NewSet()
+	ss := NewSortedSet()
+	for i := 9; i >= 0; i-- {
+		s.Add("s", strconv.Itoa(i))
+		ss.Add("z", strconv.Itoa(i), float64(-i))
+	}
+	s.Remove("s", "3")
+	s.Move("s", "t", "4")
+	ss.Add("z", "5", 100)
+	ss.Remove("z", "3")
+
+	assert.Equal(t, []string{"2", "5", "6"}, s.MembersFrom("s", "2", 3))
+	assert.Equal(t, []string{"4"}, s.MembersFrom("t", "", 3))
+	assert.Equal(t, []string{"2", "4", "5"}, ss.MembersFrom("z", "2", 3))
+	assert.Nil(t, ss.MembersFrom("none", "", 3))
+
+	s.Clear("s")
+	assert.Nil(t, s.MembersFrom("s", "", 3))
+}
@@ -52,6 +52,12 @@ func (l *List) Pop(front bool, key string) []byte {
 	return v
 }
 
+// remove the whole list of key
+func (l *List) Clear(key string) {
+	delete(l.record, key)
+	delete(l.table, key)
+}
+
 // remove some element,
 // if n == 0, remove all element that meet the requirements
 // if n > 0, from left to right, remove n elements that meet the requirements
@@ -0,0 +1,297 @@
+package ds
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+// lists whose elements are ordered by sequences. a sequence never changes,
+// so an element can be found by its sequence, or by its position in list.
+// sequences are compared as bytes: pushed elements take 8-byte ordinals,
+// inserted elements take a longer sequence between their neighbours
+type SeqList struct {
+	record map[string]*seqNode // key -> root of treap
+	keys   *KeySet
+}
+
+type SeqElem struct {
+	Seq   string
+	Value interface{}
+}
+
+type seqNode struct {
+	seq      string
+	value    interface{}
+	priority uint32
+	size     int // nodes in this subtree
+	left     *seqNode
+	right    *seqNode
+}
+
+func NewSeqList() *SeqList {
+	return &SeqList{record: make(map[string]*seqNode), keys: NewKeySet()}
+}
+
+// put value at seq, replace the value if seq exists
+func (l *SeqList) Put(key, seq string, value interface{}) {
+	root, ok := l.record[key]
+	if n := findSeq(root, seq); n != nil {
+		n.value = value
+		return
+	}
+	if !ok {
+		l.keys.Add(key)
+	}
+	left, right := splitSeq(root, seq)
+	n := &seqNode{seq: seq, value: value, priority: rand.Uint32(), size: 1}
+	l.record[key] = mergeSeq(mergeSeq(left, n), right)
+}
+
+func (l *SeqList) Remove(key, seq string) {
+	root, ok := l.record[key]
+	if !ok {
+		return
+	}
+	left, right := splitSeq(root, seq)
+
+	// the first node of right is seq if it exists
+	if right != nil && minSeq(right).seq == seq {
+		right = removeMin(right)
+	}
+	if root = mergeSeq(left, right); root == nil {
+		delete(l.record, key)
+		l.keys.Remove(key)
+		return
+	}
+	l.record[key] = root
+}
+
+func (l *SeqList) Get(key, seq string) interface{} {
+	if n := findSeq(l.record[key], seq); n != nil {
+		return n.value
+	}
+	return nil
+}
+
+// element at position n, n must be in [0, Len)
+func (l *SeqList) At(key string, n int) *SeqElem {
+	cur := l.record[key]
+	if cur == nil || n < 0 || n >= cur.size {
+		return nil
+	}
+	for cur != nil {
+		ls := nodeSize(cur.left)
+		if n < ls {
+			cur = cur.left
+		} else if n == ls {
+			return &SeqElem{Seq: cur.seq, Value: cur.value}
+		} else {
+			n -= ls + 1
+			cur = cur.right
+		}
+	}
+	return nil
+}
+
+func (l *SeqList) First(key string) *SeqElem {
+	return l.At(key, 0)
+}
+
+func (l *SeqList) Last(key string) *SeqElem {
+	return l.At(key, l.Len(key)-1)
+}
+
+// elements from start to stop, negative index counts from the end
+func (l *SeqList) Range(key string, start, stop int) []*SeqElem {
+	root, ok := l.record[key]
+	if !ok {
+		return nil
+	}
+	start, stop, ok = correctRange(start, stop, root.size)
+	if !ok {
+		return nil
+	}
+
+	res := make([]*SeqElem, 0, stop-start+1)
+	var walk func(n *seqNode, base int)
+	walk = func(n *seqNode, base int) {
+		if n == nil || base > stop || base+n.size <= start {
+			return
+		}
+		pos := base + nodeSize(n.left)
+		walk(n.left, base)
+		if pos >= start && pos <= stop {
+			res = append(res, &SeqElem{Seq: n.seq, Value: n.value})
+		}
+		walk(n.right, pos+1)
+	}
+	walk(root, 0)
+	return res
+}
+
+func (l *SeqList) Len(key string) int {
+	return nodeSize(l.record[key])
+}
+
+func (l *SeqList) KeyExist(key string) bool {
+	_, ok := l.record[key]
+	return ok
+}
+
+// remove the whole list of key
+func (l *SeqList) Clear(key string) {
+	delete(l.record, key)
+	l.keys.Remove(key)
+}
+
+// number of keys
+func (l *SeqList) KeyNum() int {
+	return l.keys.Len()
+}
+
+// n keys not less than key in order
+func (l *SeqList) KeysFrom(key string, n int) []string {
+	return l.keys.From(key, n)
+}
+
+func (l *SeqList) GetAllKeys() (res []string) {
+	for k := range l.record {
+		res = append(res, k)
+	}
+	return
+}
+
+func nodeSize(n *seqNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *seqNode) update() {
+	n.size = nodeSize(n.left) + nodeSize(n.right) + 1
+}
+
+func findSeq(cur *seqNode, seq string) *seqNode {
+	for cur != nil {
+		if seq < cur.seq {
+			cur = cur.left
+		} else if seq > cur.seq {
+			cur = cur.right
+		} else {
+			return cur
+		}
+	}
+	return nil
+}
+
+func minSeq(n *seqNode) *seqNode {
+	for n.left != nil {
+		n = n.left
+	}
+	return n
+}
+
+func removeMin(n *seqNode) *seqNode {
+	if n.left == nil {
+		return n.right
+	}
+	n.left = removeMin(n.left)
+	n.update()
+	return n
+}
+
+// split tree into nodes less than seq and the others
+func splitSeq(n *seqNode, seq string) (*seqNode, *seqNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.seq < seq {
+		l, r := splitSeq(n.right, seq)
+		n.right = l
+		n.update()
+		return n, r
+	}
+	l, r := splitSeq(n.left, seq)
+	n.left = r
+	n.update()
+	return l, n
+}
+
+// merge two trees, all nodes of a are less than nodes of b
+func mergeSeq(a, b *seqNode) *seqNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = mergeSeq(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = mergeSeq(a, b.left)
+	b.update()
+	return b
+}
+
+// sequence of the first element of a new list
+func FirstSeq() string {
+	return ordinalSeq(0)
+}
+
+// sequence less than seq, it is 8 bytes
+func SeqBefore(seq string) string {
+	return ordinalSeq(seqOrdinal(seq) - 1)
+}
+
+// sequence greater than seq, it is 8 bytes
+func SeqAfter(seq string) string {
+	return ordinalSeq(seqOrdinal(seq) + 1)
+}
+
+// sequence between a and b, a must be less than b.
+// there is always a sequence between two, because no sequence
+// longer than 8 bytes ends with 0
+func SeqBetween(a, b string) string {
+	var c []byte
+	for i := 0; ; i++ {
+		da := 0
+		if i < len(a) {
+			da = int(a[i])
+		}
+		db := int(b[i])
+		if da == db {
+			c = append(c, byte(da))
+			continue
+		}
+		if db-da > 1 {
+			return string(append(c, byte((da+db)/2)))
+		}
+
+		// no room here, go on with a larger tail than a
+		c = append(c, byte(da))
+		for j := i + 1; ; j++ {
+			d := 0
+			if j < len(a) {
+				d = int(a[j])
+			}
+			if d < 255 {
+				return string(append(c, byte((d+256)/2)))
+			}
+			c = append(c, byte(d))
+		}
+	}
+}
+
+// ordinals are stored with the sign bit flipped, so they sort as bytes
+func ordinalSeq(n int64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n)^(1<<63))
+	return string(buf)
+}
+
+func seqOrdinal(seq string) int64 {
+	return int64(binary.BigEndian.Uint64([]byte(seq[:8])) ^ (1 << 63))
+}
@@ -0,0 +1,89 @@
+package ds
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSeqList_PutRemove(t *testing.T) {
+	l := NewSeqList()
+	key := "name"
+
+	// c a b
+	a := FirstSeq()
+	b := SeqAfter(a)
+	c := SeqBefore(a)
+	l.Put(key, a, "a")
+	l.Put(key, b, "b")
+	l.Put(key, c, "c")
+	assert.Equal(t, 3, l.Len(key))
+	assert.Equal(t, "c", l.First(key).Value)
+	assert.Equal(t, "b", l.Last(key).Value)
+	assert.Equal(t, "a", l.At(key, 1).Value)
+	assert.Nil(t, l.At(key, 3))
+
+	l.Put(key, a, "aa")
+	assert.Equal(t, "aa", l.Get(key, a))
+	assert.Equal(t, 3, l.Len(key))
+
+	l.Remove(key, a)
+	l.Remove(key, a)
+	assert.Nil(t, l.Get(key, a))
+	assert.Equal(t, 2, l.Len(key))
+	l.Remove(key, b)
+	l.Remove(key, c)
+	assert.False(t, l.KeyExist(key))
+}
+
+func TestSeqList_Range(t *testing.T) {
+	l := NewSeqList()
+	key := "name"
+
+	seq := FirstSeq()
+	for i := 0; i < 100; i++ {
+		l.Put(key, seq, i)
+		seq = SeqAfter(seq)
+	}
+	res := l.Range(key, 10, 19)
+	assert.Equal(t, 10, len(res))
+	for i, e := range res {
+		assert.Equal(t, 10+i, e.Value)
+	}
+	assert.Equal(t, 99, l.Range(key, -1, -1)[0].Value)
+	assert.Equal(t, 100, len(l.Range(key, 0, -1)))
+	assert.Nil(t, l.Range(key, 5, 4))
+	assert.Nil(t, l.Range("none", 0, -1))
+}
+
+func TestSeqBetween(t *testing.T) {
+	l := NewSeqList()
+	key := "name"
+
+	a := FirstSeq()
+	b := SeqAfter(a)
+	l.Put(key, a, "a")
+	l.Put(key, b, "b")
+
+	// always insert right after a
+	for i := 0; i < 1000; i++ {
+		s := SeqBetween(a, l.At(key, 1).Seq)
+		assert.True(t, a < s)
+		assert.True(t, s < l.At(key, 1).Seq)
+		l.Put(key, s, i)
+	}
+
+	// always insert right before b
+	for i := 0; i < 1000; i++ {
+		s := SeqBetween(l.At(key, l.Len(key)-2).Seq, b)
+		assert.True(t, s < b)
+		l.Put(key, s, i)
+	}
+	assert.Equal(t, 2002, l.Len(key))
+	assert.Equal(t, "a", l.First(key).Value)
+	assert.Equal(t, 999, l.At(key, 1).Value)
+	assert.Equal(t, "b", l.Last(key).Value)
+
+	// pushed elements are still out of the range
+	assert.True(t, SeqBefore(l.First(key).Seq) < a)
+	assert.True(t, SeqAfter(l.At(key, 2000).Seq) > l.At(key, 2000).Seq)
+}
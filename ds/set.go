@@ -1,29 +1,50 @@
 package ds
 
+import "math/rand"
+
 type (
 	Set struct {
-		record SRecord
+		record  SRecord
+		keys    *KeySet
+		members map[string]*KeySet // members of every key in order
 	}
 
 	SRecord map[string]map[string]struct{}
 )
 
 func NewSet() *Set {
-	return &Set{record: make(SRecord)}
+	return &Set{record: make(SRecord), keys: NewKeySet(), members: make(map[string]*KeySet)}
 }
 
 func (s *Set) Add(key string, value string) {
 	if s.record[key] == nil {
 		s.record[key] = make(map[string]struct{})
+		s.keys.Add(key)
+		s.members[key] = NewKeySet()
+	}
+	if _, ok := s.record[key][value]; !ok {
+		s.record[key][value] = struct{}{}
+		s.members[key].Add(value)
 	}
-	s.record[key][value] = struct{}{}
 }
 
+// the set is removed with its last member
 func (s *Set) Remove(key, value string) {
-	if _, ok := s.record[key]; !ok {
+	if _, ok := s.record[key][value]; !ok {
 		return
 	}
 	delete(s.record[key], value)
+	s.members[key].Remove(value)
+	if len(s.record[key]) == 0 {
+		s.Clear(key)
+	}
+}
+
+// remove the whole set of key
+func (s *Set) Clear(key string) {
+	delete(s.record, key)
+	delete(s.members, key)
+	s.keys.Remove(key)
 }
 
 // move value from src set to dest set
@@ -31,15 +52,12 @@ func (s *Set) Move(src, dest string, value string) {
 	if _, ok := s.record[src]; !ok {
 		return
 	}
-	if _, ok := s.record[src][value]; !ok {
+	if _, ok := s.record[src][value]; !ok || src == dest {
 		return
 	}
 
-	if s.record[dest] == nil {
-		s.record[dest] = make(map[string]struct{})
-	}
-	s.record[dest][value] = struct{}{}
-	delete(s.record[src], value)
+	s.Add(dest, value)
+	s.Remove(src, value)
 }
 
 // set1 + set2 + ... + setN
@@ -90,6 +108,103 @@ func (s *Set) Diff(keys ...string) []string {
 	return res
 }
 
+// members of the first set which are not in other sets, like redis
+func (s *Set) Subtract(keys ...string) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var res []string
+Next:
+	for v := range s.record[keys[0]] {
+		for _, k := range keys[1:] {
+			if s.ValExist(k, v) {
+				continue Next
+			}
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// set1 * set2 * ... * setN
+func (s *Set) Inter(keys ...string) []string {
+	var res []string
+	s.inter(keys, func(v string) bool {
+		res = append(res, v)
+		return true
+	})
+	return res
+}
+
+// size of the intersection, counting stops at limit, 0 means no limit
+func (s *Set) InterCard(limit int, keys ...string) int {
+	n := 0
+	s.inter(keys, func(string) bool {
+		n++
+		return limit <= 0 || n < limit
+	})
+	return n
+}
+
+// walk the members of the intersection until fn returns false
+func (s *Set) inter(keys []string, fn func(string) bool) {
+	if len(keys) == 0 {
+		return
+	}
+
+	// walk the smallest one
+	smallest := keys[0]
+	for _, k := range keys {
+		if !s.KeyExist(k) {
+			return
+		}
+		if len(s.record[k]) < len(s.record[smallest]) {
+			smallest = k
+		}
+	}
+
+Next:
+	for v := range s.record[smallest] {
+		for _, k := range keys {
+			if !s.ValExist(k, v) {
+				continue Next
+			}
+		}
+		if !fn(v) {
+			return
+		}
+	}
+}
+
+// random members of key, like redis, count members at most are distinct
+// if count is positive, and -count members may repeat if it is negative
+func (s *Set) Random(key string, count int) []string {
+	if !s.KeyExist(key) || count == 0 {
+		return nil
+	}
+	members := make([]string, 0, len(s.record[key]))
+	for v := range s.record[key] {
+		members = append(members, v)
+	}
+
+	if count < 0 {
+		res := make([]string, -count)
+		for i := range res {
+			res[i] = members[rand.Intn(len(members))]
+		}
+		return res
+	}
+
+	if count > len(members) {
+		count = len(members)
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	return members[:count]
+}
+
 func (s *Set) Scan(key string) (res [][]byte) {
 	if !s.KeyExist(key) {
 		return
@@ -122,3 +237,28 @@ func (s *Set) Len(key string) int {
 	}
 	return 0
 }
+
+// n keys not less than key in order
+func (s *Set) KeysFrom(key string, n int) []string {
+	return s.keys.From(key, n)
+}
+
+// n members of key not less than member in order
+func (s *Set) MembersFrom(key, member string, n int) []string {
+	if !s.KeyExist(key) {
+		return nil
+	}
+	return s.members[key].From(member, n)
+}
+
+// number of keys
+func (s *Set) KeyNum() int {
+	return s.keys.Len()
+}
+
+func (s *Set) GetAllKeys() (res []string) {
+	for k := range s.record {
+		res = append(res, k)
+	}
+	return
+}
@@ -2,6 +2,7 @@ package ds
 
 import (
 	"github.com/stretchr/testify/assert"
+	"sort"
 	"testing"
 )
 
@@ -60,6 +61,39 @@ func TestSet_Diff(t *testing.T) {
 	//assert.Equal(t, "e", v[2])
 }
 
+func TestSet_Inter_Subtract(t *testing.T) {
+	set := NewSet()
+	for _, v := range []string{"a", "b", "c"} {
+		set.Add("set1", v)
+	}
+	set.Add("set2", "b")
+	set.Add("set2", "c")
+	set.Add("set2", "d")
+
+	v := set.Inter("set1", "set2")
+	sort.Strings(v)
+	assert.Equal(t, []string{"b", "c"}, v)
+	assert.Equal(t, 2, set.InterCard(0, "set1", "set2"))
+	assert.Equal(t, 1, set.InterCard(1, "set1", "set2"))
+	assert.Nil(t, set.Inter("set1", "none"))
+
+	assert.Equal(t, []string{"a"}, set.Subtract("set1", "set2", "none"))
+	assert.Nil(t, set.Subtract("none", "set1"))
+}
+
+func TestSet_Random(t *testing.T) {
+	set := NewSet()
+	set.Add("set1", "a")
+	set.Add("set1", "b")
+
+	v := set.Random("set1", 5)
+	sort.Strings(v)
+	assert.Equal(t, []string{"a", "b"}, v)
+	assert.Equal(t, 1, len(set.Random("set1", 1)))
+	assert.Equal(t, 5, len(set.Random("set1", -5)))
+	assert.Nil(t, set.Random("none", 1))
+}
+
 func TestSet_KeyExist(t *testing.T) {
 	set := NewSet()
 
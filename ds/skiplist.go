@@ -16,12 +16,15 @@ type SkipList struct {
 	level int // the highest level of current skip list
 	size  int
 	head  *skipListNode
+	tail  *skipListNode
 }
 
 type skipListNode struct {
 	score  float64
 	member string
 	next   []*skipListNode
+	span   []int // span[i] = number of nodes from this node to next[i], the rank distance
+	prev   *skipListNode
 }
 
 func NewSkipList() *SkipList {
@@ -37,9 +40,15 @@ func newSkipListNode(score float64, member string, level int) *skipListNode {
 		score:  score,
 		member: member,
 		next:   make([]*skipListNode, level),
+		span:   make([]int, level),
 	}
 }
 
+// whether node n is before score and member
+func (n *skipListNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
 // find node
 // opt == 0, find first node that >= score
 // opt == 1, find last node that <= score
@@ -72,11 +81,17 @@ func (sl *SkipList) Find(score float64, opt int) *skipListNode {
 
 func (sl *SkipList) Insert(score float64, member string) *skipListNode {
 
-	// store the front node of each layer into update
+	// store the front node of each layer into update,
+	// and the rank of the front node into rank
 	update := make([]*skipListNode, MaxLevel)
+	rank := make([]int, MaxLevel)
 	x := sl.head
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.next[i] != nil && (x.next[i].score < score || (x.next[i].score == score && (x.next[i].member < member))) {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.next[i] != nil && x.next[i].before(score, member) {
+			rank[i] += x.span[i]
 			x = x.next[i]
 		}
 		update[i] = x
@@ -89,6 +104,7 @@ func (sl *SkipList) Insert(score float64, member string) *skipListNode {
 	if lvl > sl.level {
 		for i := sl.level; i < lvl; i++ {
 			update[i] = sl.head
+			update[i].span[i] = sl.size
 		}
 		sl.level = lvl
 	}
@@ -98,6 +114,22 @@ func (sl *SkipList) Insert(score float64, member string) *skipListNode {
 	for i := 0; i < lvl; i++ {
 		newNode.next[i] = update[i].next[i]
 		update[i].next[i] = newNode
+		newNode.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+
+	// higher levels skip one more node
+	for i := lvl; i < sl.level; i++ {
+		update[i].span[i]++
+	}
+
+	if update[0] != sl.head {
+		newNode.prev = update[0]
+	}
+	if newNode.next[0] != nil {
+		newNode.next[0].prev = newNode
+	} else {
+		sl.tail = newNode
 	}
 
 	sl.size++
@@ -111,24 +143,32 @@ func (sl *SkipList) Delete(score float64, member string) {
 	update := make([]*skipListNode, MaxLevel)
 	x := sl.head
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.next[i] != nil && (x.next[i].score < score || (x.next[i].score == score && (x.next[i].member < member))) {
+		for x.next[i] != nil && x.next[i].before(score, member) {
 			x = x.next[i]
 		}
 		update[i] = x
 	}
 
-	// check whether the same key already exists at the position to be inserted
+	// check whether the node exists
 	x = x.next[0]
-	if x != nil && x.score == score && x.member == member {
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
 
-		// remove this node
-		for i := 0; i < sl.level; i++ {
-			if update[i].next[i] != x {
-				break
-			}
+	// remove this node
+	for i := 0; i < sl.level; i++ {
+		if update[i].next[i] == x {
+			update[i].span[i] += x.span[i] - 1
 			update[i].next[i] = x.next[i]
+		} else {
+			update[i].span[i]--
 		}
 	}
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	} else {
+		sl.tail = x.prev
+	}
 
 	// chang level of skip list
 	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
@@ -138,6 +178,57 @@ func (sl *SkipList) Delete(score float64, member string) {
 	sl.size--
 }
 
+// rank of the node from 1, 0 means it does not exist
+func (sl *SkipList) Rank(score float64, member string) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && (x.next[i].before(score, member) || (x.next[i].score == score && x.next[i].member == member)) {
+			rank += x.span[i]
+			x = x.next[i]
+		}
+		if x != sl.head && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// node of rank from 1, nil if rank is out of range
+func (sl *SkipList) ByRank(rank int) *skipListNode {
+	if rank < 1 || rank > sl.size {
+		return nil
+	}
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && traversed+x.span[i] <= rank {
+			traversed += x.span[i]
+			x = x.next[i]
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// first node in lex range, members of nodes are compared only,
+// so the result is right only if all nodes have the same score
+func (sl *SkipList) FirstInLex(r LexRange) *skipListNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && !r.gteMin(x.next[i].member) {
+			x = x.next[i]
+		}
+	}
+	x = x.next[0]
+	if x == nil || !r.lteMax(x.member) {
+		return nil
+	}
+	return x
+}
+
 // get random level
 func (sl *SkipList) randomLevel() int {
 	level := 1
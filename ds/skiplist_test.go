@@ -1,6 +1,11 @@
 package ds
 
-import "testing"
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
 
 func TestSkipList_Insert_Delete(t *testing.T) {
 	sl := NewSkipList()
@@ -9,4 +14,37 @@ func TestSkipList_Insert_Delete(t *testing.T) {
 	sl.Insert(10, "zhang san")
 	sl.Insert(11, "li si")
 	sl.Delete(10, "li si")
+	assert.Equal(t, 2, sl.size)
+}
+
+func TestSkipList_Rank(t *testing.T) {
+	sl := NewSkipList()
+	scores := make(map[string]float64)
+	for i := 0; i < 200; i++ {
+		m := fmt.Sprintf("m%d", rand.Intn(100))
+		if s, ok := scores[m]; ok {
+			sl.Delete(s, m)
+			delete(scores, m)
+			continue
+		}
+		scores[m] = float64(rand.Intn(20))
+		sl.Insert(scores[m], m)
+	}
+	assert.Equal(t, len(scores), sl.size)
+
+	// ranks follow the order of nodes, both ways
+	rank := 0
+	for p := sl.head.next[0]; p != nil; p = p.next[0] {
+		rank++
+		assert.Equal(t, rank, sl.Rank(p.score, p.member))
+		assert.Equal(t, p, sl.ByRank(rank))
+	}
+	assert.Equal(t, sl.size, rank)
+	for p := sl.tail; p != nil; p = p.prev {
+		assert.Equal(t, rank, sl.Rank(p.score, p.member))
+		rank--
+	}
+	assert.Equal(t, 0, rank)
+	assert.Equal(t, 0, sl.Rank(100, "none"))
+	assert.Nil(t, sl.ByRank(sl.size+1))
 }
@@ -1,12 +1,19 @@
 package ds
 
+import (
+	"math"
+	"sort"
+)
+
 type SortedSet struct {
 	record map[string]*zSet
+	keys   *KeySet
 }
 
 type zSet struct {
-	sl   *SkipList
-	dict map[string]*skipListNode
+	sl      *SkipList
+	dict    map[string]*skipListNode
+	members *KeySet // members in order, not in score order
 }
 
 //type pair struct {
@@ -22,19 +29,21 @@ type zSet struct {
 //}
 
 func NewSortedSet() *SortedSet {
-	return &SortedSet{record: make(map[string]*zSet)}
+	return &SortedSet{record: make(map[string]*zSet), keys: NewKeySet()}
 }
 
 func newZSet() *zSet {
 	return &zSet{
-		sl:   NewSkipList(),
-		dict: make(map[string]*skipListNode),
+		sl:      NewSkipList(),
+		dict:    make(map[string]*skipListNode),
+		members: NewKeySet(),
 	}
 }
 
 func (ss *SortedSet) Add(key, member string, score float64) {
 	if !ss.KeyExist(key) {
 		ss.record[key] = newZSet()
+		ss.keys.Add(key)
 	}
 
 	zset := ss.record[key]
@@ -45,6 +54,8 @@ func (ss *SortedSet) Add(key, member string, score float64) {
 			return
 		}
 		zset.sl.Delete(n.score, member)
+	} else {
+		zset.members.Add(member)
 	}
 	node := zset.sl.Insert(score, member)
 	zset.dict[member] = node
@@ -55,16 +66,24 @@ func (ss *SortedSet) Remove(key, member string) {
 		n := ss.record[key].dict[member]
 		ss.record[key].sl.Delete(n.score, member)
 		delete(ss.record[key].dict, member)
+		ss.record[key].members.Remove(member)
+		if len(ss.record[key].dict) == 0 {
+			ss.Clear(key)
+		}
 	}
 }
 
+// remove the whole sorted set of key
+func (ss *SortedSet) Clear(key string) {
+	delete(ss.record, key)
+	ss.keys.Remove(key)
+}
+
 func (ss *SortedSet) Top(key string, n int) (res []interface{}) {
 	if ss.KeyExist(key) {
 		zset := ss.record[key]
-		for p, i := zset.sl.head, 0; i < n && p != nil; p = p.next[0] {
-			if p != zset.sl.head {
-				res = append(res, p.member, p.score)
-			}
+		for p, i := zset.sl.head.next[0], 0; i < n && p != nil; p, i = p.next[0], i+1 {
+			res = append(res, p.member, p.score)
 		}
 	}
 	return
@@ -111,6 +130,31 @@ func (ss *SortedSet) KeyExist(key string) bool {
 	return exist
 }
 
+// n keys not less than key in order
+func (ss *SortedSet) KeysFrom(key string, n int) []string {
+	return ss.keys.From(key, n)
+}
+
+// n members of key not less than member in order, members are not in score order
+func (ss *SortedSet) MembersFrom(key, member string, n int) []string {
+	if !ss.KeyExist(key) {
+		return nil
+	}
+	return ss.record[key].members.From(member, n)
+}
+
+// number of keys
+func (ss *SortedSet) KeyNum() int {
+	return ss.keys.Len()
+}
+
+func (ss *SortedSet) GetAllKeys() (res []string) {
+	for k := range ss.record {
+		res = append(res, k)
+	}
+	return
+}
+
 func (ss *SortedSet) MemberExist(key, member string) bool {
 	if ss.KeyExist(key) {
 		_, exist := ss.record[key].dict[member]
@@ -119,6 +163,301 @@ func (ss *SortedSet) MemberExist(key, member string) bool {
 	return false
 }
 
+// score interval, bounds are inclusive unless they are exclusive
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r ScoreRange) gteMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) lteMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// member interval, NoMin and NoMax mean unbounded like "-" and "+" of redis
+type LexRange struct {
+	Min, Max     string
+	MinEx, MaxEx bool
+	NoMin, NoMax bool
+}
+
+func (r LexRange) gteMin(member string) bool {
+	switch {
+	case r.NoMin:
+		return true
+	case r.MinEx:
+		return member > r.Min
+	}
+	return member >= r.Min
+}
+
+func (r LexRange) lteMax(member string) bool {
+	switch {
+	case r.NoMax:
+		return true
+	case r.MaxEx:
+		return member < r.Max
+	}
+	return member <= r.Max
+}
+
+// rank of member from 0, ordered by score from high to low if reverse
+func (ss *SortedSet) Rank(key, member string, reverse bool) (bool, int) {
+	if !ss.MemberExist(key, member) {
+		return false, 0
+	}
+	zset := ss.record[key]
+	n := zset.dict[member]
+	r := zset.sl.Rank(n.score, n.member)
+	if reverse {
+		return true, zset.sl.size - r
+	}
+	return true, r - 1
+}
+
+// members and scores from rank start to stop, negative ranks count from the end
+func (ss *SortedSet) RangeByRank(key string, start, stop int, reverse bool) (res []interface{}) {
+	if !ss.KeyExist(key) {
+		return
+	}
+	sl := ss.record[key].sl
+	start, stop, ok := correctRange(start, stop, sl.size)
+	if !ok {
+		return
+	}
+	if reverse {
+		for p, i := sl.ByRank(sl.size-start), start; i <= stop; p, i = p.prev, i+1 {
+			res = append(res, p.member, p.score)
+		}
+		return
+	}
+	for p, i := sl.ByRank(start+1), start; i <= stop; p, i = p.next[0], i+1 {
+		res = append(res, p.member, p.score)
+	}
+	return
+}
+
+// members and scores in r, offset of them are skipped and count of them
+// are returned, negative count means all
+func (ss *SortedSet) RangeByScoreRange(key string, r ScoreRange, reverse bool, offset, count int) (res []interface{}) {
+	if offset < 0 {
+		return
+	}
+	p := ss.firstInRange(key, r, reverse)
+	for ; p != nil && offset > 0; offset-- {
+		p = step(p, reverse)
+	}
+	for ; p != nil && count != 0; count-- {
+		if !r.gteMin(p.score) || !r.lteMax(p.score) {
+			break
+		}
+		res = append(res, p.member, p.score)
+		p = step(p, reverse)
+	}
+	return
+}
+
+// number of members in r
+func (ss *SortedSet) Count(key string, r ScoreRange) int {
+	first := ss.firstInRange(key, r, false)
+	if first == nil {
+		return 0
+	}
+	last := ss.firstInRange(key, r, true)
+	sl := ss.record[key].sl
+	return sl.Rank(last.score, last.member) - sl.Rank(first.score, first.member) + 1
+}
+
+// the lowest node in r, or the highest one if reverse
+func (ss *SortedSet) firstInRange(key string, r ScoreRange, reverse bool) *skipListNode {
+	if !ss.KeyExist(key) {
+		return nil
+	}
+	sl := ss.record[key].sl
+	var p *skipListNode
+	if reverse {
+		p = sl.Find(r.Max, LE)
+		for p != nil && !r.lteMax(p.score) {
+			p = p.prev
+		}
+	} else {
+		p = sl.Find(r.Min, GE)
+		for p != nil && !r.gteMin(p.score) {
+			p = p.next[0]
+		}
+	}
+	if p == nil || !r.gteMin(p.score) || !r.lteMax(p.score) {
+		return nil
+	}
+	return p
+}
+
+func step(p *skipListNode, reverse bool) *skipListNode {
+	if reverse {
+		return p.prev
+	}
+	return p.next[0]
+}
+
+// members in r, members must have the same score like redis
+func (ss *SortedSet) RangeByLex(key string, r LexRange, offset, count int) (res []string) {
+	if !ss.KeyExist(key) || offset < 0 {
+		return
+	}
+	p := ss.record[key].sl.FirstInLex(r)
+	for ; p != nil && offset > 0; offset-- {
+		p = p.next[0]
+	}
+	for ; p != nil && count != 0 && r.lteMax(p.member); count-- {
+		res = append(res, p.member)
+		p = p.next[0]
+	}
+	return
+}
+
+// how the scores of a member in several sorted sets are combined
+type Aggregate int
+
+const (
+	AggregateSum Aggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+func (a Aggregate) combine(x, y float64) float64 {
+	switch a {
+	case AggregateMin:
+		return math.Min(x, y)
+	case AggregateMax:
+		return math.Max(x, y)
+	}
+	return zeroNaN(x + y)
+}
+
+// inf - inf and inf * 0 are 0 like redis
+func zeroNaN(f float64) float64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+// weight of the ith key, nil weights mean 1
+func weightOf(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[i]
+}
+
+// members and scores in the union of keys, ordered by score.
+// scores are multiplied by weights, and combined by agg
+func (ss *SortedSet) Union(keys []string, weights []float64, agg Aggregate) []interface{} {
+	scores := make(map[string]float64)
+	for i, k := range keys {
+		if !ss.KeyExist(k) {
+			continue
+		}
+		w := weightOf(weights, i)
+		for m, n := range ss.record[k].dict {
+			score := zeroNaN(n.score * w)
+			if old, ok := scores[m]; ok {
+				score = agg.combine(old, score)
+			}
+			scores[m] = score
+		}
+	}
+	return sortScores(scores)
+}
+
+// members and scores in the intersection of keys, like Union
+func (ss *SortedSet) Inter(keys []string, weights []float64, agg Aggregate) []interface{} {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// walk the smallest one
+	smallest := keys[0]
+	for _, k := range keys {
+		if !ss.KeyExist(k) {
+			return nil
+		}
+		if len(ss.record[k].dict) < len(ss.record[smallest].dict) {
+			smallest = k
+		}
+	}
+
+	scores := make(map[string]float64)
+	for m := range ss.record[smallest].dict {
+		var score float64
+		in := true
+		for i, k := range keys {
+			n, ok := ss.record[k].dict[m]
+			if !ok {
+				in = false
+				break
+			}
+			s := zeroNaN(n.score * weightOf(weights, i))
+			if i == 0 {
+				score = s
+			} else {
+				score = agg.combine(score, s)
+			}
+		}
+		if in {
+			scores[m] = score
+		}
+	}
+	return sortScores(scores)
+}
+
+// members and scores of the first key which are not in other keys
+func (ss *SortedSet) Diff(keys ...string) []interface{} {
+	if len(keys) == 0 || !ss.KeyExist(keys[0]) {
+		return nil
+	}
+	scores := make(map[string]float64)
+	for m, n := range ss.record[keys[0]].dict {
+		scores[m] = n.score
+	}
+	for _, k := range keys[1:] {
+		if !ss.KeyExist(k) {
+			continue
+		}
+		for m := range ss.record[k].dict {
+			delete(scores, m)
+		}
+	}
+	return sortScores(scores)
+}
+
+// member and score pairs ordered like a sorted set
+func sortScores(scores map[string]float64) []interface{} {
+	members := make([]string, 0, len(scores))
+	for m := range scores {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := scores[members[i]], scores[members[j]]
+		return si < sj || (si == sj && members[i] < members[j])
+	})
+
+	res := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		res = append(res, m, scores[m])
+	}
+	return res
+}
+
 func correctRange(start, stop int, length int) (int, int, bool) {
 	if start < 0 {
 		start += length
@@ -2,6 +2,7 @@ package ds
 
 import (
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -85,3 +86,57 @@ func TestSortedSet_MemberExist(t *testing.T) {
 	b = ss.MemberExist("stu", "a")
 	assert.False(t, b)
 }
+
+func TestSortedSet_Range(t *testing.T) {
+	ss := NewSortedSet()
+	ss.Add("z", "a", 1)
+	ss.Add("z", "b", 2)
+	ss.Add("z", "c", 3)
+	ss.Add("z", "d", 3)
+
+	ok, r := ss.Rank("z", "c", false)
+	assert.True(t, ok)
+	assert.Equal(t, 2, r)
+	_, r = ss.Rank("z", "c", true)
+	assert.Equal(t, 1, r)
+	ok, _ = ss.Rank("z", "e", false)
+	assert.False(t, ok)
+
+	assert.Equal(t, []interface{}{"b", float64(2), "c", float64(3)}, ss.RangeByRank("z", 1, 2, false))
+	assert.Equal(t, []interface{}{"d", float64(3), "c", float64(3)}, ss.RangeByRank("z", 0, -3, true))
+	assert.Nil(t, ss.RangeByRank("z", 5, 10, false))
+
+	r1 := ScoreRange{Min: 1, Max: 3, MinEx: true}
+	assert.Equal(t, 3, ss.Count("z", r1))
+	assert.Equal(t, []interface{}{"c", float64(3), "d", float64(3)}, ss.RangeByScoreRange("z", r1, false, 1, -1))
+	assert.Equal(t, []interface{}{"c", float64(3)}, ss.RangeByScoreRange("z", r1, true, 1, 1))
+	r2 := ScoreRange{Min: 1, Max: 3, MaxEx: true}
+	assert.Equal(t, []interface{}{"b", float64(2), "a", float64(1)}, ss.RangeByScoreRange("z", r2, true, 0, -1))
+	assert.Equal(t, 0, ss.Count("z", ScoreRange{Min: 2, Max: 2, MinEx: true}))
+
+	ss.Add("lex", "a", 0)
+	ss.Add("lex", "b", 0)
+	ss.Add("lex", "c", 0)
+	assert.Equal(t, []string{"b", "c"}, ss.RangeByLex("lex", LexRange{Min: "a", MinEx: true, NoMax: true}, 0, -1))
+	assert.Equal(t, []string{"a"}, ss.RangeByLex("lex", LexRange{NoMin: true, Max: "b", MaxEx: true}, 0, -1))
+	assert.Equal(t, []string{"b"}, ss.RangeByLex("lex", LexRange{NoMin: true, NoMax: true}, 1, 1))
+}
+
+func TestSortedSet_Union_Inter_Diff(t *testing.T) {
+	ss := NewSortedSet()
+	ss.Add("z1", "a", 1)
+	ss.Add("z1", "b", 2)
+	ss.Add("z2", "b", 3)
+	ss.Add("z2", "c", 4)
+
+	assert.Equal(t, []interface{}{"a", float64(1), "b", float64(8), "c", float64(8)}, ss.Union([]string{"z1", "z2", "none"}, []float64{1, 2, 1}, AggregateSum))
+	assert.Equal(t, []interface{}{"a", float64(1), "b", float64(2), "c", float64(4)}, ss.Union([]string{"z1", "z2"}, nil, AggregateMin))
+	assert.Equal(t, []interface{}{"b", float64(3)}, ss.Inter([]string{"z1", "z2"}, nil, AggregateMax))
+	assert.Equal(t, []interface{}{"b", float64(5)}, ss.Inter([]string{"z2", "z1"}, nil, AggregateSum))
+	assert.Empty(t, ss.Inter([]string{"z1", "none"}, nil, AggregateSum))
+	assert.Equal(t, []interface{}{"a", float64(1)}, ss.Diff("z1", "z2"))
+
+	// inf * 0 is 0
+	ss.Add("inf", "a", math.Inf(1))
+	assert.Equal(t, []interface{}{"a", float64(1)}, ss.Union([]string{"inf", "z1"}, []float64{0, 1}, AggregateSum)[:2])
+}
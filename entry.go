@@ -21,8 +21,11 @@ const (
 const (
 	StrSet uint16 = iota
 	StrRemove
+	StrExpire
 )
 
+// positional list entries from LPush to LRem are only loaded
+// from old files, lists are written in sequence entries now
 const (
 	ListLPush uint16 = iota
 	ListLPop
@@ -32,24 +35,42 @@ const (
 	ListRInsert
 	ListLSet
 	ListLRem
+	ListExpire
+	ListSeqSet // key = key | seq, value = element
+	ListSeqDel // key = key | seq
+	ListClear  // remove the whole list
 )
 
 const (
 	HashHSet uint16 = iota
 	HashHDel
+	HashExpire
+	HashClear
 )
 
 const (
 	SetSAdd uint16 = iota
 	SetSRem
 	SetSMove
+	SetExpire
+	SetClear
 )
 
 const (
 	ZSetZAdd uint16 = iota
 	ZSetZRem
+	ZSetExpire
+	ZSetClear
 )
 
+// crc algorithm of entries in format v2
+const (
+	ChecksumIEEE uint8 = iota
+	ChecksumCastagnoli
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 type Entry struct {
 
 	// header size: 4 + 8 + 2 + 4 + 4 + 4 = 26 bytes
@@ -145,3 +166,14 @@ func DecodeHeader(buf []byte) (*Entry, error) {
 
 	return e, nil
 }
+
+// crc of format v2, covers the header behind crc, key and value
+func entrySum(checksum uint8, header, key, value []byte) uint32 {
+	tab := crc32.IEEETable
+	if checksum == ChecksumCastagnoli {
+		tab = castagnoliTable
+	}
+	c := crc32.Update(0, tab, header[4:EntryHeaderSize])
+	c = crc32.Update(c, tab, key)
+	return crc32.Update(c, tab, value)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/edsrzf/mmap-go"
 	"hash/crc32"
-	"log"
 	"os"
 )
 
@@ -28,15 +27,31 @@ var (
 	}
 )
 
+// files of format v2 begin with a header:
+// magic(4) | version(1) | checksum(1) | reserved(2)
+// files of format v1 have no header, entries begin at 0
+const FileHeaderSize = 8
+
+const (
+	FormatV1 uint8 = 1 // crc covers value only
+	FormatV2 uint8 = 2 // crc covers header, key and value
+)
+
+var FileMagic = []byte("CASK")
+
 type File struct {
-	id     uint32
-	fd     *os.File
-	mmap   mmap.MMap
-	offset int64
+	id       uint32
+	fd       *os.File
+	mmap     mmap.MMap
+	offset   int64
+	version  uint8
+	checksum uint8 // crc algorithm of format v2
 }
 
-// create a new db file, use mmap to write and read
-func NewFile(path string, fileId uint32, dataType uint16, fileSize int64) (*File, error) {
+// create a new db file, use mmap to write and read.
+// a new file is created in format v2 with the given checksum,
+// an existing file keeps its own format
+func NewFile(path string, fileId uint32, dataType uint16, fileSize int64, checksum uint8) (*File, error) {
 	filepath := path + string(os.PathSeparator) + fmt.Sprintf(FileNameFormat[dataType], fileId)
 
 	fd, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR, 0644)
@@ -68,9 +83,91 @@ func NewFile(path string, fileId uint32, dataType uint16, fileSize int64) (*File
 	}
 	f.mmap = m
 
+	// a file without any entry can be written in the new format
+	if err := f.readHeader(); err != nil {
+		return nil, err
+	}
+	if f.version == FormatV1 && bytes.Equal(f.mmap[:EntryHeaderSize], make([]byte, EntryHeaderSize)) {
+		f.writeHeader(checksum)
+		f.offset = FileHeaderSize
+	}
+
 	return f, nil
 }
 
+// open a db file in read-only mode, nothing is changed on disk.
+// a missing file is regarded as empty
+func NewReadOnlyFile(path string, fileId uint32, dataType uint16) (*File, error) {
+	filepath := path + string(os.PathSeparator) + fmt.Sprintf(FileNameFormat[dataType], fileId)
+
+	f := &File{
+		id:      fileId,
+		version: FormatV1,
+	}
+
+	fd, err := os.Open(filepath)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.fd = fd
+
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return f, nil
+	}
+	f.offset = fi.Size()
+
+	// writing to the mapping will crash
+	m, err := mmap.Map(fd, mmap.RDONLY, 0)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	f.mmap = m
+
+	if err := f.readHeader(); err != nil {
+		f.Close(false)
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) readHeader() error {
+	if len(f.mmap) < FileHeaderSize || !bytes.Equal(f.mmap[:len(FileMagic)], FileMagic) {
+		f.version = FormatV1
+		return nil
+	}
+	f.version = f.mmap[4]
+	f.checksum = f.mmap[5]
+	if f.version != FormatV2 || f.checksum > ChecksumCastagnoli {
+		return ErrorFileFormat
+	}
+	return nil
+}
+
+func (f *File) writeHeader(checksum uint8) {
+	copy(f.mmap, FileMagic)
+	f.mmap[4] = FormatV2
+	f.mmap[5] = checksum
+	f.version = FormatV2
+	f.checksum = checksum
+}
+
+// where the first entry begins
+func (f *File) start() int64 {
+	if f.version == FormatV1 {
+		return 0
+	}
+	return FileHeaderSize
+}
+
 // stop the mapping, and 'sync' means whether flush the data to disk
 func (f *File) Close(sync bool) error {
 	if f != nil && f.mmap != nil {
@@ -83,6 +180,9 @@ func (f *File) Close(sync bool) error {
 			return err
 		}
 	}
+	if f.fd == nil {
+		return nil
+	}
 	if err := f.fd.Close(); err != nil {
 		return err
 	}
@@ -132,8 +232,12 @@ func (f *File) Read(offset int64) (*Entry, error) {
 		}
 	}
 
-	// make sure that value is unmistakable
-	if e.crc != crc32.ChecksumIEEE(e.value) {
+	// make sure that entry is unmistakable
+	if f.version == FormatV1 {
+		if e.crc != crc32.ChecksumIEEE(e.value) {
+			return nil, ErrorCrcCheck
+		}
+	} else if e.crc != entrySum(f.checksum, buf, e.key, e.value) {
 		return nil, ErrorCrcCheck
 	}
 
@@ -161,6 +265,14 @@ func (f *File) ReadBuf(offset, n int64) ([]byte, error) {
 	return buf, nil
 }
 
+// cut off the content behind offset
+func (f *File) truncate(offset int64) {
+	for i := offset; i < f.offset; i++ {
+		f.mmap[i] = 0
+	}
+	f.offset = offset
+}
+
 // write entry to file
 func (f *File) Write(e *Entry) error {
 
@@ -171,10 +283,15 @@ func (f *File) Write(e *Entry) error {
 	}
 
 	if f.offset+int64(len(b)) > int64(len(f.mmap)) {
-		log.Println("[Write entry error]")
 		return ErrorWriteOverFlow
 	}
 
+	// crc of format v2 depends on the encoded header
+	if f.version != FormatV1 {
+		e.crc = entrySum(f.checksum, b, e.key, e.value)
+		binary.BigEndian.PutUint32(b[0:4], e.crc)
+	}
+
 	// mmap write to disk
 	copy(f.mmap[f.offset:], b)
 	f.offset += int64(e.Size())
@@ -0,0 +1,85 @@
+package CaskDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFile_CrcCoversKey(t *testing.T) {
+	// key byte, key size, state
+	for _, pos := range []int{EntryHeaderSize, 17, 13} {
+		os.RemoveAll("/tmp/CaskDB")
+		db, err := Open(DefaultConfig())
+		assert.Nil(t, err)
+		assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+		assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+
+		f := db.activeFiles[Str]
+		assert.Equal(t, FormatV2, f.version)
+		assert.Equal(t, FileMagic, []byte(f.mmap[:4]))
+
+		f.mmap[FileHeaderSize+pos] ^= 0x01
+		assert.Nil(t, db.Close())
+
+		_, err = Open(DefaultConfig())
+		assert.NotNil(t, err)
+	}
+}
+
+func TestFile_CRC32C(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.CRC32C = true
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+	assert.Equal(t, ChecksumCastagnoli, db.activeFiles[Str].checksum)
+	assert.Nil(t, db.Close())
+
+	// existing files keep their checksum
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, ChecksumCastagnoli, db.activeFiles[Str].checksum)
+	v, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), v)
+	assert.Nil(t, db.Close())
+}
+
+func TestFile_FormatV1(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 1024
+	assert.Nil(t, os.MkdirAll(cfg.DBDir, os.ModePerm))
+
+	// a file written before format v2
+	buf := make([]byte, cfg.MaxFileSize)
+	var off int
+	for _, k := range []string{"k0", "k1"} {
+		b, err := NewEntry([]byte(k), []byte("v"), Str, StrSet, 0).Encode()
+		assert.Nil(t, err)
+		off += copy(buf[off:], b)
+	}
+	assert.Nil(t, ioutil.WriteFile(cfg.DBDir+"/0.data.str", buf, 0644))
+
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatV1, db.activeFiles[Str].version)
+	assert.Equal(t, FormatV2, db.activeFiles[Hash].version)
+	v, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), v)
+
+	// v1 file is appended in v1
+	assert.Nil(t, db.Set([]byte("k2"), []byte("v")))
+	assert.Equal(t, int64(off+29), db.activeFiles[Str].offset)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.True(t, db.StrKeyExist([]byte("k0")))
+	assert.True(t, db.StrKeyExist([]byte("k2")))
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,302 @@
+package CaskDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/edsrzf/mmap-go"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const FsckBackupDirName = "fsck-backup"
+
+// decoded header of an entry
+type EntryHeader struct {
+	Offset    int64
+	Crc       uint32
+	Timestamp uint64
+	DataType  uint16
+	MarkType  uint16
+	KeySize   uint32
+	ValueSize uint32
+	KeyOffset uint32
+	Key       []byte
+	Live      bool
+}
+
+func (h *EntryHeader) Size() int64 {
+	return int64(EntryHeaderSize + h.KeySize + h.ValueSize)
+}
+
+// a range of bytes which can not be read as entries
+type Corruption struct {
+	Offset int64 // where the bad entry starts
+	Size   int64 // bytes skipped until the next good entry or the end of data
+	Err    error
+}
+
+// result of checking a data file
+type FileCheck struct {
+	Path      string
+	FileId    uint32
+	DataType  uint16
+	Version   uint8 // format version of file
+	Start     int64 // where the first entry begins
+	Size      int64 // size of file
+	End       int64 // end of data, including corrupted bytes
+	Entries   []*EntryHeader
+	Live      int  // number of live entries
+	LiveKnown bool // list entries are merged by snapshot, we can not tell which one is live
+	Corrupts  []*Corruption
+}
+
+func (fc *FileCheck) Corrupted() bool {
+	return len(fc.Corrupts) > 0
+}
+
+// the offset where the first corrupted entry starts
+func (fc *FileCheck) GoodEnd() int64 {
+	if len(fc.Corrupts) > 0 {
+		return fc.Corrupts[0].Offset
+	}
+	return fc.End
+}
+
+// walk all data files of config.DBDir without changing them,
+// corrupted entries are skipped and the rest are replayed in memory
+// to find out which entries are live
+func CheckFiles(config Config) ([]*FileCheck, error) {
+	fids, err := loadFilesId(config.DBDir)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		config:    config,
+		strIndex:  NewStrIndex(),
+		listIndex: NewListIndex(),
+		hashIndex: NewHashIndex(),
+		setIndex:  NewSetIndex(),
+		zsetIndex: NewZSetIndex(),
+		lives:     newLiveSets(),
+		watch:     newWatchList(),
+		snaps:     newSnapshots(),
+	}
+
+	var checks []*FileCheck
+	for i := uint16(0); i < DataTypeNum; i++ {
+		ids := fids[int(i)]
+		sort.Ints(ids)
+
+		var typeChecks []*FileCheck
+		var files []*File
+		r := newReplayer(db)
+		for _, id := range ids {
+			path := config.DBDir + PathSeparator + fmt.Sprintf(FileNameFormat[i], id)
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			f := &File{id: uint32(id), mmap: mmap.MMap(b)}
+			if err = f.readHeader(); err != nil {
+				return nil, err
+			}
+			fc := checkFile(f, i, config)
+			fc.Path = path
+			typeChecks = append(typeChecks, fc)
+			files = append(files, f)
+
+			// replay good entries to build indexes
+			r.reset()
+			for _, h := range fc.Entries {
+				e, _ := f.Read(h.Offset)
+				idx := &Index{fileId: f.id, offset: h.Offset, size: uint32(h.Size())}
+				r.replay(e, idx, h.Offset)
+			}
+		}
+
+		// keys expired up to now are dead
+		db.removeExpired(i)
+
+		// lists of old files are not tracked until they are rewritten
+		legacy := i == List && len(db.listIndex.legacy.GetAllKeys()) > 0
+		for j, fc := range typeChecks {
+			fc.LiveKnown = !legacy
+			if !fc.LiveKnown {
+				continue
+			}
+			for _, h := range fc.Entries {
+				e, _ := files[j].Read(h.Offset)
+				if db.entryValid(e, fc.FileId, h.Offset) {
+					h.Live = true
+					fc.Live++
+				}
+			}
+		}
+		checks = append(checks, typeChecks...)
+	}
+
+	return checks, nil
+}
+
+// read entries one by one, when an entry is bad,
+// look for the next good entry byte by byte
+func checkFile(f *File, dataType uint16, config Config) *FileCheck {
+	fc := &FileCheck{
+		FileId:   f.id,
+		DataType: dataType,
+		Version:  f.version,
+		Start:    f.start(),
+		Size:     int64(len(f.mmap)),
+	}
+
+	end := dataEnd(f)
+	offset := f.start()
+	for offset < end {
+		e, err := readGood(f, offset, dataType, config)
+		if err != nil {
+			bad := &Corruption{Offset: offset, Err: err}
+			fc.Corrupts = append(fc.Corrupts, bad)
+			if offset = nextEntry(f, offset+1, end, dataType, config); offset < 0 {
+				bad.Size = end - bad.Offset
+				break
+			}
+			bad.Size = offset - bad.Offset
+			continue
+		}
+
+		fc.Entries = append(fc.Entries, &EntryHeader{
+			Offset:    offset,
+			Crc:       e.crc,
+			Timestamp: e.timestamp,
+			DataType:  e.GetDataType(),
+			MarkType:  e.GetMarkType(),
+			KeySize:   e.keySize,
+			ValueSize: e.valueSize,
+			KeyOffset: e.keyOffset,
+			Key:       e.key,
+		})
+		offset += int64(e.Size())
+	}
+
+	// the last entry may end with some zero bytes of value
+	fc.End = end
+	if offset > end {
+		fc.End = offset
+	}
+	return fc
+}
+
+// everything after the end of data is zero
+func dataEnd(f *File) int64 {
+	const chunk = 4096
+	zero := make([]byte, chunk)
+
+	// skip zero chunks quickly, then find the last non-zero byte
+	end := int64(len(f.mmap))
+	for end >= chunk && bytes.Equal(f.mmap[end-chunk:end], zero) {
+		end -= chunk
+	}
+	for end > 0 && f.mmap[end-1] == 0 {
+		end--
+	}
+	return end
+}
+
+func readGood(f *File, offset int64, dataType uint16, config Config) (*Entry, error) {
+	e, err := f.Read(offset)
+	if err != nil {
+		return nil, err
+	}
+	if !plausible(e, dataType, config) {
+		return nil, ErrorBadEntry
+	}
+	return e, nil
+}
+
+// the offset of next good entry in [from, end), -1 if there is none
+func nextEntry(f *File, from, end int64, dataType uint16, config Config) int64 {
+	for offset := from; offset < end; offset++ {
+		if _, err := readGood(f, offset, dataType, config); err == nil {
+			return offset
+		}
+	}
+	return -1
+}
+
+// a header with good crc may still be garbage, when we are looking
+// for the next entry in a corrupted area
+func plausible(e *Entry, dataType uint16, config Config) bool {
+	if e.GetDataType() != dataType || e.timestamp == 0 {
+		return false
+	}
+	if e.keySize == 0 || e.keySize > config.MaxKeySize || e.valueSize > config.MaxValueSize {
+		return false
+	}
+	if e.keyOffset > e.keySize {
+		return false
+	}
+	mt := e.GetMarkType()
+	return mt <= lastMarks[dataType] || mt == BatchBegin || mt == BatchCommit
+}
+
+// RepairFile fixes a corrupted file. by default, the file is truncated at the
+// first corrupted entry, and the entries behind it are lost. with salvage, all
+// good entries are copied to a new file which takes the place of the old one.
+// the old file is moved to backup directory either way.
+func RepairFile(config Config, fc *FileCheck, salvage bool) error {
+	if !fc.Corrupted() {
+		return nil
+	}
+
+	backupDir := config.DBDir + PathSeparator + FsckBackupDirName
+	if err := os.MkdirAll(backupDir, os.ModePerm); err != nil {
+		return err
+	}
+	name := fmt.Sprintf(FileNameFormat[fc.DataType], fc.FileId)
+	backup := fmt.Sprintf("%s%s%s.%d", backupDir, PathSeparator, name, time.Now().Unix())
+
+	b, err := ioutil.ReadFile(fc.Path)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(backup, b, 0644); err != nil {
+		return err
+	}
+
+	var data []byte
+	if salvage {
+		data = append(data, b[:fc.Start]...)
+		for _, h := range fc.Entries {
+			data = append(data, b[h.Offset:h.Offset+h.Size()]...)
+		}
+	} else {
+		data = b[:fc.GoodEnd()]
+	}
+
+	// write a complete new file, then replace the old one
+	tmp := backupDir + PathSeparator + name + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err = fd.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, fc.Path); err != nil {
+		return err
+	}
+
+	// hint points to the old offsets
+	return removeHintFile(config.DBDir, fc.FileId, fc.DataType)
+}
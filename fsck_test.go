@@ -0,0 +1,90 @@
+package CaskDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// write k0..k4 and corrupt the value of k2
+func prepareCorrupted(t *testing.T) string {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	for _, k := range []string{"k0", "k1", "k2", "k3", "k4"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("v")))
+	}
+	assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+	assert.Nil(t, db.Close())
+
+	// every entry is 26 + 2 + 1 = 29 bytes
+	path := "/tmp/CaskDB/0.data.str"
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	b[FileHeaderSize+29*2+28] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(path, b, 0644))
+	return path
+}
+
+func TestCheckFiles(t *testing.T) {
+	prepareCorrupted(t)
+
+	checks, err := CheckFiles(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, DataTypeNum, len(checks))
+
+	fc := checks[0]
+	assert.Equal(t, uint16(Str), fc.DataType)
+	assert.Equal(t, 5, len(fc.Entries))
+	assert.Equal(t, 4, fc.Live)
+	assert.True(t, fc.LiveKnown)
+	assert.False(t, fc.Entries[0].Live) // k0 is overwritten
+	assert.Equal(t, []byte("k3"), fc.Entries[2].Key)
+	assert.Equal(t, int64(FileHeaderSize+29*5+30), fc.End)
+
+	assert.Equal(t, 1, len(fc.Corrupts))
+	assert.Equal(t, int64(FileHeaderSize+29*2), fc.Corrupts[0].Offset)
+	assert.Equal(t, int64(29), fc.Corrupts[0].Size)
+	assert.Equal(t, ErrorCrcCheck, fc.Corrupts[0].Err)
+
+	for _, fc := range checks[1:] {
+		assert.False(t, fc.Corrupted())
+	}
+}
+
+func TestRepairFile(t *testing.T) {
+	for _, salvage := range []bool{false, true} {
+		prepareCorrupted(t)
+		_, err := Open(DefaultConfig())
+		assert.Equal(t, ErrorCrcCheck, err)
+
+		checks, err := CheckFiles(DefaultConfig())
+		assert.Nil(t, err)
+		err = RepairFile(DefaultConfig(), checks[0], salvage)
+		assert.Nil(t, err)
+
+		checks, err = CheckFiles(DefaultConfig())
+		assert.Nil(t, err)
+		assert.False(t, checks[0].Corrupted())
+
+		db, err := Open(DefaultConfig())
+		assert.Nil(t, err)
+		assert.True(t, db.StrKeyExist([]byte("k1")))
+		assert.False(t, db.StrKeyExist([]byte("k2")))
+		assert.Equal(t, salvage, db.StrKeyExist([]byte("k4")))
+		v, _ := db.Get([]byte("k0"))
+		if salvage {
+			assert.Equal(t, []byte("v0"), v)
+		} else {
+			assert.Equal(t, []byte("v"), v)
+		}
+
+		// the corrupted file is kept in backup dir
+		infos, err := ioutil.ReadDir("/tmp/CaskDB/" + FsckBackupDirName)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(infos))
+
+		assert.Nil(t, db.Close())
+	}
+}
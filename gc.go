@@ -1,16 +1,17 @@
 package CaskDB
 
 import (
-	"bytes"
+	"context"
 	"github.com/k-si/CaskDB/util"
 	"io/ioutil"
-	"log"
 	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// gc is started by MergeInterval, or when garbage is found on checking
 func (db *DB) listeningGC() {
 	timer := time.NewTimer(db.config.MergeInterval)
 	defer func() {
@@ -19,6 +20,13 @@ func (db *DB) listeningGC() {
 		}
 	}()
 
+	var check <-chan time.Time
+	if db.config.MergeCheckInterval > 0 {
+		ticker := time.NewTicker(db.config.MergeCheckInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
 Over:
 	for {
 		select {
@@ -26,70 +34,61 @@ Over:
 			break Over
 		case <-timer.C:
 			timer.Reset(db.config.MergeInterval)
-			if err := db.GC(); err != nil {
-				log.Println("[GC err]", err)
-
-				// file rollback
-				if err := db.FilesRollback(); err != nil {
-					log.Fatal("[rollback fail]")
-				}
+		case <-check:
+			if !db.needGC() {
+				continue
+			}
+		}
+		if atomic.LoadUint32(&db.isClosed) == 1 {
+			break Over
+		}
 
-				// fd rollback
-				for _, f := range db.activeFiles {
-					if err := f.Close(true); err != nil {
-						log.Fatal(err)
-					}
-				}
-				for _, item := range db.archedFiles {
-					for _, f := range item {
-						if err := f.Close(true); err != nil {
-							log.Fatal(err)
-						}
-					}
-				}
-				db.activeFiles, db.archedFiles, _, err = db.loadFiles()
-				if err != nil {
-					log.Fatal(err)
-				}
-				log.Println("[rollback finish]")
+		// a failed merge leaves the files in use as they were,
+		// unless it fails after commit, then db is degraded
+		if err := db.GC(); err != nil && err != ErrorMergingMerge {
+			db.logger().Println("[GC err]", err)
+			if db.config.OnGCError != nil {
+				db.config.OnGCError(err)
 			}
-			log.Println(">>> restart the world <<<")
 		}
 	}
 }
 
-// garbage file recycling
-// merge all files and remove useless data
+// garbage file recycling, merge arched files which are worth merging
+// and remove useless data. files are merged in background, reads and writes
+// go on meanwhile, the lock of a data type is only held to check an entry
+// and to swap files
 func (db *DB) GC() error {
+	return db.GCWithContext(context.Background())
+}
+
+// GC that stops when ctx is done, it is checked before every entry.
+// merged files of the runs not committed are discarded, so the db stays
+// as it was, and ctx.Err() is returned. progress is shown by GCStatus
+func (db *DB) GCWithContext(ctx context.Context) (err error) {
 
 	// check status
 	if atomic.LoadUint32(&db.isClosed) == 1 {
 		return ErrorClosedDB
 	}
-	if atomic.LoadUint32(&db.isMerging) == 1 {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	if !atomic.CompareAndSwapUint32(&db.isMerging, 0, 1) {
 		return ErrorMergingMerge
 	}
-
-	log.Println(">>> stop the world <<<")
-	db.strIndex.mu.Lock()
-	db.hashIndex.mu.Lock()
-	db.listIndex.mu.Lock()
-	db.setIndex.mu.Lock()
-	db.zsetIndex.mu.Lock()
-	defer db.strIndex.mu.Unlock()
-	defer db.hashIndex.mu.Unlock()
-	defer db.listIndex.mu.Unlock()
-	defer db.setIndex.mu.Unlock()
-	defer db.zsetIndex.mu.Unlock()
-
-	// change status
-	atomic.StoreUint32(&db.isMerging, 1)
 	defer atomic.StoreUint32(&db.isMerging, 0)
 
-	// backup
-	if err := db.FilesBackup(); err != nil {
-		return err
+	// Close waits for merging to stop
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	if atomic.LoadUint32(&db.isClosed) == 1 {
+		return ErrorClosedDB
 	}
+	atomic.StoreUint32(&db.mergeStop, 0)
+	defer func() {
+		db.setGCErr(err)
+	}()
 
 	// check merged path
 	mergePath := db.config.DBDir + PathSeparator + MergeDirName
@@ -97,98 +96,58 @@ func (db *DB) GC() error {
 		return err
 	}
 
-	// all files that fail to merge will be deleted
-	if err := db.removeMergedFiles(); err != nil {
+	// a committed merge must be finished before any other merge
+	mans, err := readManifests(mergePath)
+	if err != nil {
 		return err
 	}
+	if len(mans) > 0 {
+		return ErrorMergeBroken
+	}
 
-	// load all files id
-	fids, err := loadFilesId(db.config.DBDir)
-	if err != nil {
+	// all files that fail to merge will be deleted
+	if err := db.removeMergedFiles(); err != nil {
 		return err
 	}
 
-	var mergeErr error
+	stats := db.FileStats()
+	totalDead := mergeableDead(stats)
 
+	// plan all runs first, so the total is known
+	runs := make([][][]*File, DataTypeNum)
+	oldest := make([]bool, DataTypeNum)
+	total := 0
+	for i := 0; i < DataTypeNum; i++ {
+		runs[i], oldest[i] = db.mergeRuns(stats, uint16(i), totalDead)
+		for _, run := range runs[i] {
+			total += len(run)
+		}
+	}
+	db.gcStat.start(total)
+	defer db.gcStat.stop()
+
+	// every goroutine do its merge task
+	errs := make([]error, DataTypeNum)
 	wg := sync.WaitGroup{}
 	for i := 0; i < DataTypeNum; i++ {
 		wg.Add(1)
-
-		// every goroutine do its merge task
 		go func(i int) {
 			defer wg.Done()
 
-			// List snapshot GC
-			if i == 1 {
-				log.Println("[list snapshot...]")
-				if mergeErr = db.listSnapshot(mergePath); mergeErr != nil {
+			for j, run := range runs[i] {
+				if errs[i] = db.mergeRun(ctx, uint16(i), mergePath, run, j == 0 && oldest[i]); errs[i] != nil {
 					return
 				}
-				return
 			}
-
-			ids := fids[i] // all files with this type
-
-			mergedArchedFiles := make(map[uint32]*File)
-			var mergedActiveFile *File
-
-			for j := 0; j < len(ids); j++ {
-				select {
-				case <-db.mergeChan:
-					log.Println("[exit merge task]", i)
-					return
-				default:
-					f, err := db.getFileById(uint16(i), uint32(ids[j]))
-					if err != nil {
-						return
-					}
-
-					// read all entry from files, but except empty file
-					var offset int64
-					if 0 == f.offset {
-						continue
-					}
-					for offset < f.offset {
-						e, err := f.Read(offset)
-						if err != nil {
-							mergeErr = err
-							return
-						}
-
-						// check entry valid
-						if ok := db.entryValid(e, uint32(ids[j]), offset); ok {
-
-							// store entry
-							// here use &, make mergedActiveFile modifiable
-							if mergeErr = db.storeMerged(e, mergedArchedFiles, &mergedActiveFile); mergeErr != nil {
-								return
-							}
-						}
-						offset += int64(e.Size())
-					}
-
-					// close and remove old file
-					if mergeErr = f.Close(true); mergeErr != nil {
-						return
-					}
-					if mergeErr = os.Remove(f.fd.Name()); mergeErr != nil {
-						return
-					}
-				}
-			}
-
-			if mergeErr = db.buildFromMerged(mergedActiveFile, mergedArchedFiles, i, mergePath); mergeErr != nil {
-				return
-			}
-
 		}(i)
 	}
 	wg.Wait()
 
-	if mergeErr != nil {
-		return mergeErr
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
@@ -200,218 +159,404 @@ func (db *DB) StopGC() error {
 	}
 
 	if atomic.LoadUint32(&db.isMerging) == 0 {
-		db.listenChan <- struct{}{}
+		db.stopListen()
 		return nil
 	}
 
-	// channel notify
-	if atomic.LoadUint32(&db.isMerging) == 1 {
-		go func() {
-			for i := 0; i < DataTypeNum; i++ {
-				db.mergeChan <- struct{}{}
-			}
-		}()
-	}
+	db.stopMerge()
 	return nil
 }
 
-func (db *DB) buildFromMerged(mergedActiveFile *File, mergedArchedFiles map[uint32]*File, i int, mergePath string) error {
-	if mergedActiveFile != nil {
+// stop the running merge tasks, merged files are discarded
+func (db *DB) stopMerge() {
+	atomic.StoreUint32(&db.mergeStop, 1)
+}
 
-		// save file info
-		fi, err := mergedActiveFile.fd.Stat()
-		if err != nil {
-			return err
-		}
-		name := PathSeparator + fi.Name()
-		tmpId := mergedActiveFile.id
-		tmpOff := mergedActiveFile.offset
+func (db *DB) mergeStopped() bool {
+	return atomic.LoadUint32(&db.mergeStop) == 1
+}
 
-		// close merged file
-		if err = mergedActiveFile.Close(true); err != nil {
-			return err
-		}
+// stop timed gc, it is safe to call more than once
+func (db *DB) stopListen() {
+	db.listenOnce.Do(func() {
+		close(db.listenChan)
+	})
+}
 
-		// rename new merged file
-		oldPath := mergePath + name
-		newPath := db.config.DBDir + name
-		if err = os.Rename(oldPath, newPath); err != nil {
-			return err
-		}
+// arched files of a data type sorted by id
+func (db *DB) archedFilesOf(dataType uint16) []*File {
+	mu := db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
 
-		// reopen file
-		activeFile, err := NewFile(db.config.DBDir, tmpId, uint16(i), db.config.MaxFileSize)
-		if err != nil {
-			return err
-		}
-		activeFile.offset = tmpOff
+	var files []*File
+	for _, f := range db.archedFiles[dataType] {
+		files = append(files, f)
+	}
+	sortFiles(files)
+	return files
+}
 
-		// do same
-		var archedFiles = make(map[uint32]*File)
-		for _, f := range mergedArchedFiles {
+func sortFiles(files []*File) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].id < files[j].id
+	})
+}
 
-			// save info
-			fi, err = f.fd.Stat()
-			if err != nil {
+// copy valid entries of adjacent arched files to merged files. the active
+// file is not touched, so writing goes on while merging. remove entries can
+// be thrown away only if there is no older file, otherwise the data they
+// removed comes back on loading
+func (db *DB) mergeRun(ctx context.Context, dataType uint16, mergePath string, files []*File, oldest bool) error {
+	mu := db.lockerOf(dataType)
+	m := newMerger(db, dataType, mergePath, files)
+	st := db.gcStat
+
+	// entries kept for snapshots come back on loading without remove entries
+	oldest = oldest && !db.snaps.pinning(dataType)
+
+	for _, f := range files {
+		offset := f.start()
+		for offset < f.offset {
+
+			// nothing is changed before commit, stop at any entry
+			if db.mergeStopped() {
+				db.logger().Println("[exit merge task]", FileNameSuffix[dataType])
+				return m.discard()
+			}
+			if err := ctx.Err(); err != nil {
+				db.logger().Println("[exit merge task]", FileNameSuffix[dataType], err)
+				m.discard()
 				return err
 			}
-			name = PathSeparator + fi.Name()
-			tmpId = f.id
-			tmpOff = f.offset
 
-			// close merged file
-			if err = f.Close(true); err != nil {
+			e, err := f.Read(offset)
+			if err != nil {
+				m.discard()
 				return err
 			}
 
-			// rename file
-			oldPath = mergePath + name
-			newPath = db.config.DBDir + name
-			if err = os.Rename(oldPath, newPath); err != nil {
-				return err
+			// entries of a batch which is not committed never take effect
+			if e.GetMarkType() == BatchBegin {
+				end, broken, err := brokenBatch(f, e, offset)
+				if err != nil {
+					m.discard()
+					return err
+				}
+				if broken {
+					atomic.AddInt64(&st.bytesRead, end-offset)
+					offset = end
+					continue
+				}
 			}
 
-			// reopen file
-			ac, err := NewFile(db.config.DBDir, tmpId, uint16(i), db.config.MaxFileSize)
-			if err != nil {
-				return err
+			// memory may be changed by writing between entries
+			mu.RLock()
+			ok := db.entryValid(e, f.id, offset)
+			mu.RUnlock()
+
+			from := &Index{fileId: f.id, offset: offset, size: e.Size()}
+			if !ok {
+				from = nil
+				ok = !oldest && removing(e)
 			}
-			ac.offset = tmpOff
-			archedFiles[ac.id] = ac
+			if ok {
+				if err := m.write(e, from); err != nil {
+					m.discard()
+					return err
+				}
+				atomic.AddInt64(&st.bytesWritten, int64(e.Size()))
+				atomic.AddInt64(&st.entriesKept, 1)
+			} else {
+				atomic.AddInt64(&st.entriesDropped, 1)
+			}
+			atomic.AddInt64(&st.bytesRead, int64(e.Size()))
+			offset += int64(e.Size())
 		}
-
-		// update fd
-		db.activeFiles[i] = activeFile
-		db.archedFiles[i] = archedFiles
+		atomic.AddInt64(&st.filesDone, 1)
 	}
 
-	return nil
+	return m.commit()
 }
 
-// clear the files in merged directory
-func (db *DB) removeMergedFiles() error {
-	mergedPath := db.config.DBDir + PathSeparator + MergeDirName
-	mInfos, err := ioutil.ReadDir(mergedPath)
-	if err != nil {
-		return err
-	}
-	for _, mi := range mInfos {
-		fp := mergedPath + PathSeparator + mi.Name()
-		if err := os.Remove(fp); err != nil {
-			return err
+// whether the batch beginning at offset is not committed in f, it is thrown
+// away on loading like replayer does. end is where loading goes on after it
+func brokenBatch(f *File, begin *Entry, offset int64) (int64, bool, error) {
+	want := util.BytesToInt(begin.value)
+	offset += int64(begin.Size())
+	for n := 0; offset < f.offset; n++ {
+		e, err := f.Read(offset)
+		if err != nil {
+			return 0, false, err
+		}
+		mt := e.GetMarkType()
+		if mt != BatchBegin && mt != BatchCommit && n < want {
+			offset += int64(e.Size())
+			continue
+		}
+		if mt == BatchCommit && n == want && string(e.key) == string(begin.key) {
+			return 0, false, nil
 		}
+		return offset, true, nil
 	}
-	return nil
+	return offset, true, nil
 }
 
-// while merging, need 'set' or 'update' type of entry, 'remove' type is useless
-func (db *DB) entryValid(e *Entry, eFid uint32, eOffset int64) bool {
-	if e == nil {
-		return false
-	}
-
+// entries that remove data or set a timeout
+func removing(e *Entry) bool {
 	dt := e.GetDataType()
 	mt := e.GetMarkType()
+	if mt == expireMarks[dt] || mt == clearMarks[dt] {
+		return true
+	}
 	switch dt {
-	case Str:
-		if mt == StrSet {
-
-			// entry is valid, if key, file id, offset all equals index
-			v := db.strIndex.idx.Get(e.key)
-			if v == nil {
-				return false
-			}
-			idx := v.(*Index)
-			if eFid == idx.fileId && eOffset == idx.offset {
-				return true
-			}
-			return false
-		}
 	case List:
-		// unable to determine whether List entry is valid,
-		// because if we push 'a' and pop 'a' and push 'a',
-		// when GC, we can not ensure the correctness of 'a',
-		// the solution is to use snapshots.
+		return mt == ListSeqDel
 	case Hash:
-		if mt == HashHSet {
-			v := db.hashIndex.idx.Get(e.GetPreKey(), e.GetPostKey())
-			if v != nil {
-				return bytes.Compare(e.value, v) == 0
-			}
-		}
+		return mt == HashHDel
 	case Set:
-		if mt == SetSAdd {
-			if db.setIndex.idx.ValExist(string(e.key), string(e.value)) {
-				return true
-			}
-		} else if mt == SetSMove {
-			if db.setIndex.idx.ValExist(e.GetPostKey(), string(e.value)) {
-				return true
-			}
-		}
+		return mt == SetSRem || mt == SetSMove
 	case ZSet:
-		if mt == ZSetZAdd {
-			score1 := util.BytesToFloat64(e.GetPostBytesKey())
-			ok, score2 := db.zsetIndex.idx.GetScore(e.GetPreKey(), string(e.value))
-			if ok && score1 == score2 {
-				return true
-			}
-		}
+		return mt == ZSetZRem
 	}
-
 	return false
 }
 
-// store entry in merged files
-// we should use *activeFile
-func (db *DB) storeMerged(e *Entry, archedFiles map[uint32]*File, activeFile **File) error {
-	mergePath := db.config.DBDir + PathSeparator + MergeDirName
+// a live entry copied to merged file, it is moved at last,
+// unless it is changed by writing during merging
+type move struct {
+	e        *Entry
+	from, to *Index
+}
+
+// merged files of a data type. they take the ids of old files in order,
+// so they are still loaded between the same files as before
+type merger struct {
+	db       *DB
+	dataType uint16
+	dir      string
+	old      []*File
+	files    []*File
+	moves    []*move
+	kept     map[uint32]int64 // bytes of remove entries kept in merged files
+	grow     bool             // merged files may take ids after the old files
+}
+
+func newMerger(db *DB, dataType uint16, dir string, old []*File) *merger {
+	return &merger{
+		db:       db,
+		dataType: dataType,
+		dir:      dir,
+		old:      old,
+		kept:     make(map[uint32]int64),
+	}
+}
+
+// write entry in merged files, from is where the live entry comes from,
+// nil means the entry is not tracked
+func (m *merger) write(e *Entry, from *Index) error {
+	db := m.db
+	var f *File
+	if len(m.files) > 0 {
+		f = m.files[len(m.files)-1]
+	}
 
-	// init
-	if (*activeFile) == nil {
-		f, err := NewFile(mergePath, 0, e.GetDataType(), db.config.MaxFileSize)
+	// check active file size
+	if f == nil || f.offset+int64(e.Size()) > db.config.MaxFileSize {
+		if f != nil {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+		var id uint32
+		if len(m.files) < len(m.old) {
+			id = m.old[len(m.files)].id
+		} else if m.grow {
+			id = m.files[len(m.files)-1].id + 1
+		} else {
+			return ErrorMergeOverflow
+		}
+		newf, err := NewFile(m.dir, id, m.dataType, db.config.MaxFileSize, db.checksum())
 		if err != nil {
 			return err
 		}
-		*activeFile = f
+		m.files = append(m.files, newf)
+		f = newf
 	}
 
-	// check active file size
-	if (*activeFile).offset+int64(e.Size()) > db.config.MaxFileSize {
+	if err := f.Write(e); err != nil {
+		return err
+	}
+
+	if from == nil {
+		m.kept[f.id] += int64(e.Size())
+		return nil
+	}
+	m.moves = append(m.moves, &move{
+		e:    e,
+		from: from,
+		to:   &Index{fileId: f.id, offset: f.offset - int64(e.Size()), size: e.Size()},
+	})
+	return nil
+}
 
-		// flush current active file to disk
-		if err := (*activeFile).Sync(); err != nil {
+// remove merged files
+func (m *merger) discard() error {
+	for _, f := range m.files {
+		if err := f.Close(false); err != nil {
+			return err
+		}
+		if err := os.Remove(f.fd.Name()); err != nil {
 			return err
 		}
+	}
+	m.files = nil
+	return nil
+}
 
-		// create new file as active file
-		newId := (*activeFile).id + 1
-		newf, err := NewFile(mergePath, newId, e.GetDataType(), db.config.MaxFileSize)
-		if err != nil {
+// replace old files with merged files. the manifest is the commit point,
+// a crash before it discards the merge, a crash after it is recovered on Open
+func (m *merger) commit() error {
+	db := m.db
+	dt := m.dataType
+
+	// everything that takes time is done before locking
+	offsets := make([]int64, len(m.files))
+	for i, f := range m.files {
+		if err := f.Sync(); err != nil {
+			m.discard()
 			return err
 		}
-		archedFiles[(*activeFile).id] = *activeFile
-		*activeFile = newf
+		if _, ok := HintNameFormat[dt]; ok {
+			if err := db.writeHint(m.dir, f, dt); err != nil {
+				m.discard()
+				return err
+			}
+		}
+		offsets[i] = f.offset
+	}
+	if err := util.SyncDir(m.dir); err != nil {
+		m.discard()
+		return err
+	}
+
+	man := &manifest{DataType: dt}
+	for _, f := range m.old {
+		man.Old = append(man.Old, f.id)
+	}
+	for _, f := range m.files {
+		man.Merged = append(man.Merged, f.id)
+	}
+	if err := man.write(m.dir); err != nil {
+		m.discard()
+		return err
 	}
 
-	// write entry in active file
-	if err := (*activeFile).Write(e); err != nil {
+	// the merge is committed, reopening db finishes it if it fails from now on
+	if err := m.swap(man, offsets); err != nil {
+		db.degrade(err)
 		return err
 	}
+	return nil
+}
 
-	// update str indexes. list, hash, set, zset exist in memory,
-	// they dont have to update index
-	switch e.GetDataType() {
-	case Str:
-		idx := db.strIndex.idx.Get(e.key).(*Index)
-		idx.fileId = (*activeFile).id
-		idx.offset = (*activeFile).offset - int64(e.Size())
+// replace old files with merged files in memory and disk
+func (m *merger) swap(man *manifest, offsets []int64) error {
+	db := m.db
+	dt := m.dataType
+
+	for _, f := range m.files {
+		if err := f.Close(false); err != nil {
+			return err
+		}
+	}
+
+	mu := db.lockerOf(dt)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// expired keys are not copied
+	db.removeExpired(dt)
+
+	// point indexes to merged files, and count live bytes again
+	l := db.lives[dt]
+	for _, f := range m.old {
+		delete(l.bytes, f.id)
+	}
+	db.snaps.mu.Lock()
+	for _, mv := range m.moves {
+		idx, _ := db.liveIndex(mv.e)
+		if idx != nil && idx.fileId == mv.from.fileId && idx.offset == mv.from.offset {
+			idx.fileId = mv.to.fileId
+			idx.offset = mv.to.offset
+			idx.size = mv.to.size
+			idx.value = nil
+			l.add(idx)
+		}
+		db.snaps.move(dt, mv.from, mv.to)
 	}
+	db.snaps.mu.Unlock()
 
-	// sync buffer with disk
-	if err := (*activeFile).Sync(); err != nil {
+	// remove entries kept are needed, as live entries
+	for id, n := range m.kept {
+		l.bytes[id] += n
+	}
+
+	// old files are in use until now
+	arched := db.archedFiles[dt]
+	for _, f := range m.old {
+		if err := f.Close(false); err != nil {
+			return err
+		}
+		delete(arched, f.id)
+	}
+
+	// merged files take the place of old files with the same id
+	if err := man.apply(db.config.DBDir, m.dir); err != nil {
 		return err
 	}
+	for i, f := range m.files {
+		nf, err := NewFile(db.config.DBDir, f.id, dt, db.config.MaxFileSize, db.checksum())
+		if err != nil {
+			return err
+		}
+		nf.offset = offsets[i]
+		arched[nf.id] = nf
+	}
 
 	return nil
 }
+
+// clear the files in merged directory
+func (db *DB) removeMergedFiles() error {
+	mergedPath := db.config.DBDir + PathSeparator + MergeDirName
+	mInfos, err := ioutil.ReadDir(mergedPath)
+	if err != nil {
+		return err
+	}
+	for _, mi := range mInfos {
+		fp := mergedPath + PathSeparator + mi.Name()
+		if err := os.Remove(fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// while merging, need 'set' or 'update' type of entry, 'remove' type is useless.
+// an entry is valid if it is where the live entry is
+func (db *DB) entryValid(e *Entry, eFid uint32, eOffset int64) bool {
+	if e == nil {
+		return false
+	}
+	if db.snaps.pinned(e.GetDataType(), eFid, eOffset) {
+		return true
+	}
+	idx, key := db.liveIndex(e)
+	if idx == nil || idx.fileId != eFid || idx.offset != eOffset {
+		return false
+	}
+
+	// data of expired key is useless
+	return !db.expiresOf(e.GetDataType()).expired(key)
+}
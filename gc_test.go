@@ -1,9 +1,14 @@
 package CaskDB
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -61,7 +66,7 @@ func TestDB_GC_Str(t *testing.T) {
 	assert.Nil(t, err)
 }
 
-// test List snapshot
+// list elements are merged one by one
 func TestDB_GC_List(t *testing.T) {
 	log.Println("gc list")
 	os.RemoveAll("/tmp/CaskDB")
@@ -75,33 +80,45 @@ func TestDB_GC_List(t *testing.T) {
 	k1 := []byte("k1")
 	k2 := []byte("k2")
 
-	// 0.data.list 1.data.list
-	err = db.RPush(k1, []byte("aa"), []byte("bb"), []byte("cc")) // 60 + 30
+	// 26 + 2 + 8 + 2 = 38 bytes, one element in a file
+	err = db.RPush(k1, []byte("aa"), []byte("bb"), []byte("cc"))
+	err = db.LPush(k2, []byte("aa"), []byte("bb"), []byte("cc"))
 
-	// 1.data.list 2.data.list
-	err = db.LPush(k2, []byte("aa"), []byte("bb"), []byte("cc")) // 30 + 60
-
-	// 3.data.list 4.data.list
-	err = db.LRem(k1, []byte("aa"), 1) // 31
-	err = db.LRem(k2, []byte("aa"), 1) // 31
+	// remove entries are 36 bytes
+	err = db.LRem(k1, []byte("aa"), 1)
+	err = db.LRem(k2, []byte("aa"), 1)
+	err = db.LInsert(k1, []byte("dd"), 1)
+	_, err = db.RPop(k2)
 
 	assert.Nil(t, err)
+	arched := len(db.archedFilesOf(List))
 
 	// merge
 	time.Sleep(8 * time.Second)
-
-	res, err := db.LRange(k1, 0, -1)
-	// 0.data.list
-	assert.Equal(t, "bb", string(res[0])) // 30
-	assert.Equal(t, "cc", string(res[1])) // 30
-
-	res, err = db.LRange(k2, 0, -1)
-	// 1.data.list
-	assert.Equal(t, "cc", string(res[0])) // 30
-	assert.Equal(t, "bb", string(res[1])) // 30
+	assert.True(t, len(db.archedFilesOf(List)) < arched)
+
+	check := func() {
+		res, err := db.LRange(k1, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(res))
+		assert.Equal(t, "bb", string(res[0]))
+		assert.Equal(t, "dd", string(res[1]))
+		assert.Equal(t, "cc", string(res[2]))
+
+		res, err = db.LRange(k2, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(res))
+		assert.Equal(t, "cc", string(res[0]))
+	}
+	check()
 
 	err = db.Close()
 	assert.Nil(t, err)
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
 }
 
 func TestDB_GC_Hash(t *testing.T) {
@@ -225,3 +242,345 @@ func TestDB_GC(t *testing.T) {
 	err = db.Close()
 	assert.Nil(t, err)
 }
+
+// writing and reading go on while merging
+func TestDB_GC_Background(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 1024
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%04d", i)) }
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Set(key(i), []byte(fmt.Sprintf("v%d", round))))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.RPush([]byte("l"), key(i)))
+		assert.Nil(t, db.HSet([]byte("h"), key(i), key(i)))
+	}
+	assert.Nil(t, db.LRem([]byte("l"), key(0), 1))
+	arched := len(db.archedFiles[Str])
+
+	done := make(chan error)
+	go func() {
+		done <- db.GC()
+	}()
+	for i := 0; i < 500; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Set(key(i), []byte("new")))
+		} else {
+			v, err := db.Get(key(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("v2"), v)
+		}
+	}
+	assert.Nil(t, db.Remove(key(1)))
+	assert.Nil(t, db.RPush([]byte("l"), []byte("last")))
+	assert.Nil(t, <-done)
+	assert.True(t, len(db.archedFiles[Str]) < arched)
+
+	check := func() {
+		for i := 0; i < 500; i++ {
+			v, err := db.Get(key(i))
+			assert.Nil(t, err)
+			switch {
+			case i == 1:
+				assert.Nil(t, v)
+			case i%2 == 0:
+				assert.Equal(t, []byte("new"), v)
+			default:
+				assert.Equal(t, []byte("v2"), v)
+			}
+		}
+		l, err := db.LRange([]byte("l"), 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, len(l))
+		assert.Equal(t, key(1), l[0])
+		assert.Equal(t, []byte("last"), l[9])
+		assert.Equal(t, 10, db.HLen([]byte("h")))
+	}
+	check()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+// only files with enough garbage are merged
+func TestDB_FileStats(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// 3 entries of 30 bytes in each file
+	// 0.data.str: aa, bb, cc
+	// 1.data.str: aa, dd, ee
+	// 2.data.str: ff
+	for _, k := range []string{"aa", "bb", "cc", "aa", "dd", "ee", "ff"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("vv")))
+	}
+	stats := db.fileStats(Str)
+	assert.Equal(t, 3, len(stats))
+	assert.Equal(t, int64(90), stats[0].Size)
+	assert.Equal(t, int64(30), stats[0].Dead())
+	assert.Equal(t, int64(0), stats[1].Dead())
+	assert.True(t, stats[2].Active)
+
+	// a third of file 0 is garbage
+	cfg.MergeRatio = 0.5
+	assert.False(t, db.needGC())
+	assert.Nil(t, db.Remove([]byte("bb")))
+	stats = db.fileStats(Str)
+	assert.Equal(t, int64(60), stats[0].Dead())
+	assert.True(t, db.needGC())
+
+	// file 1 is left as it was
+	assert.Nil(t, db.GC())
+	stats = db.fileStats(Str)
+	assert.Equal(t, 3, len(stats))
+	assert.Equal(t, int64(30), stats[0].Size)
+	assert.Equal(t, int64(0), stats[0].Dead())
+	assert.Equal(t, int64(90), stats[1].Size)
+	assert.False(t, db.needGC())
+
+	// hash fields are tracked too
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v1")))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v2")))
+	stats = db.fileStats(Hash)
+	assert.Equal(t, stats[0].Size/2, stats[0].Dead())
+
+	check := func() {
+		for _, k := range []string{"aa", "cc", "dd", "ee", "ff"} {
+			v, err := db.Get([]byte(k))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("vv"), v)
+		}
+		assert.False(t, db.StrKeyExist([]byte("bb")))
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+	stats = db.fileStats(Str)
+	assert.Equal(t, int64(0), stats[0].Dead())
+	assert.Equal(t, int64(0), stats[1].Dead())
+	assert.Nil(t, db.Close())
+}
+
+// a merge is interrupted by a crash after its manifest is written
+func TestDB_GCWithContext(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// 0.data.str: aa, bb, cc
+	// 1.data.str: aa, dd, ee
+	// 2.data.str: ff, remove bb
+	for _, k := range []string{"aa", "bb", "cc", "aa", "dd", "ee", "ff"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("vv")))
+	}
+	assert.Nil(t, db.Remove([]byte("bb")))
+	before := readDataFiles(t, cfg.DBDir)
+
+	// nothing is changed by a cancelled gc
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.GCWithContext(ctx))
+	assert.Equal(t, before, readDataFiles(t, cfg.DBDir))
+	st := db.GCStatus()
+	assert.False(t, st.Running)
+	assert.Equal(t, int64(1), st.FilesTotal)
+	assert.Equal(t, int64(0), st.FilesDone)
+
+	assert.Nil(t, db.GCWithContext(context.Background()))
+	st = db.GCStatus()
+	assert.Equal(t, GCStatus{
+		FilesTotal:     1,
+		FilesDone:      1,
+		BytesRead:      90,
+		BytesWritten:   30,
+		EntriesKept:    1,
+		EntriesDropped: 2,
+	}, st)
+
+	check := func() {
+		for _, k := range []string{"aa", "cc", "dd", "ee", "ff"} {
+			v, err := db.Get([]byte(k))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("vv"), v)
+		}
+		assert.False(t, db.StrKeyExist([]byte("bb")))
+	}
+	check()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check()
+	assert.Nil(t, db.Close())
+}
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintln(v...))
+}
+
+func (l *testLogger) logged(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Health(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	logger := &testLogger{}
+	gcErrs := make(chan error, 10)
+	cfg := DefaultConfig()
+	cfg.MergeInterval = 10 * time.Millisecond
+	cfg.Logger = logger
+	cfg.OnGCError = func(err error) {
+		select {
+		case gcErrs <- err:
+		default:
+		}
+	}
+
+	// a committed merge left behind stops gc
+	mergePath := cfg.DBDir + PathSeparator + MergeDirName
+	assert.Nil(t, os.MkdirAll(mergePath, 0755))
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, (&manifest{DataType: Str}).write(mergePath))
+	assert.Equal(t, ErrorMergeBroken, <-gcErrs)
+	assert.True(t, logger.logged("[GC err]"))
+	h := db.Health()
+	assert.Equal(t, Healthy, h.State)
+	assert.Equal(t, ErrorMergeBroken, h.LastGCErr)
+
+	// nothing is written after db is degraded, but it can be read
+	assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+	db.degrade(ErrorMergeOverflow)
+	assert.True(t, logger.logged("[db degraded]"))
+	h = db.Health()
+	assert.Equal(t, Degraded, h.State)
+	assert.Equal(t, ErrorMergeOverflow, h.Err)
+	assert.Equal(t, ErrorDegraded, db.Set([]byte("k"), []byte("v2")))
+	assert.Equal(t, ErrorDegraded, db.GC())
+	v, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), v)
+	assert.Nil(t, db.Close())
+
+	// reopening finishes the merge
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	assert.True(t, logger.logged("[finish merge]"))
+	assert.Equal(t, Health{}, db.Health())
+	assert.Nil(t, db.Set([]byte("k"), []byte("v2")))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_MergeRecover(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// 0.data.str: aa, bb, cc
+	// 1.data.str: aa, dd, ee
+	// 2.data.str: ff
+	for _, k := range []string{"aa", "bb", "cc", "aa", "dd", "ee", "ff"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("vv")))
+	}
+	assert.Nil(t, db.Remove([]byte("bb")))
+
+	// merge file 0 until the commit point
+	mergePath := cfg.DBDir + PathSeparator + MergeDirName
+	old := db.archedFilesOf(Str)[:1]
+	m := newMerger(db, Str, mergePath, old)
+	for offset := old[0].start(); offset < old[0].offset; {
+		e, err := old[0].Read(offset)
+		assert.Nil(t, err)
+		if db.entryValid(e, old[0].id, offset) {
+			assert.Nil(t, m.write(e, nil))
+		}
+		offset += int64(e.Size())
+	}
+	assert.Equal(t, 1, len(m.files))
+	assert.Nil(t, m.files[0].Sync())
+	assert.Nil(t, db.writeHint(mergePath, m.files[0], Str))
+	assert.Nil(t, m.files[0].Close(false))
+	man := &manifest{DataType: Str, Old: []uint32{0}, Merged: []uint32{0}}
+	assert.Nil(t, man.write(mergePath))
+	assert.Nil(t, db.Close())
+
+	// crash after the hint is moved
+	assert.Nil(t, os.Rename(hintPath(mergePath, 0, Str), hintPath(cfg.DBDir, 0, Str)))
+
+	check := func(db *DB) {
+		for _, k := range []string{"aa", "cc", "dd", "ee", "ff"} {
+			v, err := db.Get([]byte(k))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("vv"), v)
+		}
+		assert.False(t, db.StrKeyExist([]byte("bb")))
+		assert.Equal(t, int64(30), db.fileStats(Str)[0].Size)
+	}
+
+	// read-only mode reads the merged file where it is
+	ro := cfg
+	ro.ReadOnly = true
+	db, err = Open(ro)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(manifestPath(mergePath, Str))
+	assert.Nil(t, err)
+
+	// the merge is finished on Open
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check(db)
+	infos, err := ioutil.ReadDir(mergePath)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(infos))
+	assert.Nil(t, db.Close())
+
+	// merged files without manifest are discarded
+	assert.Nil(t, ioutil.WriteFile(dataPath(mergePath, 1, Str), []byte("garbage"), 0644))
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	check(db)
+	infos, err = ioutil.ReadDir(mergePath)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(infos))
+	assert.Nil(t, db.Close())
+}
@@ -5,5 +5,5 @@ go 1.16
 require (
 	github.com/edsrzf/mmap-go v1.0.0
 	github.com/stretchr/testify v1.7.0
-	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e
 )
@@ -0,0 +1,77 @@
+package CaskDB
+
+import (
+	"log"
+	"sync"
+)
+
+// logger of db, *log.Logger of the standard library is one
+type Logger interface {
+	Println(v ...interface{})
+}
+
+const (
+	Healthy  = iota // db works as usual
+	Degraded        // files may not match memory, nothing is written until db is reopened
+)
+
+type Health struct {
+	State     int
+	Err       error // why db is degraded
+	LastGCErr error // error of the last gc, nil if it succeeded
+}
+
+type health struct {
+	mu        sync.RWMutex
+	state     int
+	err       error
+	lastGCErr error
+}
+
+func (db *DB) Health() Health {
+	h := db.health
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Health{State: h.state, Err: h.err, LastGCErr: h.lastGCErr}
+}
+
+// stop writing after an error which leaves files and memory apart,
+// reopening db finishes the committed merge
+func (db *DB) degrade(err error) {
+	h := db.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == Healthy {
+		db.logger().Println("[db degraded]", err)
+		h.state = Degraded
+		h.err = err
+	}
+}
+
+func (db *DB) setGCErr(err error) {
+	h := db.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastGCErr = err
+}
+
+// files can be changed, unless db is read-only or degraded
+func (db *DB) writable() error {
+	if db.config.ReadOnly {
+		return ErrorReadOnly
+	}
+	h := db.health
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.state != Healthy {
+		return ErrorDegraded
+	}
+	return nil
+}
+
+func (db *DB) logger() Logger {
+	if db.config.Logger != nil {
+		return db.config.Logger
+	}
+	return log.Default()
+}
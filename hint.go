@@ -0,0 +1,189 @@
+package CaskDB
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/k-si/CaskDB/util"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+// hint files are written beside the arched str files, they only keep
+// the position of every entry, so that the str index can be rebuilt
+// without reading the values from data files
+const HintHeaderSize = 34
+
+var HintNameFormat = map[uint16]string{
+	0: "%d.hint.str",
+}
+
+type hint struct {
+
+	// header size: 4 + 8 + 2 + 4 + 4 + 8 + 4 = 34 bytes
+	crc       uint32
+	timestamp uint64
+	state     uint16
+	keySize   uint32
+	fileId    uint32
+	offset    int64
+	size      uint32 // entry size in data file
+
+	key []byte
+}
+
+func hintPath(dir string, fileId uint32, dataType uint16) string {
+	return dir + PathSeparator + fmt.Sprintf(HintNameFormat[dataType], fileId)
+}
+
+func (h *hint) encode() []byte {
+	buf := make([]byte, HintHeaderSize+h.keySize)
+
+	binary.BigEndian.PutUint64(buf[4:12], h.timestamp)
+	binary.BigEndian.PutUint16(buf[12:14], h.state)
+	binary.BigEndian.PutUint32(buf[14:18], h.keySize)
+	binary.BigEndian.PutUint32(buf[18:22], h.fileId)
+	binary.BigEndian.PutUint64(buf[22:30], uint64(h.offset))
+	binary.BigEndian.PutUint32(buf[30:34], h.size)
+	copy(buf[HintHeaderSize:], h.key)
+
+	// crc covers everything behind itself
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// decode all hints in buf, any broken hint makes the whole file useless
+func decodeHints(buf []byte) ([]*hint, error) {
+	var hints []*hint
+
+	for len(buf) > 0 {
+		if len(buf) < HintHeaderSize {
+			return nil, ErrorHintBroken
+		}
+		h := &hint{
+			crc:       binary.BigEndian.Uint32(buf[0:4]),
+			timestamp: binary.BigEndian.Uint64(buf[4:12]),
+			state:     binary.BigEndian.Uint16(buf[12:14]),
+			keySize:   binary.BigEndian.Uint32(buf[14:18]),
+			fileId:    binary.BigEndian.Uint32(buf[18:22]),
+			offset:    int64(binary.BigEndian.Uint64(buf[22:30])),
+			size:      binary.BigEndian.Uint32(buf[30:34]),
+		}
+		end := int64(HintHeaderSize) + int64(h.keySize)
+		if int64(len(buf)) < end {
+			return nil, ErrorHintBroken
+		}
+		if h.crc != crc32.ChecksumIEEE(buf[4:end]) {
+			return nil, ErrorHintBroken
+		}
+		h.key = buf[HintHeaderSize:end]
+		hints = append(hints, h)
+		buf = buf[end:]
+	}
+
+	return hints, nil
+}
+
+// generate the hint file of an arched file
+func (db *DB) writeHintFile(f *File, dataType uint16) error {
+	return db.writeHint(db.config.DBDir, f, dataType)
+}
+
+// generate the hint file in dir
+func (db *DB) writeHint(dir string, f *File, dataType uint16) error {
+	var buf []byte
+
+	offset := f.start()
+	for offset < f.offset {
+		e, err := f.Read(offset)
+		if err != nil {
+			return err
+		}
+		h := &hint{
+			timestamp: e.timestamp,
+			state:     e.state,
+			keySize:   e.keySize,
+			fileId:    f.id,
+			offset:    offset,
+			size:      e.Size(),
+			key:       e.key,
+		}
+		buf = append(buf, h.encode()...)
+		offset += int64(e.Size())
+	}
+
+	// write a temporary file first, make sure that a half written
+	// hint file never replaces a good one
+	path := hintPath(dir, f.id, dataType)
+	tmp := path + ".tmp"
+	if err := util.WriteFileSync(tmp, buf); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rebuild index of an arched file by its hint file
+func (db *DB) loadHintFile(f *File, dataType uint16, r *replayer) error {
+	buf, err := ioutil.ReadFile(hintPath(db.fileDir(dataType, f.id), f.id, dataType))
+	if err != nil {
+		return err
+	}
+	hints, err := decodeHints(buf)
+	if err != nil {
+		return err
+	}
+
+	// check all hints before building any index
+	end := f.start()
+	entries := make([]*Entry, len(hints))
+	for i, h := range hints {
+		if h.fileId != f.id || h.offset != end {
+			return ErrorHintBroken
+		}
+		end += int64(h.size)
+		if end > int64(len(f.mmap)) {
+			return ErrorHintBroken
+		}
+
+		e := &Entry{
+			timestamp: h.timestamp,
+			state:     h.state,
+			keySize:   h.keySize,
+			key:       h.key,
+		}
+
+		// deadline and batch size are in the value
+		switch e.GetMarkType() {
+		case StrExpire, BatchBegin:
+			if e.value, err = f.ReadValue(h.offset); err != nil {
+				return err
+			}
+		}
+		entries[i] = e
+	}
+
+	r.reset()
+	for i, h := range hints {
+		idx := &Index{
+			fileId: f.id,
+			offset: h.offset,
+			size:   h.size,
+		}
+		r.replay(entries[i], idx, h.offset)
+	}
+	f.offset = end
+
+	return nil
+}
+
+// remove the hint file of a data file, it is fine that there is no hint file
+func removeHintFile(dir string, fileId uint32, dataType uint16) error {
+	if _, ok := HintNameFormat[dataType]; !ok {
+		return nil
+	}
+	if err := os.Remove(hintPath(dir, fileId, dataType)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDB_Hint(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 80
+
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// 0.data.str 1.data.str 2.data.str
+	err = db.Set([]byte("aa"), []byte("11")) // 30
+	err = db.Set([]byte("bb"), []byte("22")) // 60
+	err = db.Set([]byte("aa"), []byte("33")) // 30
+	err = db.Remove([]byte("bb"))            // 58
+	err = db.Set([]byte("cc"), []byte("44")) // 30
+	assert.Nil(t, err)
+
+	// every arched file has its hint
+	_, err = os.Stat(hintPath(cfg.DBDir, 0, Str))
+	assert.Nil(t, err)
+	_, err = os.Stat(hintPath(cfg.DBDir, 1, Str))
+	assert.Nil(t, err)
+	_, err = os.Stat(hintPath(cfg.DBDir, 2, Str))
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+
+	aa, err := db.Get([]byte("aa"))
+	assert.Equal(t, []byte("33"), aa)
+	bb, err := db.Get([]byte("bb"))
+	assert.Nil(t, bb)
+	cc, err := db.Get([]byte("cc"))
+	assert.Equal(t, []byte("44"), cc)
+
+	// arched file offset comes from hint
+	assert.Equal(t, int64(FileHeaderSize+58), db.archedFiles[Str][1].offset)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Hint_Broken(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 80
+
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		err = db.Set([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)))
+		assert.Nil(t, err)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// flip a byte of hint, and remove another hint
+	path := hintPath(cfg.DBDir, 0, Str)
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	b[len(b)-1] ^= 0xff
+	err = ioutil.WriteFile(path, b, 0644)
+	assert.Nil(t, err)
+	err = os.Remove(hintPath(cfg.DBDir, 1, Str))
+	assert.Nil(t, err)
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		v, err := db.Get([]byte(fmt.Sprintf("k%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), v)
+	}
+
+	// hints are written again after full scan
+	_, err = os.Stat(hintPath(cfg.DBDir, 1, Str))
+	assert.Nil(t, err)
+	b2, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotEqual(t, b, b2)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
@@ -1,8 +1,10 @@
 package CaskDB
 
 import (
+	"fmt"
 	"github.com/k-si/CaskDB/ds"
 	"github.com/k-si/CaskDB/util"
+	"os"
 	"sync"
 )
 
@@ -10,6 +12,7 @@ type Index struct {
 	//valueSize uint32
 	fileId uint32
 	offset int64
+	size   uint32 // entry size
 	value  []byte
 }
 
@@ -22,33 +25,56 @@ func (db *DB) buildStrIndex(e *Entry, idx *Index) {
 	switch e.GetMarkType() {
 	case StrSet:
 		db.strIndex.idx.Put(e.key, idx)
+		delete(db.strIndex.exp, string(e.key))
 	case StrRemove:
 		db.strIndex.idx.Remove(e.key)
+		delete(db.strIndex.exp, string(e.key))
+	case StrExpire:
+		db.buildExpire(e)
 	}
 }
 
-func (db *DB) buildListIndex(e *Entry) {
+func (db *DB) buildListIndex(e *Entry, idx *Index) {
+	switch e.GetMarkType() {
+	case ListSeqSet:
+		db.listIndex.idx.Put(e.GetPreKey(), e.GetPostKey(), idx)
+	case ListSeqDel:
+		db.listIndex.idx.Remove(e.GetPreKey(), e.GetPostKey())
+		db.forgetEmpty(List, e.GetPreKey())
+	case ListClear:
+		db.clearKey(List, string(e.key))
+	case ListExpire:
+		db.buildExpire(e)
+	default:
+		db.buildLegacyList(e)
+	}
+}
+
+// lists of old files are built by position, they are
+// turned into sequences after loading
+func (db *DB) buildLegacyList(e *Entry) {
+	legacy := db.listIndex.legacy
 	switch e.GetMarkType() {
 	case ListLPush:
-		db.listIndex.idx.Push(true, string(e.key), e.value)
+		legacy.Push(true, string(e.key), e.value)
 	case ListLPop:
-		db.listIndex.idx.Pop(true, string(e.key))
+		legacy.Pop(true, string(e.key))
 	case ListRPush:
-		db.listIndex.idx.Push(false, string(e.key), e.value)
+		legacy.Push(false, string(e.key), e.value)
 	case ListRPop:
-		db.listIndex.idx.Pop(false, string(e.key))
+		legacy.Pop(false, string(e.key))
 	case ListLSet:
 		n := util.BytesToInt(e.GetPostBytesKey())
-		db.listIndex.idx.Put(e.GetPreKey(), e.value, n)
+		legacy.Put(e.GetPreKey(), e.value, n)
 	case ListLInsert:
 		n := util.BytesToInt(e.GetPostBytesKey())
-		db.listIndex.idx.Insert(e.GetPreKey(), ds.Before, n, e.value)
+		legacy.Insert(e.GetPreKey(), ds.Before, n, e.value)
 	case ListRInsert:
 		n := util.BytesToInt(e.GetPostBytesKey())
-		db.listIndex.idx.Insert(e.GetPreKey(), ds.After, n, e.value)
+		legacy.Insert(e.GetPreKey(), ds.After, n, e.value)
 	case ListLRem:
 		n := util.BytesToInt(e.GetPostBytesKey())
-		db.listIndex.idx.Remove(e.GetPreKey(), e.value, n)
+		legacy.Remove(e.GetPreKey(), e.value, n)
 	}
 }
 
@@ -58,6 +84,11 @@ func (db *DB) buildHashIndex(e *Entry) {
 		db.hashIndex.idx.Put(e.GetPreKey(), e.GetPostKey(), e.value)
 	case HashHDel:
 		db.hashIndex.idx.Remove(e.GetPreKey(), e.GetPostKey())
+		db.forgetEmpty(Hash, e.GetPreKey())
+	case HashExpire:
+		db.buildExpire(e)
+	case HashClear:
+		db.clearKey(Hash, string(e.key))
 	}
 }
 
@@ -67,8 +98,14 @@ func (db *DB) buildSetIndex(e *Entry) {
 		db.setIndex.idx.Add(string(e.key), string(e.value))
 	case SetSRem:
 		db.setIndex.idx.Remove(string(e.key), string(e.value))
+		db.forgetEmpty(Set, string(e.key))
 	case SetSMove:
 		db.setIndex.idx.Move(e.GetPreKey(), e.GetPostKey(), string(e.value))
+		db.forgetEmpty(Set, e.GetPreKey())
+	case SetExpire:
+		db.buildExpire(e)
+	case SetClear:
+		db.clearKey(Set, string(e.key))
 	}
 }
 
@@ -79,6 +116,33 @@ func (db *DB) buildZSetIndex(e *Entry) {
 		db.zsetIndex.idx.Add(e.GetPreKey(), string(e.value), score)
 	case ZSetZRem:
 		db.zsetIndex.idx.Remove(string(e.key), string(e.value))
+		db.forgetEmpty(ZSet, string(e.key))
+	case ZSetExpire:
+		db.buildExpire(e)
+	case ZSetClear:
+		db.clearKey(ZSet, string(e.key))
+	}
+}
+
+// modify the index in memory according to the data operation type
+func (db *DB) buildIndex(e *Entry, idx *Index) {
+
+	// key may be expired before this entry was written
+	db.expireBeforeEntry(e)
+	db.track(e, idx)
+
+	// different data types correspond to different index types
+	switch e.GetDataType() {
+	case Str:
+		db.buildStrIndex(e, idx)
+	case List:
+		db.buildListIndex(e, idx)
+	case Hash:
+		db.buildHashIndex(e)
+	case Set:
+		db.buildSetIndex(e)
+	case ZSet:
+		db.buildZSetIndex(e)
 	}
 }
 
@@ -88,15 +152,18 @@ func (db *DB) loadIndexes(fids map[int][]int) (err error) {
 	var loadErr error
 
 	wg := sync.WaitGroup{}
+	replayers := make([]*replayer, DataTypeNum)
 
 	// every goroutine do its type
 	for i := 0; i < DataTypeNum; i++ {
 		wg.Add(1)
+		replayers[i] = newReplayer(db)
 
 		go func(i int) {
 			defer wg.Done()
 
 			ids := fids[i]
+			r := replayers[i]
 
 			// traverse arched files
 			for j := 0; j < len(ids)-1; j++ {
@@ -105,15 +172,34 @@ func (db *DB) loadIndexes(fids map[int][]int) (err error) {
 					loadErr = err
 					return
 				}
-				if err := db.loadFileIndexes(af); err != nil {
+
+				// hint file is much smaller than data file, use it first
+				if _, ok := HintNameFormat[uint16(i)]; ok {
+					err := db.loadHintFile(af, uint16(i), r)
+					if err == nil {
+						continue
+					}
+					if !os.IsNotExist(err) {
+						db.logger().Println("[load hint err]", af.id, err)
+					}
+				}
+
+				if err := db.loadFileIndexes(af, uint16(i), r); err != nil {
 					loadErr = err
 					return
 				}
+
+				// next time we can start with hint
+				if _, ok := HintNameFormat[uint16(i)]; ok && !db.config.ReadOnly {
+					if err := db.writeHintFile(af, uint16(i)); err != nil {
+						db.logger().Println("[write hint err]", err)
+					}
+				}
 			}
 
 			// traverse active files
 			f := db.activeFiles[i]
-			if err := db.loadFileIndexes(f); err != nil {
+			if err := db.loadFileIndexes(f, uint16(i), r); err != nil {
 				loadErr = err
 				return
 			}
@@ -126,52 +212,93 @@ func (db *DB) loadIndexes(fids map[int][]int) (err error) {
 		return loadErr
 	}
 
-	return
+	// a batch may be broken off at the end of active files
+	if err := db.resolveBatches(replayers); err != nil {
+		return err
+	}
+
+	// the keys expired while db was closed
+	for i := uint16(0); i < DataTypeNum; i++ {
+		db.removeExpired(i)
+	}
+
+	return db.upgradeLists()
 }
 
-func (db *DB) loadFileIndexes(f *File) error {
+func (db *DB) loadFileIndexes(f *File, dataType uint16, r *replayer) error {
 
 	// there may be no files at the beginning
 	// Pay attention to null pointers when loading
 	if f != nil {
 
-		var offset int64
+		// batch never crosses files
+		r.reset()
 
-		// read entry from file
-		for offset < db.config.MaxFileSize {
-			if e, err := f.Read(offset); err == nil {
+		offset := f.start()
+		active := f == db.activeFiles[dataType]
 
-				// different data types correspond to different index types
-				switch e.GetDataType() {
-				case Str:
-					idx := &Index{
-						fileId: f.id,
-						offset: offset,
-					}
-					db.buildStrIndex(e, idx)
-				case List:
-					db.buildListIndex(e)
-				case Hash:
-					db.buildHashIndex(e)
-				case Set:
-					db.buildSetIndex(e)
-				case ZSet:
-					db.buildZSetIndex(e)
+		// read entry from file, the rest space which is less than
+		// a header can not hold any entry
+		for offset+EntryHeaderSize <= int64(len(f.mmap)) {
+			e, err := f.Read(offset)
+
+			// a torn header may still pass the crc check
+			if err == nil && (e.GetDataType() != dataType || e.keySize == 0) {
+				err = ErrorBadEntry
+			}
+
+			if err == nil {
+				idx := &Index{
+					fileId: f.id,
+					offset: offset,
+					size:   e.Size(),
 				}
+				r.replay(e, idx, offset)
 				offset += int64(e.Size())
-			} else if err == ErrorEmptyHeader {
+			} else if err == ErrorEmptyHeader && !active {
 
 				// the file is full of 0
 				// reading an empty header means reading to the end
-				f.offset = offset
+				break
+			} else if active {
 
-				// read to end, then break
+				// power loss may leave the last entry half written
+				if err := db.recoverTail(f, dataType, offset, err); err != nil {
+					return err
+				}
 				break
 			} else {
 				return err
 			}
 		}
+		f.offset = offset
 	}
 
 	return nil
 }
+
+// the entry at offset of active file can not be read. if no good entry
+// follows it, it is a torn write at the tail, we cut it off. otherwise
+// the file is corrupted in the middle, which can not be fixed here.
+func (db *DB) recoverTail(f *File, dataType uint16, offset int64, readErr error) error {
+	end := dataEnd(f)
+	if end <= offset {
+		return nil
+	}
+	if readErr == ErrorEmptyHeader {
+		readErr = ErrorBadEntry
+	}
+	if nextEntry(f, offset+1, end, dataType, db.config) >= 0 {
+		return readErr
+	}
+
+	if db.config.ReadOnly {
+		f.offset = offset
+		return nil
+	}
+
+	db.logger().Println("[drop torn write]", fmt.Sprintf(FileNameFormat[dataType], f.id), "offset:", offset, "bytes:", end-offset, "err:", readErr)
+	f.offset = end
+	f.truncate(offset)
+	return f.Sync()
+}
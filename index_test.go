@@ -0,0 +1,81 @@
+package CaskDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// write part of an entry at the end of active file, as a crash does
+func writeTorn(t *testing.T, db *DB, e *Entry, n int) {
+	b, err := e.Encode()
+	assert.Nil(t, err)
+	f := db.activeFiles[e.GetDataType()]
+	copy(f.mmap[f.offset:], b[:n])
+}
+
+func TestDB_TornWrite(t *testing.T) {
+	e := NewEntry([]byte("k2"), []byte("value2"), Str, StrSet, 0)
+	for _, n := range []int{10, EntryHeaderSize, int(e.Size()) - 1} {
+		os.RemoveAll("/tmp/CaskDB")
+		db, err := Open(DefaultConfig())
+		assert.Nil(t, err)
+		assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+		assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+		off := db.activeFiles[Str].offset
+		writeTorn(t, db, e, n)
+		assert.Nil(t, db.Close())
+
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+		assert.Equal(t, off, db.activeFiles[Str].offset)
+		assert.True(t, db.StrKeyExist([]byte("k1")))
+		assert.False(t, db.StrKeyExist([]byte("k2")))
+
+		// the space is clean for new entries
+		assert.Nil(t, db.Set([]byte("k3"), []byte("v3")))
+		assert.Nil(t, db.Close())
+
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+		v, err := db.Get([]byte("k3"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v3"), v)
+		assert.Nil(t, db.Close())
+	}
+}
+
+func TestDB_CorruptedMiddle(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	// 3 entries of 30 bytes in each file
+	for _, k := range []string{"k0", "k1", "k2", "k3", "k4", "k5"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("vv")))
+	}
+	assert.Equal(t, uint32(1), db.activeFiles[Str].id)
+
+	// bad entry followed by good entries in active file
+	db.activeFiles[Str].mmap[FileHeaderSize+30+28] ^= 0xff
+	assert.Nil(t, db.Close())
+	_, err = Open(cfg)
+	assert.Equal(t, ErrorCrcCheck, err)
+
+	// bad entry at the tail of arched file
+	os.RemoveAll("/tmp/CaskDB")
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	for _, k := range []string{"k0", "k1", "k2", "k3"} {
+		assert.Nil(t, db.Set([]byte(k), []byte("vv")))
+	}
+	db.archedFiles[Str][0].mmap[FileHeaderSize+60+28] ^= 0xff
+	assert.Nil(t, db.Close())
+
+	// hint file does not check crc, scan the data file
+	assert.Nil(t, removeHintFile(cfg.DBDir, 0, Str))
+	_, err = Open(cfg)
+	assert.Equal(t, ErrorCrcCheck, err)
+}
@@ -0,0 +1,178 @@
+package CaskDB
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// bounds of an iterator, they are combined if more than one is set
+type IteratorOptions struct {
+	Prefix  []byte // only keys with the prefix
+	Start   []byte // keys not less than it
+	End     []byte // keys less than it, nil means no end
+	Reverse bool   // from greater keys to less keys
+}
+
+// iterator over str keys in order. it only keeps the key it is at, and
+// looks the index up again on every move, so writing and merging go on
+// while iterating, and each move sees the index at that time.
+// values are read from files when Value is called
+type Iterator struct {
+	db      *DB
+	reverse bool
+	lower   []byte // included
+	upper   []byte // excluded, nil means no bound
+	key     []byte // nil means out of keys
+	closed  bool
+}
+
+// the iterator is at its first key
+func (db *DB) NewIterator(opt IteratorOptions) *Iterator {
+	it := &Iterator{
+		db:      db,
+		reverse: opt.Reverse,
+		lower:   opt.Start,
+		upper:   opt.End,
+	}
+	if len(opt.Prefix) > 0 {
+		if bytes.Compare(opt.Prefix, it.lower) > 0 {
+			it.lower = opt.Prefix
+		}
+		if end := prefixEnd(opt.Prefix); end != nil && (it.upper == nil || bytes.Compare(end, it.upper) < 0) {
+			it.upper = end
+		}
+	}
+	it.Rewind()
+	return it
+}
+
+// go to the first key
+func (it *Iterator) Rewind() {
+	if it.reverse {
+		it.find(it.upper, false, true)
+	} else {
+		it.find(it.lower, true, false)
+	}
+}
+
+// go to the first key not less than key,
+// or not greater than key in reverse order
+func (it *Iterator) Seek(key []byte) {
+	if it.reverse {
+		if it.upper != nil && bytes.Compare(key, it.upper) >= 0 {
+			it.find(it.upper, false, true)
+			return
+		}
+		it.find(key, false, false)
+		return
+	}
+	if bytes.Compare(key, it.lower) < 0 {
+		key = it.lower
+	}
+	it.find(key, true, false)
+}
+
+// go to the next key in order
+func (it *Iterator) Next() {
+	if it.key != nil {
+		it.find(it.key, !it.reverse, true)
+	}
+}
+
+// go back to the previous key in order
+func (it *Iterator) Prev() {
+	if it.key != nil {
+		it.find(it.key, it.reverse, true)
+	}
+}
+
+// false when the iterator goes past the last key
+func (it *Iterator) Valid() bool {
+	return it.key != nil
+}
+
+// a copy of the current key, changing it does not touch the index
+func (it *Iterator) Key() []byte {
+	if it.key == nil {
+		return nil
+	}
+	return append([]byte(nil), it.key...)
+}
+
+// value of the current key, nil if the key is removed or expired after moving here
+func (it *Iterator) Value() ([]byte, error) {
+	if it.key == nil {
+		return nil, ErrorKeyNotExist
+	}
+	db := it.db
+	if atomic.LoadUint32(&db.isClosed) == 1 {
+		return nil, ErrorClosedDB
+	}
+
+	// files are not swapped by merging meanwhile
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+	return db.getVal(it.key)
+}
+
+func (it *Iterator) Close() {
+	it.closed = true
+	it.key = nil
+}
+
+// find the nearest key in bounds from key, greater keys if forward,
+// key itself is included unless strict. nil key means from the end
+func (it *Iterator) find(key []byte, forward, strict bool) {
+	it.key = nil
+	if it.closed {
+		return
+	}
+
+	db := it.db
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	idx := db.strIndex.idx
+	for {
+		var k []byte
+		switch {
+		case forward && key == nil:
+			k, _ = idx.First()
+		case forward:
+			k, _ = idx.Ceiling(key, strict)
+		case key == nil:
+			k, _ = idx.Last()
+		default:
+			k, _ = idx.Floor(key, strict)
+		}
+		if k == nil || !it.inBounds(k) {
+			return
+		}
+
+		// expired keys are skipped
+		if !db.strIndex.exp.expired(string(k)) {
+			it.key = k
+			return
+		}
+		key, strict = k, true
+	}
+}
+
+func (it *Iterator) inBounds(key []byte) bool {
+	if bytes.Compare(key, it.lower) < 0 {
+		return false
+	}
+	return it.upper == nil || bytes.Compare(key, it.upper) < 0
+}
+
+// the least key greater than all keys with prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package CaskDB
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/k-si/CaskDB/util"
+	"io/ioutil"
+	"os"
+)
+
+// a merge is committed once its manifest is written in the merged dir,
+// the files are switched after that. on Open, a committed merge is
+// finished, and merged files without a manifest are discarded
+var ManifestNameFormat = "MANIFEST.%s"
+
+type manifest struct {
+	DataType uint16   `json:"data_type"`
+	Old      []uint32 `json:"old"`    // ids of the files replaced by merging
+	Merged   []uint32 `json:"merged"` // ids of merged files, they take the ids of old files in order
+}
+
+// old files which no merged file takes the place of
+func (m *manifest) removed() []uint32 {
+	merged := make(map[uint32]bool)
+	for _, id := range m.Merged {
+		merged[id] = true
+	}
+	var ids []uint32
+	for _, id := range m.Old {
+		if !merged[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func manifestPath(dir string, dataType uint16) string {
+	return dir + PathSeparator + fmt.Sprintf(ManifestNameFormat, FileNameSuffix[dataType])
+}
+
+func dataPath(dir string, fileId uint32, dataType uint16) string {
+	return dir + PathSeparator + fmt.Sprintf(FileNameFormat[dataType], fileId)
+}
+
+// write the manifest atomically, the merge is committed when it returns nil
+func (m *manifest) write(dir string) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := manifestPath(dir, m.DataType)
+	tmp := path + ".tmp"
+	if err := util.WriteFileSync(tmp, b); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := util.SyncDir(dir); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// move merged files to db dir and remove the rest old files, then drop the manifest.
+// it can be done again and again until it is finished
+func (m *manifest) apply(dbDir, mergeDir string) error {
+	dt := m.DataType
+	for _, id := range m.Merged {
+
+		// hint goes first, the old hint must not be left with the merged file
+		if _, ok := HintNameFormat[dt]; ok {
+			if _, err := os.Stat(hintPath(mergeDir, id, dt)); err == nil {
+				if err := os.Rename(hintPath(mergeDir, id, dt), hintPath(dbDir, id, dt)); err != nil {
+					return err
+				}
+			} else if _, err := os.Stat(dataPath(mergeDir, id, dt)); err == nil {
+				if err := removeHintFile(dbDir, id, dt); err != nil {
+					return err
+				}
+			}
+		}
+		if err := util.RenameIfExist(dataPath(mergeDir, id, dt), dataPath(dbDir, id, dt)); err != nil {
+			return err
+		}
+	}
+	for _, id := range m.removed() {
+		if err := util.RemoveIfExist(dataPath(dbDir, id, dt)); err != nil {
+			return err
+		}
+		if err := removeHintFile(dbDir, id, dt); err != nil {
+			return err
+		}
+	}
+	if err := util.SyncDir(dbDir); err != nil {
+		return err
+	}
+
+	if err := os.Remove(manifestPath(mergeDir, dt)); err != nil {
+		return err
+	}
+	return util.SyncDir(mergeDir)
+}
+
+// committed merges in the merged dir
+func readManifests(mergeDir string) ([]*manifest, error) {
+	var mans []*manifest
+	for i := uint16(0); i < DataTypeNum; i++ {
+		b, err := ioutil.ReadFile(manifestPath(mergeDir, i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := &manifest{}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, err
+		}
+		if m.DataType != i {
+			return nil, ErrorMergeBroken
+		}
+		mans = append(mans, m)
+	}
+	return mans, nil
+}
+
+// finish the merges interrupted by a crash, and discard the others.
+// read-only mode can not change the files, it reads them as if merges were finished
+func (db *DB) recoverMerge() error {
+	mergePath := db.config.DBDir + PathSeparator + MergeDirName
+	mans, err := readManifests(mergePath)
+	if err != nil {
+		return err
+	}
+
+	if db.config.ReadOnly {
+		db.pending = mans
+		return nil
+	}
+
+	for _, m := range mans {
+		db.logger().Println("[finish merge]", FileNameSuffix[m.DataType])
+		if err := m.apply(db.config.DBDir, mergePath); err != nil {
+			return err
+		}
+	}
+	return db.removeMergedFiles()
+}
+
+// ids of files after unfinished merges
+func (db *DB) pendingIds(dataType uint16, ids []int) []int {
+	removed := make(map[int]bool)
+	var merged []int
+	for _, m := range db.pending {
+		if m.DataType != dataType {
+			continue
+		}
+		for _, id := range m.removed() {
+			removed[int(id)] = true
+		}
+		for _, id := range m.Merged {
+			merged = append(merged, int(id))
+		}
+	}
+	if len(removed) == 0 && len(merged) == 0 {
+		return ids
+	}
+
+	exist := make(map[int]bool)
+	var res []int
+	for _, id := range append(ids, merged...) {
+		if !removed[id] && !exist[id] {
+			exist[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// dir where a data file and its hint are, a merged file that has not been
+// moved yet is still in merged dir
+func (db *DB) fileDir(dataType uint16, fileId uint32) string {
+	mergePath := db.config.DBDir + PathSeparator + MergeDirName
+	for _, m := range db.pending {
+		if m.DataType != dataType {
+			continue
+		}
+		for _, id := range m.Merged {
+			if id != fileId {
+				continue
+			}
+			if _, err := os.Stat(dataPath(mergePath, id, dataType)); err == nil {
+				return mergePath
+			}
+		}
+	}
+	return db.config.DBDir
+}
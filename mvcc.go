@@ -0,0 +1,600 @@
+package CaskDB
+
+import (
+	"github.com/k-si/CaskDB/ds"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// point-in-time view of all data types. taking a snapshot copies nothing,
+// the first time a key is changed after it, the old version of the key is
+// saved in every open snapshot, so a snapshot costs memory in proportion to
+// the keys changed while it is open. values of str and list are still read
+// from files, gc keeps the entries that open snapshots need. a snapshot
+// must be closed when it is no longer used
+type Snapshot struct {
+	db     *DB
+	at     int64 // unix nano when it is taken, keys expire by it
+	saved  [DataTypeNum]map[string]struct{}
+	old    snapIndexes // old versions of saved keys, a saved key not in it did not exist
+	pinned [DataTypeNum][]*Index
+	closed uint32
+}
+
+// indexes holding old versions, they are guarded by the locks of db indexes
+type snapIndexes struct {
+	str  *StrIndex
+	list *ListIndex
+	hash *HashIndex
+	set  *SetIndex
+	zset *ZSetIndex
+}
+
+// open snapshots, and the entries they keep in files
+type snapshots struct {
+	mu   sync.Mutex
+	n    int32 // number of open snapshots, writes skip saving when it is 0
+	open map[*Snapshot]struct{}
+	pins []map[indexLoc]*pin // pins[dataType] = where -> entry needed by snapshots
+}
+
+type indexLoc struct {
+	fileId uint32
+	offset int64
+}
+
+type pin struct {
+	idx *Index
+	n   int
+}
+
+func newSnapshots() *snapshots {
+	ss := &snapshots{
+		open: make(map[*Snapshot]struct{}),
+		pins: make([]map[indexLoc]*pin, DataTypeNum),
+	}
+	for i := range ss.pins {
+		ss.pins[i] = make(map[indexLoc]*pin)
+	}
+	return ss
+}
+
+func locOf(idx *Index) indexLoc {
+	return indexLoc{fileId: idx.fileId, offset: idx.offset}
+}
+
+// take a snapshot of the db, nothing is written meanwhile
+func (db *DB) Snapshot() (*Snapshot, error) {
+	if atomic.LoadUint32(&db.isClosed) == 1 {
+		return nil, ErrorClosedDB
+	}
+	defer db.lockAll()()
+
+	s := &Snapshot{
+		db: db,
+		at: time.Now().UnixNano(),
+		old: snapIndexes{
+			str:  NewStrIndex(),
+			list: NewListIndex(),
+			hash: NewHashIndex(),
+			set:  NewSetIndex(),
+			zset: NewZSetIndex(),
+		},
+	}
+	for i := range s.saved {
+		s.saved[i] = make(map[string]struct{})
+	}
+
+	ss := db.snaps
+	ss.mu.Lock()
+	ss.open[s] = struct{}{}
+	atomic.AddInt32(&ss.n, 1)
+	ss.mu.Unlock()
+	return s, nil
+}
+
+// release the snapshot, entries kept for it can be merged away
+func (s *Snapshot) Close() {
+	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		return
+	}
+	ss := s.db.snaps
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.open, s)
+	atomic.AddInt32(&ss.n, -1)
+	for dt, idxs := range s.pinned {
+		ss.unpin(uint16(dt), idxs)
+	}
+}
+
+// save the current version of keys in all open snapshots which have not
+// saved them. it is called before keys are changed, caller must hold the
+// write lock of dataType
+func (ss *snapshots) save(dataType uint16, keys ...string) {
+	if atomic.LoadInt32(&ss.n) == 0 {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for s := range ss.open {
+		for _, k := range keys {
+			s.save(dataType, k)
+		}
+	}
+}
+
+func (s *Snapshot) save(dataType uint16, key string) {
+	if _, ok := s.saved[dataType][key]; ok {
+		return
+	}
+	s.saved[dataType][key] = struct{}{}
+
+	db := s.db
+	if !db.keyInIndex(dataType, key) {
+		return
+	}
+	if d, ok := db.expiresOf(dataType)[key]; ok {
+		s.old.expiresOf(dataType)[key] = d
+	}
+
+	switch dataType {
+	case Str:
+		idx := db.strIndex.idx.Get([]byte(key)).(*Index)
+		s.old.str.idx.Put([]byte(key), idx)
+		s.pin(Str, idx)
+	case List:
+		for _, el := range db.listIndex.idx.Range(key, 0, -1) {
+			idx := el.Value.(*Index)
+			s.old.list.idx.Put(key, el.Seq, idx)
+			s.pin(List, idx)
+		}
+	case Hash:
+		kvs := db.hashIndex.idx.GetAll(key)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			s.old.hash.idx.Put(key, string(kvs[i]), kvs[i+1])
+		}
+	case Set:
+		for _, m := range db.setIndex.idx.Scan(key) {
+			s.old.set.idx.Add(key, string(m))
+		}
+	case ZSet:
+		ms := db.zsetIndex.idx.RangeByScore(key, math.Inf(-1), math.Inf(1))
+		for i := 0; i+1 < len(ms); i += 2 {
+			s.old.zset.idx.Add(key, ms[i].(string), ms[i+1].(float64))
+		}
+	}
+}
+
+// keep the entry at idx in files, values in memory need nothing
+func (s *Snapshot) pin(dataType uint16, idx *Index) {
+	if idx.size == 0 {
+		return
+	}
+	ps := s.db.snaps.pins[dataType]
+	loc := locOf(idx)
+	p, ok := ps[loc]
+	if !ok {
+		p = &pin{idx: idx}
+		ps[loc] = p
+	}
+	p.n++
+	s.pinned[dataType] = append(s.pinned[dataType], idx)
+}
+
+func (ss *snapshots) unpin(dataType uint16, idxs []*Index) {
+	ps := ss.pins[dataType]
+	for _, idx := range idxs {
+		loc := locOf(idx)
+		if p, ok := ps[loc]; ok {
+			if p.n--; p.n == 0 {
+				delete(ps, loc)
+			}
+		}
+	}
+}
+
+// whether the entry at fileId and offset is needed by snapshots
+func (ss *snapshots) pinned(dataType uint16, fileId uint32, offset int64) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	_, ok := ss.pins[dataType][indexLoc{fileId: fileId, offset: offset}]
+	return ok
+}
+
+// whether snapshots need any entry of dataType
+func (ss *snapshots) pinning(dataType uint16) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return len(ss.pins[dataType]) > 0
+}
+
+// the entry needed by snapshots is moved to merged file,
+// caller must hold ss.mu and the write lock of dataType
+func (ss *snapshots) move(dataType uint16, from, to *Index) {
+	ps := ss.pins[dataType]
+	p, ok := ps[locOf(from)]
+	if !ok {
+		return
+	}
+	delete(ps, locOf(from))
+	p.idx.fileId = to.fileId
+	p.idx.offset = to.offset
+	p.idx.size = to.size
+	p.idx.value = nil
+	ps[locOf(to)] = p
+}
+
+// all files are going to be removed, save all keys in open snapshots and
+// read the values they need from files. caller must hold all write locks
+func (ss *snapshots) detach() error {
+	if atomic.LoadInt32(&ss.n) == 0 {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	// values are read once for all snapshots
+	read := make(map[*Index]*Index)
+	value := func(s *Snapshot, dataType uint16, idx *Index) (*Index, error) {
+		if idx.size == 0 {
+			return idx, nil
+		}
+		if v, ok := read[idx]; ok {
+			return v, nil
+		}
+		v, err := s.db.readValue(dataType, idx)
+		if err != nil {
+			return nil, err
+		}
+		read[idx] = &Index{value: v}
+		return read[idx], nil
+	}
+
+	for s := range ss.open {
+		for i := uint16(0); i < DataTypeNum; i++ {
+			for _, k := range s.db.allKeys(i) {
+				s.save(i, k)
+			}
+		}
+		for _, k := range s.old.str.idx.GetAllKeys() {
+			idx, err := value(s, Str, s.old.str.idx.Get(k).(*Index))
+			if err != nil {
+				return err
+			}
+			s.old.str.idx.Put(k, idx)
+		}
+		for _, k := range s.old.list.idx.GetAllKeys() {
+			for _, el := range s.old.list.idx.Range(k, 0, -1) {
+				idx, err := value(s, List, el.Value.(*Index))
+				if err != nil {
+					return err
+				}
+				s.old.list.idx.Put(k, el.Seq, idx)
+			}
+		}
+	}
+
+	for s := range ss.open {
+		for i := range s.pinned {
+			s.pinned[i] = nil
+		}
+	}
+	for i := range ss.pins {
+		ss.pins[i] = make(map[indexLoc]*pin)
+	}
+	return nil
+}
+
+func (si *snapIndexes) expiresOf(dataType uint16) expires {
+	switch dataType {
+	case Str:
+		return si.str.exp
+	case List:
+		return si.list.exp
+	case Hash:
+		return si.hash.exp
+	case Set:
+		return si.set.exp
+	default:
+		return si.zset.exp
+	}
+}
+
+func (s *Snapshot) check(keys ...[]byte) error {
+	if atomic.LoadUint32(&s.closed) == 1 {
+		return ErrorSnapshotClosed
+	}
+	if atomic.LoadUint32(&s.db.isClosed) == 1 {
+		return ErrorClosedDB
+	}
+	return s.db.checkKeysSize(keys...)
+}
+
+func (s *Snapshot) isSaved(dataType uint16, key []byte) bool {
+	_, ok := s.saved[dataType][string(key)]
+	return ok
+}
+
+func (s *Snapshot) value(dataType uint16, idx *Index) ([]byte, error) {
+	if idx.size == 0 {
+		return idx.value, nil
+	}
+	return s.db.readValue(dataType, idx)
+}
+
+func (s *Snapshot) str(key []byte) *StrIndex {
+	if s.isSaved(Str, key) {
+		return s.old.str
+	}
+	return s.db.strIndex
+}
+
+func (s *Snapshot) list(key []byte) *ListIndex {
+	if s.isSaved(List, key) {
+		return s.old.list
+	}
+	return s.db.listIndex
+}
+
+func (s *Snapshot) hash(key []byte) *HashIndex {
+	if s.isSaved(Hash, key) {
+		return s.old.hash
+	}
+	return s.db.hashIndex
+}
+
+func (s *Snapshot) set(key []byte) *SetIndex {
+	if s.isSaved(Set, key) {
+		return s.old.set
+	}
+	return s.db.setIndex
+}
+
+func (s *Snapshot) zset(key []byte) *ZSetIndex {
+	if s.isSaved(ZSet, key) {
+		return s.old.zset
+	}
+	return s.db.zsetIndex
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if err := s.check(key); err != nil {
+		return nil, err
+	}
+	s.db.strIndex.mu.RLock()
+	defer s.db.strIndex.mu.RUnlock()
+	return s.getVal(key)
+}
+
+func (s *Snapshot) MGet(keys ...[]byte) ([][]byte, error) {
+	if err := s.check(keys...); err != nil {
+		return nil, err
+	}
+	s.db.strIndex.mu.RLock()
+	defer s.db.strIndex.mu.RUnlock()
+
+	var res [][]byte
+	for _, k := range keys {
+		v, err := s.getVal(k)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (s *Snapshot) getVal(key []byte) ([]byte, error) {
+	ix := s.str(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	v := ix.idx.Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	return s.value(Str, v.(*Index))
+}
+
+func (s *Snapshot) LRange(key []byte, start, stop int) ([][]byte, error) {
+	if err := s.check(key); err != nil {
+		return nil, err
+	}
+	s.db.listIndex.mu.RLock()
+	defer s.db.listIndex.mu.RUnlock()
+
+	ix := s.list(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	var res [][]byte
+	for _, el := range ix.idx.Range(string(key), start, stop) {
+		v, err := s.value(List, el.Value.(*Index))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (s *Snapshot) LLen(key []byte) int {
+	s.db.listIndex.mu.RLock()
+	defer s.db.listIndex.mu.RUnlock()
+	ix := s.list(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return 0
+	}
+	return ix.idx.Len(string(key))
+}
+
+func (s *Snapshot) HGet(key, k []byte) ([]byte, error) {
+	if err := s.check(key, k); err != nil {
+		return nil, err
+	}
+	s.db.hashIndex.mu.RLock()
+	defer s.db.hashIndex.mu.RUnlock()
+
+	ix := s.hash(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	return ix.idx.Get(string(key), string(k)), nil
+}
+
+func (s *Snapshot) HGetAll(key []byte) ([][]byte, error) {
+	if err := s.check(key); err != nil {
+		return nil, err
+	}
+	s.db.hashIndex.mu.RLock()
+	defer s.db.hashIndex.mu.RUnlock()
+
+	ix := s.hash(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	return ix.idx.GetAll(string(key)), nil
+}
+
+func (s *Snapshot) HLen(key []byte) int {
+	s.db.hashIndex.mu.RLock()
+	defer s.db.hashIndex.mu.RUnlock()
+	ix := s.hash(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return 0
+	}
+	return ix.idx.Len(string(key))
+}
+
+func (s *Snapshot) SMembers(key []byte) ([][]byte, error) {
+	if err := s.check(key); err != nil {
+		return nil, err
+	}
+	s.db.setIndex.mu.RLock()
+	defer s.db.setIndex.mu.RUnlock()
+
+	ix := s.set(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	return ix.idx.Scan(string(key)), nil
+}
+
+func (s *Snapshot) SIsMember(key, value []byte) bool {
+	s.db.setIndex.mu.RLock()
+	defer s.db.setIndex.mu.RUnlock()
+	ix := s.set(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return false
+	}
+	return ix.idx.ValExist(string(key), string(value))
+}
+
+func (s *Snapshot) SCard(key []byte) int {
+	s.db.setIndex.mu.RLock()
+	defer s.db.setIndex.mu.RUnlock()
+	ix := s.set(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return 0
+	}
+	return ix.idx.Len(string(key))
+}
+
+func (s *Snapshot) ZScore(key, member []byte) (bool, float64) {
+	s.db.zsetIndex.mu.RLock()
+	defer s.db.zsetIndex.mu.RUnlock()
+	ix := s.zset(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return false, 0
+	}
+	return ix.idx.GetScore(string(key), string(member))
+}
+
+func (s *Snapshot) ZScoreRange(key []byte, from, to float64) ([]interface{}, error) {
+	if err := s.check(key); err != nil {
+		return nil, err
+	}
+	s.db.zsetIndex.mu.RLock()
+	defer s.db.zsetIndex.mu.RUnlock()
+
+	ix := s.zset(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return nil, nil
+	}
+	return ix.idx.RangeByScore(string(key), from, to), nil
+}
+
+func (s *Snapshot) ZCard(key []byte) int {
+	s.db.zsetIndex.mu.RLock()
+	defer s.db.zsetIndex.mu.RUnlock()
+	ix := s.zset(key)
+	if ix.exp.expiredAt(string(key), s.at) {
+		return 0
+	}
+	return ix.idx.GetCard(string(key))
+}
+
+// all keys of dataType in the snapshot in order
+func (s *Snapshot) Keys(dataType uint16) [][]byte {
+	if dataType >= DataTypeNum {
+		return nil
+	}
+	mu := s.db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	// keys not changed are in db, changed keys are saved
+	var keys []string
+	live := s.db.expiresOf(dataType)
+	for _, k := range s.db.allKeys(dataType) {
+		if _, ok := s.saved[dataType][k]; !ok && !live.expiredAt(k, s.at) {
+			keys = append(keys, k)
+		}
+	}
+	old := s.old.expiresOf(dataType)
+	for _, k := range s.old.allKeys(dataType) {
+		if !old.expiredAt(k, s.at) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	res := make([][]byte, len(keys))
+	for i, k := range keys {
+		res[i] = []byte(k)
+	}
+	return res
+}
+
+func (si *snapIndexes) allKeys(dataType uint16) []string {
+	return keysOf(dataType, si.str.idx, si.list.idx, si.hash.idx, si.set.idx, si.zset.idx)
+}
+
+// all keys in the index of dataType, caller must hold its lock
+func (db *DB) allKeys(dataType uint16) []string {
+	return keysOf(dataType, db.strIndex.idx, db.listIndex.idx, db.hashIndex.idx, db.setIndex.idx, db.zsetIndex.idx)
+}
+
+func keysOf(dataType uint16, str *ds.AVLTree, list *ds.SeqList, hash *ds.Hash, set *ds.Set, zset *ds.SortedSet) []string {
+	switch dataType {
+	case Str:
+		var keys []string
+		for _, k := range str.GetAllKeys() {
+			keys = append(keys, string(k))
+		}
+		return keys
+	case List:
+		return list.GetAllKeys()
+	case Hash:
+		return hash.GetAllKeys()
+	case Set:
+		return set.GetAllKeys()
+	case ZSet:
+		return zset.GetAllKeys()
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Set([]byte("k2"), []byte("v2")))
+	assert.Nil(t, db.RPush([]byte("l"), []byte("a"), []byte("b")))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Nil(t, db.SAdd([]byte("s"), []byte("a")))
+	assert.Nil(t, db.ZAdd([]byte("z"), 1, []byte("a")))
+	assert.Nil(t, db.Expire([]byte("k2"), 500*time.Millisecond))
+
+	s, err := db.Snapshot()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// change everything after the snapshot
+	assert.Nil(t, db.Set([]byte("k1"), []byte("new")))
+	assert.Nil(t, db.Set([]byte("k3"), []byte("v3")))
+	_, err = db.LPop([]byte("l"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("new")))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("g"), []byte("new")))
+	_, err = db.Del([]byte("s"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.ZAdd([]byte("z"), 5, []byte("a")))
+	assert.Nil(t, db.ZAdd([]byte("z"), 2, []byte("b")))
+
+	vs, err := s.MGet([]byte("k1"), []byte("k2"), []byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2"), nil}, vs)
+	l, err := s.LRange([]byte("l"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, l)
+	kvs, err := s.HGetAll([]byte("h"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f"), []byte("v")}, kvs)
+	assert.True(t, s.SIsMember([]byte("s"), []byte("a")))
+	assert.Equal(t, 1, s.SCard([]byte("s")))
+	zs, err := s.ZScoreRange([]byte("z"), 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", float64(1)}, zs)
+	assert.Equal(t, [][]byte{[]byte("k1"), []byte("k2")}, s.Keys(Str))
+
+	// the db goes on
+	v, _ := db.Get([]byte("k1"))
+	assert.Equal(t, []byte("new"), v)
+	assert.Equal(t, 0, db.SCard([]byte("s")))
+
+	// keys expire by the time of snapshot
+	time.Sleep(600 * time.Millisecond)
+	_ = db.Exists([]byte("k2"))
+	v, err = s.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), v)
+
+	// later snapshot sees the changes
+	s2, err := db.Snapshot()
+	assert.Nil(t, err)
+	v, _ = s2.Get([]byte("k1"))
+	assert.Equal(t, []byte("new"), v)
+	assert.Nil(t, db.FlushDB())
+	v, _ = s2.Get([]byte("k1"))
+	assert.Equal(t, []byte("new"), v)
+	l, _ = s2.LRange([]byte("l"), 0, -1)
+	assert.Equal(t, [][]byte{[]byte("b")}, l)
+	v, _ = s.Get([]byte("k1"))
+	assert.Equal(t, []byte("v1"), v)
+	s2.Close()
+
+	_, err = s2.Get([]byte("k1"))
+	assert.Equal(t, ErrorSnapshotClosed, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+// gc keeps the entries that snapshots need
+func TestDB_Snapshot_GC(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 100
+	cfg.MergeCheckInterval = 0
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("old")))
+		assert.Nil(t, db.RPush([]byte("l"), []byte("old")))
+	}
+	s, err := db.Snapshot()
+	assert.Nil(t, err)
+
+	// old entries are garbage of db
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("new")))
+		_, err = db.LPop([]byte("l"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Remove([]byte("k0")))
+	assert.Nil(t, db.GC())
+
+	for i := 0; i < 10; i++ {
+		v, err := s.Get([]byte(fmt.Sprintf("k%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), v)
+	}
+	l, err := s.LRange([]byte("l"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(l))
+	assert.Equal(t, []byte("old"), l[9])
+
+	// kept entries do not come back
+	s.Close()
+	assert.Nil(t, db.GC())
+	assert.Nil(t, db.Close())
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+	v, err := db.Get([]byte("k0"))
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	v, err = db.Get([]byte("k1"))
+	assert.Equal(t, []byte("new"), v)
+	assert.Equal(t, 0, db.LLen([]byte("l")))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,1582 @@
+package server
+
+import (
+	"fmt"
+	"github.com/k-si/CaskDB"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Version = "7.0.0" // the redis version we pretend to be
+
+type command struct {
+	fn    func(c *client, args [][]byte)
+	arity int // include command name, negative means at least -arity
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		// connection
+		"ping":    {ping, -1},
+		"echo":    {echo, 2},
+		"select":  {selectDB, 2},
+		"quit":    {quit, 1},
+		"hello":   {hello, -1},
+		"info":    {info, -1},
+		"command": {commandCmd, -1},
+		"client":  {clientCmd, -2},
+
+		// key
+		"exists":    {exists, -2},
+		"type":      {typeCmd, 2},
+		"rename":    {rename, 3},
+		"renamenx":  {renameNx, 3},
+		"dbsize":    {dbSize, 1},
+		"flushdb":   {flushDB, -1},
+		"expire":    {expire, 3},
+		"pexpire":   {pexpire, 3},
+		"expireat":  {expireAt, 3},
+		"pexpireat": {pexpireAt, 3},
+		"ttl":       {ttl, 2},
+		"pttl":      {pttl, 2},
+		"persist":   {persist, 2},
+
+		// string
+		"set":    {set, -3},
+		"setnx":  {setNx, 3},
+		"setex":  {setEx, 4},
+		"psetex": {pSetEx, 4},
+		"get":    {get, 2},
+		"getset": {getSet, 3},
+		"mset":   {mSet, -3},
+		"msetnx": {mSetNx, -3},
+		"mget":   {mGet, -2},
+		"del":    {del, -2},
+
+		// list
+		"lpush":  {lPush, -3},
+		"rpush":  {rPush, -3},
+		"lpop":   {lPop, 2},
+		"rpop":   {rPop, 2},
+		"llen":   {lLen, 2},
+		"lindex": {lIndex, 3},
+		"lrange": {lRange, 4},
+		"lset":   {lSet, 4},
+		"lrem":   {lRem, 4},
+
+		// hash
+		"hset":         {hSet, -4},
+		"hmset":        {hMSet, -4},
+		"hsetnx":       {hSetNx, 4},
+		"hget":         {hGet, 3},
+		"hdel":         {hDel, -3},
+		"hgetall":      {hGetAll, 2},
+		"hkeys":        {hKeys, 2},
+		"hvals":        {hVals, 2},
+		"hexists":      {hExists, 3},
+		"hlen":         {hLen, 2},
+		"hmget":        {hMGet, -3},
+		"hincrby":      {hIncrBy, 4},
+		"hincrbyfloat": {hIncrByFloat, 4},
+		"hstrlen":      {hStrLen, 3},
+
+		// set
+		"sadd":        {sAdd, -3},
+		"srem":        {sRem, -3},
+		"smove":       {sMove, 4},
+		"smembers":    {sMembers, 2},
+		"sismember":   {sIsMember, 3},
+		"scard":       {sCard, 2},
+		"sunion":      {sUnion, -2},
+		"sdiff":       {sDiff, -2},
+		"sinter":      {sInter, -2},
+		"sintercard":  {sInterCard, -3},
+		"sunionstore": {sUnionStore, -3},
+		"sdiffstore":  {sDiffStore, -3},
+		"sinterstore": {sInterStore, -3},
+		"spop":        {sPop, -2},
+		"srandmember": {sRandMember, -2},
+		"smismember":  {sMIsMember, -3},
+
+		// sorted set
+		"zadd":             {zAdd, -4},
+		"zrem":             {zRem, -3},
+		"zscore":           {zScore, 3},
+		"zcard":            {zCard, 2},
+		"zrange":           {zRange, -4},
+		"zrevrange":        {zRevRange, -4},
+		"zrangebyscore":    {zRangeByScore, -4},
+		"zrevrangebyscore": {zRevRangeByScore, -4},
+		"zrangebylex":      {zRangeByLex, -4},
+		"zrank":            {zRank, 3},
+		"zrevrank":         {zRevRank, 3},
+		"zcount":           {zCount, 4},
+		"zincrby":          {zIncrBy, 4},
+		"zpopmin":          {zPopMin, -2},
+		"zpopmax":          {zPopMax, -2},
+		"zremrangebyscore": {zRemRangeByScore, 4},
+		"zremrangebyrank":  {zRemRangeByRank, 4},
+		"zunion":           {zUnion, -3},
+		"zinter":           {zInter, -3},
+		"zdiff":            {zDiff, -3},
+		"zunionstore":      {zUnionStore, -4},
+		"zinterstore":      {zInterStore, -4},
+		"zdiffstore":       {zDiffStore, -4},
+	}
+}
+
+var (
+	errNotInteger = "ERR value is not an integer or out of range"
+	errNotFloat   = "ERR value is not a valid float"
+	errSyntax     = "ERR syntax error"
+)
+
+func parseInt(b []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, err == nil
+}
+
+func parseFloat(b []byte) (float64, bool) {
+	switch strings.ToLower(string(b)) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func bool2int(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// connection
+
+func ping(c *client, args [][]byte) {
+	switch len(args) {
+	case 0:
+		c.w.WriteString("PONG")
+	case 1:
+		c.w.WriteBulk(args[0])
+	default:
+		c.w.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func echo(c *client, args [][]byte) {
+	c.w.WriteBulk(args[0])
+}
+
+func selectDB(c *client, args [][]byte) {
+	n, ok := parseInt(args[0])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	if n != 0 {
+		c.w.WriteError("ERR DB index is out of range")
+		return
+	}
+	c.db = int(n)
+	c.w.WriteString("OK")
+}
+
+func quit(c *client, args [][]byte) {
+	c.quit = true
+	c.w.WriteString("OK")
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(c *client, args [][]byte) {
+	proto := c.w.proto
+	if len(args) > 0 {
+		n, ok := parseInt(args[0])
+		if !ok {
+			c.w.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if n != 2 && n != 3 {
+			c.w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = int(n)
+	}
+	c.w.proto = proto
+
+	c.w.WriteMap(7)
+	c.w.WriteBulk([]byte("server"))
+	c.w.WriteBulk([]byte("caskdb"))
+	c.w.WriteBulk([]byte("version"))
+	c.w.WriteBulk([]byte(Version))
+	c.w.WriteBulk([]byte("proto"))
+	c.w.WriteInt(int64(proto))
+	c.w.WriteBulk([]byte("id"))
+	c.w.WriteInt(int64(c.s.connected))
+	c.w.WriteBulk([]byte("mode"))
+	c.w.WriteBulk([]byte("standalone"))
+	c.w.WriteBulk([]byte("role"))
+	c.w.WriteBulk([]byte("master"))
+	c.w.WriteBulk([]byte("modules"))
+	c.w.WriteArray(0)
+}
+
+func info(c *client, args [][]byte) {
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(&b, "redis_version:%s\r\n", Version)
+	b.WriteString("redis_mode:standalone\r\n")
+	fmt.Fprintf(&b, "tcp_port:%s\r\n", port(c.s.Addr()))
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(c.s.startTime).Seconds()))
+	b.WriteString("\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", c.s.clients())
+	b.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", c.s.connected)
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", c.s.processed)
+	b.WriteString("\r\n# CaskDB\r\n")
+	fmt.Fprintf(&b, "str_keys:%d\r\n", c.s.db.StrLen())
+	c.w.WriteBulk([]byte(b.String()))
+}
+
+func port(addr fmt.Stringer) string {
+	if addr == nil {
+		return ""
+	}
+	s := addr.String()
+	return s[strings.LastIndex(s, ":")+1:]
+}
+
+// redis-cli asks for command docs when starting, nothing to tell
+func commandCmd(c *client, args [][]byte) {
+	if len(args) > 0 && strings.ToLower(string(args[0])) == "count" {
+		c.w.WriteInt(int64(len(commands)))
+		return
+	}
+	c.w.WriteArray(0)
+}
+
+// clients like to set their names and info, just accept them
+func clientCmd(c *client, args [][]byte) {
+	switch strings.ToLower(string(args[0])) {
+	case "getname":
+		c.w.WriteNull()
+	case "id":
+		c.w.WriteInt(int64(c.s.connected))
+	default:
+		c.w.WriteString("OK")
+	}
+}
+
+// key
+
+func exists(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.Exists(args...)))
+}
+
+var typeNames = []string{"string", "list", "hash", "set", "zset"}
+
+// keys of different types are apart in db, the first type is shown
+func typeCmd(c *client, args [][]byte) {
+	types := c.s.db.Type(args[0])
+	if len(types) == 0 {
+		c.w.WriteString("none")
+		return
+	}
+	c.w.WriteString(typeNames[types[0]])
+}
+
+func rename(c *client, args [][]byte) {
+	switch err := c.s.db.Rename(args[0], args[1]); err {
+	case nil:
+		c.w.WriteString("OK")
+	case CaskDB.ErrorKeyNotExist:
+		c.w.WriteError("ERR no such key")
+	default:
+		c.writeErr(err)
+	}
+}
+
+func renameNx(c *client, args [][]byte) {
+	ok, err := c.s.db.RenameNx(args[0], args[1])
+	switch {
+	case err == CaskDB.ErrorKeyNotExist:
+		c.w.WriteError("ERR no such key")
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.w.WriteInt(1)
+	default:
+		c.w.WriteInt(0)
+	}
+}
+
+func dbSize(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.DBSize()))
+}
+
+// ASYNC and SYNC are accepted, it is always done at once
+func flushDB(c *client, args [][]byte) {
+	if err := c.s.db.FlushDB(); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteString("OK")
+}
+
+func expireWith(c *client, key []byte, fn func() error) {
+	err := fn()
+	switch err {
+	case nil:
+		c.w.WriteInt(1)
+	case CaskDB.ErrorKeyNotExist:
+		c.w.WriteInt(0)
+	default:
+		c.writeErr(err)
+	}
+}
+
+func expire(c *client, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	expireWith(c, args[0], func() error {
+		return c.s.db.Expire(args[0], time.Duration(n)*time.Second)
+	})
+}
+
+func pexpire(c *client, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	expireWith(c, args[0], func() error {
+		return c.s.db.Expire(args[0], time.Duration(n)*time.Millisecond)
+	})
+}
+
+func expireAt(c *client, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	expireWith(c, args[0], func() error {
+		return c.s.db.ExpireAt(args[0], time.Unix(n, 0))
+	})
+}
+
+func pexpireAt(c *client, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	expireWith(c, args[0], func() error {
+		return c.s.db.ExpireAt(args[0], time.Unix(0, n*int64(time.Millisecond)))
+	})
+}
+
+func ttlWith(c *client, key []byte, unit time.Duration) {
+	d, err := c.s.db.TTL(key)
+	switch {
+	case err == CaskDB.ErrorKeyNotExist:
+		c.w.WriteInt(-2)
+	case err != nil:
+		c.writeErr(err)
+	case d < 0:
+		c.w.WriteInt(-1)
+	default:
+		// round up like redis
+		c.w.WriteInt(int64((d + unit - 1) / unit))
+	}
+}
+
+func ttl(c *client, args [][]byte) {
+	ttlWith(c, args[0], time.Second)
+}
+
+func pttl(c *client, args [][]byte) {
+	ttlWith(c, args[0], time.Millisecond)
+}
+
+func persist(c *client, args [][]byte) {
+	d, err := c.s.db.TTL(args[0])
+	if err != nil || d < 0 {
+		c.w.WriteInt(0)
+		return
+	}
+	if err = c.s.db.Persist(args[0]); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(1)
+}
+
+// string
+
+// SET key value [NX|XX] [EX seconds|PX milliseconds]
+func set(c *client, args [][]byte) {
+	key, value := args[0], args[1]
+	var nx, xx bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				c.w.WriteError(errSyntax)
+				return
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok || n <= 0 {
+				c.w.WriteError("ERR invalid expire time in 'set' command")
+				return
+			}
+			if strings.ToLower(string(args[i])) == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		default:
+			c.w.WriteError(errSyntax)
+			return
+		}
+	}
+	if nx && xx {
+		c.w.WriteError(errSyntax)
+		return
+	}
+
+	exist := c.s.db.StrKeyExist(key)
+	if (nx && exist) || (xx && !exist) {
+		c.w.WriteNull()
+		return
+	}
+
+	var err error
+	if ttl > 0 {
+		err = c.s.db.SetEx(key, value, ttl)
+	} else {
+		err = c.s.db.Set(key, value)
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteString("OK")
+}
+
+func setNx(c *client, args [][]byte) {
+	if c.s.db.StrKeyExist(args[0]) {
+		c.w.WriteInt(0)
+		return
+	}
+	if err := c.s.db.SetNx(args[0], args[1]); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(1)
+}
+
+func setExWith(c *client, args [][]byte, unit time.Duration) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	if n <= 0 {
+		c.w.WriteError("ERR invalid expire time in '" + map[time.Duration]string{time.Second: "setex", time.Millisecond: "psetex"}[unit] + "' command")
+		return
+	}
+	if err := c.s.db.SetEx(args[0], args[2], time.Duration(n)*unit); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteString("OK")
+}
+
+func setEx(c *client, args [][]byte) {
+	setExWith(c, args, time.Second)
+}
+
+func pSetEx(c *client, args [][]byte) {
+	setExWith(c, args, time.Millisecond)
+}
+
+func get(c *client, args [][]byte) {
+	v, err := c.s.db.Get(args[0])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func getSet(c *client, args [][]byte) {
+	v, err := c.s.db.GetSet(args[0], args[1])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func mSet(c *client, args [][]byte) {
+	if len(args)%2 != 0 {
+		c.w.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	if err := c.s.db.MSet(args...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteString("OK")
+}
+
+// set nothing if any key exists
+func mSetNx(c *client, args [][]byte) {
+	if len(args)%2 != 0 {
+		c.w.WriteError("ERR wrong number of arguments for 'msetnx' command")
+		return
+	}
+	for i := 0; i < len(args); i += 2 {
+		if c.s.db.StrKeyExist(args[i]) {
+			c.w.WriteInt(0)
+			return
+		}
+	}
+	if err := c.s.db.MSet(args...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(1)
+}
+
+func mGet(c *client, args [][]byte) {
+	vs, err := c.s.db.MGet(args...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulks(vs)
+}
+
+func del(c *client, args [][]byte) {
+	n, err := c.s.db.Del(args...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(n))
+}
+
+// list
+
+func push(c *client, args [][]byte, left bool) {
+	var err error
+	if left {
+		err = c.s.db.LPush(args[0], args[1:]...)
+	} else {
+		err = c.s.db.RPush(args[0], args[1:]...)
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(c.s.db.LLen(args[0])))
+}
+
+func lPush(c *client, args [][]byte) {
+	push(c, args, true)
+}
+
+func rPush(c *client, args [][]byte) {
+	push(c, args, false)
+}
+
+func pop(c *client, args [][]byte, left bool) {
+	if c.s.db.LLen(args[0]) == 0 {
+		c.w.WriteNull()
+		return
+	}
+	var v []byte
+	var err error
+	if left {
+		v, err = c.s.db.LPop(args[0])
+	} else {
+		v, err = c.s.db.RPop(args[0])
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func lPop(c *client, args [][]byte) {
+	pop(c, args, true)
+}
+
+func rPop(c *client, args [][]byte) {
+	pop(c, args, false)
+}
+
+func lLen(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.LLen(args[0])))
+}
+
+// turn a negative index to positive, false if out of range
+func listIndex(c *client, key, arg []byte) (int, bool) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return 0, false
+	}
+	l := int64(c.s.db.LLen(key))
+	if n < 0 {
+		n += l
+	}
+	if n < 0 || n >= l {
+		return 0, false
+	}
+	return int(n), true
+}
+
+func lIndex(c *client, args [][]byte) {
+	if _, ok := parseInt(args[1]); !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	n, ok := listIndex(c, args[0], args[1])
+	if !ok {
+		c.w.WriteNull()
+		return
+	}
+	v, err := c.s.db.LIndex(args[0], n)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func lRange(c *client, args [][]byte) {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	vs, err := c.s.db.LRange(args[0], int(start), int(stop))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulks(vs)
+}
+
+func lSet(c *client, args [][]byte) {
+	if _, ok := parseInt(args[1]); !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	if c.s.db.LLen(args[0]) == 0 {
+		c.w.WriteError("ERR no such key")
+		return
+	}
+	n, ok := listIndex(c, args[0], args[1])
+	if !ok {
+		c.w.WriteError("ERR index out of range")
+		return
+	}
+	if err := c.s.db.LSet(args[0], args[2], n); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteString("OK")
+}
+
+func lRem(c *client, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	before := c.s.db.LLen(args[0])
+	if before == 0 {
+		c.w.WriteInt(0)
+		return
+	}
+	if err := c.s.db.LRem(args[0], args[2], int(n)); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(before - c.s.db.LLen(args[0])))
+}
+
+// hash
+
+// set field value pairs, return the number of new fields
+func hSetPairs(c *client, args [][]byte) (int64, bool) {
+	if len(args)%2 != 1 {
+		c.w.WriteError("ERR wrong number of arguments for 'hset' command")
+		return 0, false
+	}
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if !c.s.db.HExist(args[0], args[i]) {
+			n++
+		}
+	}
+	if err := c.s.db.HMSet(args[0], args[1:]...); err != nil {
+		c.writeErr(err)
+		return 0, false
+	}
+	return n, true
+}
+
+func hSet(c *client, args [][]byte) {
+	if n, ok := hSetPairs(c, args); ok {
+		c.w.WriteInt(n)
+	}
+}
+
+func hMSet(c *client, args [][]byte) {
+	if _, ok := hSetPairs(c, args); ok {
+		c.w.WriteString("OK")
+	}
+}
+
+func hSetNx(c *client, args [][]byte) {
+	if c.s.db.HExist(args[0], args[1]) {
+		c.w.WriteInt(0)
+		return
+	}
+	if err := c.s.db.HSetNx(args[0], args[1], args[2]); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(1)
+}
+
+func hGet(c *client, args [][]byte) {
+	v, err := c.s.db.HGet(args[0], args[1])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func hDel(c *client, args [][]byte) {
+	var n int64
+	for _, f := range args[1:] {
+		n += bool2int(c.s.db.HExist(args[0], f))
+	}
+	if err := c.s.db.HDel(args[0], args[1:]...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(n)
+}
+
+// HMGET key field [field ...]
+func hMGet(c *client, args [][]byte) {
+	res, err := c.s.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteArray(len(res))
+	for _, v := range res {
+		c.w.WriteBulk(v)
+	}
+}
+
+func hGetAll(c *client, args [][]byte) {
+	res, err := c.s.db.HGetAll(args[0])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteMap(len(res) / 2)
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+func hKeys(c *client, args [][]byte) {
+	writeBulks(c, c.s.db.HKeys, args[0])
+}
+
+func hVals(c *client, args [][]byte) {
+	writeBulks(c, c.s.db.HVals, args[0])
+}
+
+func writeBulks(c *client, get func(key []byte) ([][]byte, error), key []byte) {
+	res, err := get(key)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteArray(len(res))
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+// HINCRBY key field increment
+func hIncrBy(c *client, args [][]byte) {
+	incr, ok := parseInt(args[2])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	n, err := c.s.db.HIncrBy(args[0], args[1], incr)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(n)
+}
+
+// HINCRBYFLOAT key field increment, the new value is a bulk string
+func hIncrByFloat(c *client, args [][]byte) {
+	incr, ok := parseFloat(args[2])
+	if !ok {
+		c.w.WriteError(errNotFloat)
+		return
+	}
+	f, err := c.s.db.HIncrByFloat(args[0], args[1], incr)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulk([]byte(strconv.FormatFloat(f, 'f', -1, 64)))
+}
+
+func hStrLen(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.HStrLen(args[0], args[1])))
+}
+
+func hExists(c *client, args [][]byte) {
+	c.w.WriteInt(bool2int(c.s.db.HExist(args[0], args[1])))
+}
+
+func hLen(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.HLen(args[0])))
+}
+
+// set
+
+func sAdd(c *client, args [][]byte) {
+	var n int64
+	for _, m := range args[1:] {
+		if !c.s.db.SIsMember(args[0], m) {
+			n++
+		}
+	}
+	if err := c.s.db.SAdd(args[0], args[1:]...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(n)
+}
+
+func sRem(c *client, args [][]byte) {
+	var n int64
+	for _, b := range c.s.db.SMIsMember(args[0], args[1:]...) {
+		n += bool2int(b)
+	}
+	if err := c.s.db.SRem(args[0], args[1:]...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(n)
+}
+
+func sMove(c *client, args [][]byte) {
+	if !c.s.db.SIsMember(args[0], args[2]) {
+		c.w.WriteInt(0)
+		return
+	}
+	if err := c.s.db.SMove(args[0], args[1], args[2]); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(1)
+}
+
+func sMembers(c *client, args [][]byte) {
+	res, err := c.s.db.SMembers(args[0])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteSet(len(res))
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+func sIsMember(c *client, args [][]byte) {
+	c.w.WriteInt(bool2int(c.s.db.SIsMember(args[0], args[1])))
+}
+
+func sCard(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.SCard(args[0])))
+}
+
+func sUnion(c *client, args [][]byte) {
+	res, err := c.s.db.SUnion(args...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteSet(len(res))
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+// members of the first set which are not in other sets,
+// db should be opened with Config.RedisSDiff
+func sDiff(c *client, args [][]byte) {
+	writeMembers(c, c.s.db.SDiff, args)
+}
+
+func sInter(c *client, args [][]byte) {
+	writeMembers(c, c.s.db.SInter, args)
+}
+
+func writeMembers(c *client, combine func(keys ...[]byte) ([][]byte, error), keys [][]byte) {
+	res, err := combine(keys...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteSet(len(res))
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sInterCard(c *client, args [][]byte) {
+	n, ok := parseInt(args[0])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	if n <= 0 {
+		c.w.WriteError("ERR numkeys should be greater than 0")
+		return
+	}
+	if n > int64(len(args)-1) {
+		c.w.WriteError(errSyntax)
+		return
+	}
+	keys, rest := args[1:n+1], args[n+1:]
+
+	limit := int64(0)
+	switch {
+	case len(rest) == 2 && strings.ToLower(string(rest[0])) == "limit":
+		if limit, ok = parseInt(rest[1]); !ok || limit < 0 {
+			c.w.WriteError("ERR LIMIT can't be negative")
+			return
+		}
+	case len(rest) != 0:
+		c.w.WriteError(errSyntax)
+		return
+	}
+
+	card, err := c.s.db.SInterCard(int(limit), keys...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(card))
+}
+
+// SUNIONSTORE destination key [key ...]
+func sUnionStore(c *client, args [][]byte) {
+	sStore(c, c.s.db.SUnionStore, args)
+}
+
+// SDIFFSTORE destination key [key ...]
+func sDiffStore(c *client, args [][]byte) {
+	sStore(c, c.s.db.SDiffStore, args)
+}
+
+// SINTERSTORE destination key [key ...]
+func sInterStore(c *client, args [][]byte) {
+	sStore(c, c.s.db.SInterStore, args)
+}
+
+func sStore(c *client, store func(dst []byte, keys ...[]byte) (int, error), args [][]byte) {
+	n, err := store(args[0], args[1:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(n))
+}
+
+// SPOP key [count]
+func sPop(c *client, args [][]byte) {
+	if len(args) == 2 {
+		if count, ok := parseInt(args[1]); ok && count < 0 {
+			c.w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+	sRandom(c, args, c.s.db.SPop)
+}
+
+// SRANDMEMBER key [count]
+func sRandMember(c *client, args [][]byte) {
+	sRandom(c, args, c.s.db.SRandMember)
+}
+
+// a member or null without count, an array with count
+func sRandom(c *client, args [][]byte, random func(key []byte, count int) ([][]byte, error)) {
+	if len(args) > 2 {
+		c.w.WriteError(errSyntax)
+		return
+	}
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok {
+			c.w.WriteError(errNotInteger)
+			return
+		}
+	}
+
+	res, err := random(args[0], int(count))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	if len(args) == 1 {
+		if len(res) == 0 {
+			c.w.WriteNull()
+			return
+		}
+		c.w.WriteBulk(res[0])
+		return
+	}
+	c.w.WriteArray(len(res))
+	for _, b := range res {
+		c.w.WriteBulk(b)
+	}
+}
+
+// SMISMEMBER key member [member ...]
+func sMIsMember(c *client, args [][]byte) {
+	res := c.s.db.SMIsMember(args[0], args[1:]...)
+	c.w.WriteArray(len(res))
+	for _, b := range res {
+		c.w.WriteInt(bool2int(b))
+	}
+}
+
+// sorted set
+
+// ZADD key score member [score member ...]
+func zAdd(c *client, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.WriteError(errSyntax)
+		return
+	}
+	scores := make([]float64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		f, ok := parseFloat(args[i])
+		if !ok {
+			c.w.WriteError(errNotFloat)
+			return
+		}
+		scores = append(scores, f)
+	}
+
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if !c.s.db.ZIsMember(args[0], args[i+1]) {
+			n++
+		}
+		if err := c.s.db.ZAdd(args[0], scores[i/2], args[i+1]); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+	c.w.WriteInt(n)
+}
+
+func zRem(c *client, args [][]byte) {
+	var n int64
+	for _, m := range args[1:] {
+		if !c.s.db.ZIsMember(args[0], m) {
+			continue
+		}
+		if err := c.s.db.ZRem(args[0], m); err != nil {
+			c.writeErr(err)
+			return
+		}
+		n++
+	}
+	c.w.WriteInt(n)
+}
+
+func zScore(c *client, args [][]byte) {
+	ok, score := c.s.db.ZScore(args[0], args[1])
+	if !ok {
+		c.w.WriteNull()
+		return
+	}
+	c.w.WriteDouble(score)
+}
+
+func zCard(c *client, args [][]byte) {
+	c.w.WriteInt(int64(c.s.db.ZCard(args[0])))
+}
+
+// member and score are paired in res
+func writeScores(c *client, res []interface{}, withScores bool) {
+	if withScores && c.w.proto == 3 {
+		c.w.WriteArray(len(res) / 2)
+		for i := 0; i < len(res); i += 2 {
+			c.w.WriteArray(2)
+			c.w.WriteBulk([]byte(res[i].(string)))
+			c.w.WriteDouble(res[i+1].(float64))
+		}
+		return
+	}
+	if withScores {
+		c.w.WriteArray(len(res))
+	} else {
+		c.w.WriteArray(len(res) / 2)
+	}
+	for i := 0; i < len(res); i += 2 {
+		c.w.WriteBulk([]byte(res[i].(string)))
+		if withScores {
+			c.w.WriteDouble(res[i+1].(float64))
+		}
+	}
+}
+
+func zRank(c *client, args [][]byte) {
+	writeRank(c, args, c.s.db.ZRank)
+}
+
+func zRevRank(c *client, args [][]byte) {
+	writeRank(c, args, c.s.db.ZRevRank)
+}
+
+func writeRank(c *client, args [][]byte, rank func(key, member []byte) (bool, int)) {
+	ok, r := rank(args[0], args[1])
+	if !ok {
+		c.w.WriteNull()
+		return
+	}
+	c.w.WriteInt(int64(r))
+}
+
+// ZRANGE key start stop [WITHSCORES]
+func zRange(c *client, args [][]byte) {
+	zRangeByRank(c, args, c.s.db.ZRange)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func zRevRange(c *client, args [][]byte) {
+	zRangeByRank(c, args, c.s.db.ZRevRange)
+}
+
+func zRangeByRank(c *client, args [][]byte, rangeFn func(key []byte, start, stop int) ([]interface{}, error)) {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	withScores := false
+	for _, a := range args[3:] {
+		if strings.ToLower(string(a)) != "withscores" {
+			c.w.WriteError(errSyntax)
+			return
+		}
+		withScores = true
+	}
+
+	res, err := rangeFn(args[0], int(start), int(stop))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	writeScores(c, res, withScores)
+}
+
+// score bound, "(" means exclusive
+func parseScoreBound(b []byte) (float64, bool, bool) {
+	if len(b) > 0 && b[0] == '(' {
+		f, ok := parseFloat(b[1:])
+		return f, true, ok
+	}
+	f, ok := parseFloat(b)
+	return f, false, ok
+}
+
+func parseScoreRange(c *client, min, max []byte) (CaskDB.ScoreRange, bool) {
+	var r CaskDB.ScoreRange
+	var ok1, ok2 bool
+	r.Min, r.MinEx, ok1 = parseScoreBound(min)
+	r.Max, r.MaxEx, ok2 = parseScoreBound(max)
+	if !ok1 || !ok2 {
+		c.w.WriteError("ERR min or max is not a float")
+		return r, false
+	}
+	return r, true
+}
+
+// [WITHSCORES] [LIMIT offset count], withScores is not allowed if withScores is nil
+func parseRangeOpts(c *client, args [][]byte, withScores *bool) (int, int, bool) {
+	offset, count := int64(0), int64(-1)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withscores":
+			if withScores == nil {
+				c.w.WriteError(errSyntax)
+				return 0, 0, false
+			}
+			*withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				c.w.WriteError(errSyntax)
+				return 0, 0, false
+			}
+			var ok bool
+			if offset, ok = parseInt(args[i+1]); !ok {
+				c.w.WriteError(errNotInteger)
+				return 0, 0, false
+			}
+			if count, ok = parseInt(args[i+2]); !ok {
+				c.w.WriteError(errNotInteger)
+				return 0, 0, false
+			}
+			i += 2
+		default:
+			c.w.WriteError(errSyntax)
+			return 0, 0, false
+		}
+	}
+	return int(offset), int(count), true
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func zRangeByScore(c *client, args [][]byte) {
+	r, ok := parseScoreRange(c, args[1], args[2])
+	if !ok {
+		return
+	}
+	zRangeByScoreRange(c, args, r, c.s.db.ZRangeByScore)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func zRevRangeByScore(c *client, args [][]byte) {
+	r, ok := parseScoreRange(c, args[2], args[1])
+	if !ok {
+		return
+	}
+	zRangeByScoreRange(c, args, r, c.s.db.ZRevRangeByScore)
+}
+
+func zRangeByScoreRange(c *client, args [][]byte, r CaskDB.ScoreRange, rangeFn func(key []byte, r CaskDB.ScoreRange, offset, count int) ([]interface{}, error)) {
+	withScores := false
+	offset, count, ok := parseRangeOpts(c, args[3:], &withScores)
+	if !ok {
+		return
+	}
+	res, err := rangeFn(args[0], r, offset, count)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	writeScores(c, res, withScores)
+}
+
+func zCount(c *client, args [][]byte) {
+	r, ok := parseScoreRange(c, args[1], args[2])
+	if !ok {
+		return
+	}
+	c.w.WriteInt(int64(c.s.db.ZCount(args[0], r)))
+}
+
+func zIncrBy(c *client, args [][]byte) {
+	incr, ok := parseFloat(args[1])
+	if !ok {
+		c.w.WriteError(errNotFloat)
+		return
+	}
+	score, err := c.s.db.ZIncrBy(args[0], incr, args[2])
+	if err == CaskDB.ErrorScoreNaN {
+		c.w.WriteError("ERR resulting score is not a number (NaN)")
+		return
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteDouble(score)
+}
+
+// lex bound, "[" means inclusive, "(" means exclusive, "-" and "+" mean unbounded
+func parseLexBound(b []byte) (s string, ex, inf, ok bool) {
+	if len(b) == 0 {
+		return "", false, false, false
+	}
+	switch b[0] {
+	case '-', '+':
+		return "", false, true, len(b) == 1
+	case '[':
+		return string(b[1:]), false, false, true
+	case '(':
+		return string(b[1:]), true, false, true
+	}
+	return "", false, false, false
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func zRangeByLex(c *client, args [][]byte) {
+	var r CaskDB.LexRange
+	var ok1, ok2 bool
+	r.Min, r.MinEx, r.NoMin, ok1 = parseLexBound(args[1])
+	r.Max, r.MaxEx, r.NoMax, ok2 = parseLexBound(args[2])
+	if !ok1 || !ok2 {
+		c.w.WriteError("ERR min or max not valid string range item")
+		return
+	}
+
+	// "+" as min or "-" as max is empty
+	if (r.NoMin && args[1][0] == '+') || (r.NoMax && args[2][0] == '-') {
+		c.w.WriteArray(0)
+		return
+	}
+	offset, count, ok := parseRangeOpts(c, args[3:], nil)
+	if !ok {
+		return
+	}
+	res, err := c.s.db.ZRangeByLex(args[0], r, offset, count)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteBulks(res)
+}
+
+// ZPOPMIN key [count]
+func zPopMin(c *client, args [][]byte) {
+	zPop(c, args, c.s.db.ZPopMin)
+}
+
+// ZPOPMAX key [count]
+func zPopMax(c *client, args [][]byte) {
+	zPop(c, args, c.s.db.ZPopMax)
+}
+
+func zPop(c *client, args [][]byte, pop func(key []byte, count int) ([]interface{}, error)) {
+	count := int64(1)
+	if len(args) > 2 {
+		c.w.WriteError(errSyntax)
+		return
+	}
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			c.w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+	res, err := pop(args[0], int(count))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	writeScores(c, res, true)
+}
+
+func zRemRangeByScore(c *client, args [][]byte) {
+	r, ok := parseScoreRange(c, args[1], args[2])
+	if !ok {
+		return
+	}
+	n, err := c.s.db.ZRemRangeByScore(args[0], r)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(n))
+}
+
+func zRemRangeByRank(c *client, args [][]byte) {
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		c.w.WriteError(errNotInteger)
+		return
+	}
+	n, err := c.s.db.ZRemRangeByRank(args[0], int(start), int(stop))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(n))
+}
+
+// options of combining sorted sets
+type combineOpts struct {
+	keys       [][]byte
+	weights    []float64
+	agg        CaskDB.Aggregate
+	withScores bool
+}
+
+// numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES],
+// options not allowed are syntax errors
+func parseCombine(c *client, args [][]byte, weights, withScores bool) (*combineOpts, bool) {
+	n, ok := parseInt(args[0])
+	if !ok {
+		c.w.WriteError(errNotInteger)
+		return nil, false
+	}
+	if n <= 0 {
+		c.w.WriteError("ERR at least 1 input key is needed")
+		return nil, false
+	}
+	if n > int64(len(args)-1) {
+		c.w.WriteError(errSyntax)
+		return nil, false
+	}
+	opts := &combineOpts{keys: args[1 : n+1], agg: CaskDB.AggregateSum}
+
+	for i := int(n) + 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "weights":
+			if !weights || i+int(n) >= len(args) {
+				c.w.WriteError(errSyntax)
+				return nil, false
+			}
+			opts.weights = make([]float64, n)
+			for j := range opts.weights {
+				if opts.weights[j], ok = parseFloat(args[i+1+j]); !ok {
+					c.w.WriteError("ERR weight value is not a float")
+					return nil, false
+				}
+			}
+			i += int(n)
+		case "aggregate":
+			if !weights || i+1 >= len(args) {
+				c.w.WriteError(errSyntax)
+				return nil, false
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "sum":
+				opts.agg = CaskDB.AggregateSum
+			case "min":
+				opts.agg = CaskDB.AggregateMin
+			case "max":
+				opts.agg = CaskDB.AggregateMax
+			default:
+				c.w.WriteError(errSyntax)
+				return nil, false
+			}
+			i++
+		case "withscores":
+			if !withScores {
+				c.w.WriteError(errSyntax)
+				return nil, false
+			}
+			opts.withScores = true
+		default:
+			c.w.WriteError(errSyntax)
+			return nil, false
+		}
+	}
+	return opts, true
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zUnion(c *client, args [][]byte) {
+	zCombine(c, args, true, c.s.db.ZUnion)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zInter(c *client, args [][]byte) {
+	zCombine(c, args, true, c.s.db.ZInter)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func zDiff(c *client, args [][]byte) {
+	zCombine(c, args, false, func(keys [][]byte, _ []float64, _ CaskDB.Aggregate) ([]interface{}, error) {
+		return c.s.db.ZDiff(keys...)
+	})
+}
+
+func zCombine(c *client, args [][]byte, weights bool, combine func([][]byte, []float64, CaskDB.Aggregate) ([]interface{}, error)) {
+	opts, ok := parseCombine(c, args, weights, true)
+	if !ok {
+		return
+	}
+	res, err := combine(opts.keys, opts.weights, opts.agg)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	writeScores(c, res, opts.withScores)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zUnionStore(c *client, args [][]byte) {
+	zStore(c, args, true, c.s.db.ZUnionStore)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zInterStore(c *client, args [][]byte) {
+	zStore(c, args, true, c.s.db.ZInterStore)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func zDiffStore(c *client, args [][]byte) {
+	zStore(c, args, false, func(dst []byte, keys [][]byte, _ []float64, _ CaskDB.Aggregate) (int, error) {
+		return c.s.db.ZDiffStore(dst, keys...)
+	})
+}
+
+func zStore(c *client, args [][]byte, weights bool, store func([]byte, [][]byte, []float64, CaskDB.Aggregate) (int, error)) {
+	opts, ok := parseCombine(c, args[1:], weights, false)
+	if !ok {
+		return
+	}
+	n, err := store(args[0], opts.keys, opts.weights, opts.agg)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.WriteInt(int64(n))
+}
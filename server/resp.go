@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorProtocol   = errors.New("[protocol error]")
+	ErrorBulkLength = errors.New("[invalid bulk length]")
+)
+
+const (
+	MaxBulkSize  = 512 * 1024 * 1024 // same as redis
+	MaxArraySize = 1024 * 1024
+)
+
+// read commands from client, both multi bulk and inline commands are supported
+type Reader struct {
+	rd *bufio.Reader
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(rd)}
+}
+
+// whether there are commands waiting in buffer, used by pipelining
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	n := len(line) - 1
+	if n > 0 && line[n-1] == '\r' {
+		n--
+	}
+	return line[:n], nil
+}
+
+// read a command, return the arguments
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		// inline command, such as telnet
+		if line[0] != '*' {
+			var args [][]byte
+			for _, f := range strings.Fields(string(line)) {
+				args = append(args, []byte(f))
+			}
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MaxArraySize {
+			return nil, ErrorProtocol
+		}
+		if n <= 0 {
+			continue
+		}
+
+		args := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			if args[i], err = r.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+}
+
+func (r *Reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, ErrorProtocol
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > MaxBulkSize {
+		return nil, ErrorBulkLength
+	}
+
+	// bulk ends with \r\n
+	buf := make([]byte, n+2)
+	if _, err = io.ReadFull(r.rd, buf); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// write replies, proto decides how to write null, map and double
+type Writer struct {
+	wr    *bufio.Writer
+	proto int // 2 or 3
+}
+
+func NewWriter(wr io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(wr), proto: 2}
+}
+
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
+
+func (w *Writer) writeHead(prefix byte, n int) {
+	w.wr.WriteByte(prefix)
+	w.wr.WriteString(strconv.Itoa(n))
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteString(s string) {
+	w.wr.WriteByte('+')
+	w.wr.WriteString(s)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteError(s string) {
+	w.wr.WriteByte('-')
+	w.wr.WriteString(s)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteInt(n int64) {
+	w.wr.WriteByte(':')
+	w.wr.WriteString(strconv.FormatInt(n, 10))
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteBulk(b []byte) {
+	if b == nil {
+		w.WriteNull()
+		return
+	}
+	w.writeHead('$', len(b))
+	w.wr.Write(b)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *Writer) WriteNull() {
+	if w.proto == 3 {
+		w.wr.WriteString("_\r\n")
+		return
+	}
+	w.wr.WriteString("$-1\r\n")
+}
+
+func (w *Writer) WriteArray(n int) {
+	w.writeHead('*', n)
+}
+
+func (w *Writer) WriteNullArray() {
+	if w.proto == 3 {
+		w.wr.WriteString("_\r\n")
+		return
+	}
+	w.wr.WriteString("*-1\r\n")
+}
+
+// map is a flat array in RESP2
+func (w *Writer) WriteMap(n int) {
+	if w.proto == 3 {
+		w.writeHead('%', n)
+		return
+	}
+	w.writeHead('*', n*2)
+}
+
+// set is an array in RESP2
+func (w *Writer) WriteSet(n int) {
+	if w.proto == 3 {
+		w.writeHead('~', n)
+		return
+	}
+	w.writeHead('*', n)
+}
+
+// double is a bulk string in RESP2
+func (w *Writer) WriteDouble(f float64) {
+	s := formatFloat(f)
+	if w.proto == 3 {
+		w.wr.WriteByte(',')
+		w.wr.WriteString(s)
+		w.wr.WriteString("\r\n")
+		return
+	}
+	w.WriteBulk([]byte(s))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (w *Writer) WriteBulks(bs [][]byte) {
+	w.WriteArray(len(bs))
+	for _, b := range bs {
+		w.WriteBulk(b)
+	}
+}
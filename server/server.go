@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"github.com/k-si/CaskDB"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrorServerClosed = errors.New("[server closed]")
+
+// redis protocol server, commands are mapped to the methods of *CaskDB.DB
+type Server struct {
+	db        *CaskDB.DB
+	mu        sync.Mutex
+	ln        net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	isClosed  uint32
+	startTime time.Time
+
+	connected uint64 // number of connections ever accepted
+	processed uint64 // number of commands ever processed
+}
+
+func New(db *CaskDB.DB) *Server {
+	return &Server{
+		db:        db,
+		conns:     make(map[net.Conn]struct{}),
+		startTime: time.Now(),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// accept connections until Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if atomic.LoadUint32(&s.isClosed) == 1 {
+		s.mu.Unlock()
+		return ErrorServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if atomic.LoadUint32(&s.isClosed) == 1 {
+				return ErrorServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		atomic.AddUint64(&s.connected, 1)
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// stop listening, close all connections and wait for them,
+// the DB is not closed here
+func (s *Server) Close() error {
+	if !atomic.CompareAndSwapUint32(&s.isClosed, 0, 1) {
+		return ErrorServerClosed
+	}
+
+	s.mu.Lock()
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Addr is the address server is listening on
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+func (s *Server) clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// state of a connection
+type client struct {
+	s    *Server
+	conn net.Conn
+	r    *Reader
+	w    *Writer
+	db   int // selected db, only 0 is supported
+	quit bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	c := &client{
+		s:    s,
+		conn: conn,
+		r:    NewReader(conn),
+		w:    NewWriter(conn),
+	}
+
+	for !c.quit {
+		args, err := c.r.ReadCommand()
+		if err != nil {
+			if err != io.EOF && atomic.LoadUint32(&s.isClosed) == 0 {
+				if err == ErrorProtocol || err == ErrorBulkLength {
+					c.w.WriteError("ERR " + errorMessage(err))
+					c.w.Flush()
+				}
+				log.Println("[read command err]", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		c.exec(args)
+		atomic.AddUint64(&s.processed, 1)
+
+		// pipelining, flush only when all commands in buffer are done
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *client) exec(args [][]byte) {
+	if len(args) == 0 {
+		return
+	}
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.WriteError("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+
+	// arity > 0 means exactly, arity < 0 means at least
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	cmd.fn(c, args[1:])
+}
+
+// errors of CaskDB look like "[message]"
+func errorMessage(err error) string {
+	return strings.Trim(err.Error(), "[]")
+}
+
+func (c *client) writeErr(err error) {
+	c.w.WriteError("ERR " + errorMessage(err))
+}
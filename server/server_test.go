@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"github.com/k-si/CaskDB"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+const testDir = "/tmp/CaskDB-server"
+
+func startServer(t *testing.T) (*Server, *CaskDB.DB, net.Conn) {
+	os.RemoveAll(testDir)
+	cfg := CaskDB.DefaultConfig()
+	cfg.DBDir = testDir
+	cfg.RedisSDiff = true
+	db, err := CaskDB.Open(cfg)
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := New(db)
+	go s.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	return s, db, conn
+}
+
+func stopServer(t *testing.T, s *Server, db *CaskDB.DB) {
+	assert.Nil(t, s.Close())
+	assert.Nil(t, db.Close())
+}
+
+// send raw request, read exactly len(want) bytes of reply
+func roundTrip(t *testing.T, conn net.Conn, rd *bufio.Reader, req, want string) {
+	_, err := conn.Write([]byte(req))
+	assert.Nil(t, err)
+	buf := make([]byte, len(want))
+	_, err = io.ReadFull(rd, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, want, string(buf))
+}
+
+func TestServer_Commands(t *testing.T) {
+	s, db, conn := startServer(t)
+	rd := bufio.NewReader(conn)
+
+	roundTrip(t, conn, rd, "PING\r\n", "+PONG\r\n")
+	roundTrip(t, conn, rd, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n", "+OK\r\n")
+	roundTrip(t, conn, rd, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$1\r\nv\r\n")
+	roundTrip(t, conn, rd, "GET nokey\r\n", "$-1\r\n")
+	roundTrip(t, conn, rd, "SET k v2 NX\r\n", "$-1\r\n")
+	roundTrip(t, conn, rd, "DEL k nokey\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "SELECT 0\r\n", "+OK\r\n")
+	roundTrip(t, conn, rd, "SELECT 1\r\n", "-ERR DB index is out of range\r\n")
+
+	roundTrip(t, conn, rd, "RPUSH l a b c\r\n", ":3\r\n")
+	roundTrip(t, conn, rd, "LRANGE l 0 -1\r\n", "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	roundTrip(t, conn, rd, "LINDEX l -1\r\n", "$1\r\nc\r\n")
+	roundTrip(t, conn, rd, "LINDEX l 3\r\n", "$-1\r\n")
+
+	roundTrip(t, conn, rd, "HSET h f1 v1 f2 v2\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "HSET h f1 v3\r\n", ":0\r\n")
+	roundTrip(t, conn, rd, "HMGET h f1 f3 f2\r\n", "*3\r\n$2\r\nv3\r\n$-1\r\n$2\r\nv2\r\n")
+	roundTrip(t, conn, rd, "HSTRLEN h f2\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "HINCRBY h n 5\r\n", ":5\r\n")
+	roundTrip(t, conn, rd, "HINCRBY h f1 1\r\n", "-ERR hash value is not an integer\r\n")
+	roundTrip(t, conn, rd, "HINCRBY h n 9223372036854775807\r\n", "-ERR increment or decrement would overflow\r\n")
+	roundTrip(t, conn, rd, "HINCRBYFLOAT h n 0.5\r\n", "$3\r\n5.5\r\n")
+	roundTrip(t, conn, rd, "HKEYS h3\r\n", "*0\r\n")
+	roundTrip(t, conn, rd, "HDEL h n f3\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "HGET h f1\r\n", "$2\r\nv3\r\n")
+
+	roundTrip(t, conn, rd, "SADD s1 a b c\r\n", ":3\r\n")
+	roundTrip(t, conn, rd, "SADD s2 b c\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "SDIFF s1 s2\r\n", "*1\r\n$1\r\na\r\n")
+	roundTrip(t, conn, rd, "SINTERCARD 2 s1 s2 LIMIT 1\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "SMISMEMBER s1 a d\r\n", "*2\r\n:1\r\n:0\r\n")
+	roundTrip(t, conn, rd, "SDIFFSTORE s3 s1 s2\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "SINTERSTORE s3 s1 s2\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "SUNIONSTORE s3 s3 none\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "SREM s3 b x\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "SRANDMEMBER s3 -2\r\n", "*2\r\n$1\r\nc\r\n$1\r\nc\r\n")
+	roundTrip(t, conn, rd, "SPOP s3\r\n", "$1\r\nc\r\n")
+	roundTrip(t, conn, rd, "SPOP s3\r\n", "$-1\r\n")
+	roundTrip(t, conn, rd, "SPOP s3 -1\r\n", "-ERR value is out of range, must be positive\r\n")
+
+	roundTrip(t, conn, rd, "ZADD z 1 a 2 b 3 c\r\n", ":3\r\n")
+	roundTrip(t, conn, rd, "ZSCORE z b\r\n", "$1\r\n2\r\n")
+	roundTrip(t, conn, rd, "ZRANGEBYSCORE z (1 +inf WITHSCORES\r\n", "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	roundTrip(t, conn, rd, "ZREVRANGEBYSCORE z 3 (1 LIMIT 1 1\r\n", "*1\r\n$1\r\nb\r\n")
+	roundTrip(t, conn, rd, "ZRANK z c\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "ZREVRANK z nobody\r\n", "$-1\r\n")
+	roundTrip(t, conn, rd, "ZREVRANGE z 0 1\r\n", "*2\r\n$1\r\nc\r\n$1\r\nb\r\n")
+	roundTrip(t, conn, rd, "ZCOUNT z -inf (3\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "ZINCRBY z 2 a\r\n", "$1\r\n3\r\n")
+	roundTrip(t, conn, rd, "ZPOPMIN z\r\n", "*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	roundTrip(t, conn, rd, "ZADD lex 0 a 0 b 0 c\r\n", ":3\r\n")
+	roundTrip(t, conn, rd, "ZRANGEBYLEX lex (a +\r\n", "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	roundTrip(t, conn, rd, "ZRANGEBYLEX lex a +\r\n", "-ERR min or max not valid string range item\r\n")
+	roundTrip(t, conn, rd, "ZREMRANGEBYRANK lex 0 -2\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "ZREMRANGEBYSCORE lex -inf +inf\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "ZADD z3 1 a 5 c\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "ZUNION 2 z z3 WEIGHTS 1 2 AGGREGATE MAX WITHSCORES\r\n", "*4\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nc\r\n$2\r\n10\r\n")
+	roundTrip(t, conn, rd, "ZINTERSTORE zi 2 z z3\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "ZRANGE zi 0 -1 WITHSCORES\r\n", "*4\r\n$1\r\na\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n8\r\n")
+	roundTrip(t, conn, rd, "ZDIFFSTORE zi 2 z z3\r\n", ":0\r\n")
+	roundTrip(t, conn, rd, "ZDIFF 1 z WEIGHTS 1\r\n", "-ERR syntax error\r\n")
+	roundTrip(t, conn, rd, "ZUNIONSTORE zi 0 z\r\n", "-ERR at least 1 input key is needed\r\n")
+	roundTrip(t, conn, rd, "DEL z3\r\n", ":1\r\n")
+
+	roundTrip(t, conn, rd, "EXPIRE z 100\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "TTL z\r\n", ":100\r\n")
+	roundTrip(t, conn, rd, "TTL nokey\r\n", ":-2\r\n")
+
+	roundTrip(t, conn, rd, "TYPE z\r\n", "+zset\r\n")
+	roundTrip(t, conn, rd, "RENAME z z2\r\n", "+OK\r\n")
+	roundTrip(t, conn, rd, "RENAMENX z2 h\r\n", ":0\r\n")
+	roundTrip(t, conn, rd, "EXISTS z z2 l\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "DEL l h\r\n", ":2\r\n")
+	roundTrip(t, conn, rd, "DBSIZE\r\n", ":3\r\n")
+	roundTrip(t, conn, rd, "FLUSHDB\r\n", "+OK\r\n")
+	roundTrip(t, conn, rd, "DBSIZE\r\n", ":0\r\n")
+
+	conn.Close()
+	stopServer(t, s, db)
+}
+
+func TestServer_Errors(t *testing.T) {
+	s, db, conn := startServer(t)
+	rd := bufio.NewReader(conn)
+
+	roundTrip(t, conn, rd, "NOCMD\r\n", "-ERR unknown command 'NOCMD'\r\n")
+	roundTrip(t, conn, rd, "GET\r\n", "-ERR wrong number of arguments for 'get' command\r\n")
+
+	// blank inline lines are skipped
+	roundTrip(t, conn, rd, "   \r\n\t\r\nPING\r\n", "+PONG\r\n")
+	roundTrip(t, conn, rd, "SET \"\" v\r\n", "+OK\r\n")
+	roundTrip(t, conn, rd, "*3\r\n$3\r\nSET\r\n$0\r\n\r\n$1\r\nv\r\n", "-ERR the size of key can not be 0\r\n")
+	roundTrip(t, conn, rd, "LRANGE l a b\r\n", "-ERR value is not an integer or out of range\r\n")
+	roundTrip(t, conn, rd, "*1\r\n$x\r\n", "-ERR invalid bulk length\r\n")
+
+	// connection is closed after protocol error
+	_, err := rd.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	stopServer(t, s, db)
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	s, db, conn := startServer(t)
+	rd := bufio.NewReader(conn)
+
+	roundTrip(t, conn, rd, "SET a 1\r\nSET b 2\r\nMGET a b c\r\n",
+		"+OK\r\n+OK\r\n*3\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n")
+	roundTrip(t, conn, rd, "PING\r\nQUIT\r\nPING\r\n", "+PONG\r\n+OK\r\n")
+
+	_, err := rd.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	stopServer(t, s, db)
+}
+
+func TestServer_Resp3(t *testing.T) {
+	s, db, conn := startServer(t)
+	rd := bufio.NewReader(conn)
+
+	roundTrip(t, conn, rd, "HELLO 4\r\n", "-NOPROTO unsupported protocol version\r\n")
+	_, err := conn.Write([]byte("HELLO 3\r\n"))
+	assert.Nil(t, err)
+	line, err := rd.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "%7\r\n", line)
+	// the last field is modules, an empty array
+	for err == nil && line != "*0\r\n" {
+		line, err = rd.ReadString('\n')
+		assert.Nil(t, err)
+	}
+
+	roundTrip(t, conn, rd, "GET nokey\r\n", "_\r\n")
+	roundTrip(t, conn, rd, "HSET h f v\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "HGETALL h\r\n", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n")
+	roundTrip(t, conn, rd, "ZADD z 1.5 a\r\n", ":1\r\n")
+	roundTrip(t, conn, rd, "ZSCORE z a\r\n", ",1.5\r\n")
+
+	conn.Close()
+	stopServer(t, s, db)
+}
@@ -1,49 +1,90 @@
 package CaskDB
 
-import "os"
+import (
+	"github.com/k-si/CaskDB/ds"
+	"github.com/k-si/CaskDB/util"
+)
 
-// due to the particularity of list structure storage,
-// it is necessary to use snapshots for garbage collection
-
-func (db *DB) listSnapshot(mergePath string) error {
-	idx := db.listIndex.idx
-	keys := idx.GetAllKeys()
-	mergedArchedFiles := make(map[uint32]*File)
-	var mergedActiveFile *File
+// lists of old files are made of positional entries, which can not be
+// merged one by one. after loading, they are turned into sequences in
+// memory, and all list files are rewritten in sequence entries once,
+// like a snapshot. read-only mode keeps them in memory.
+func (db *DB) upgradeLists() error {
+	legacy := db.listIndex.legacy
+	keys := legacy.GetAllKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+	db.listIndex.legacy = ds.NewList()
 
 	for _, k := range keys {
-		vals := idx.Range(k, 0, -1)
-		for _, v := range vals {
-			e := NewEntry([]byte(k), v, List, ListRPush, 0)
-			if err := db.storeMerged(e, mergedArchedFiles, &mergedActiveFile); err != nil {
-				return err
-			}
+		seq := ds.FirstSeq()
+		for _, v := range legacy.Range(k, 0, -1) {
+			db.listIndex.idx.Put(k, seq, &Index{value: v})
+			seq = ds.SeqAfter(seq)
+		}
+		if db.listIndex.idx.Len(k) == 0 {
+			db.untrackKey(List, k)
+			delete(db.listIndex.exp, k)
 		}
 	}
 
-	if mergedActiveFile != nil {
+	if db.config.ReadOnly {
+		return nil
+	}
+	db.logger().Println("[upgrade list files]")
+	return db.snapshotLists()
+}
+
+// replace all list files with the lists in memory,
+// it is only called while opening, nothing is written meanwhile
+func (db *DB) snapshotLists() error {
+	mergePath := db.config.DBDir + PathSeparator + MergeDirName
+	files := append(db.archedFilesOf(List), db.activeFiles[List])
+	last := files[len(files)-1].id
+
+	// the snapshot may be larger than old files
+	m := newMerger(db, List, mergePath, files)
+	m.grow = true
 
-		// close and remove old file
-		for _, f := range db.archedFiles[1] {
-			if err := f.Close(true); err != nil {
+	l := db.lives[List]
+	for _, k := range db.listIndex.idx.GetAllKeys() {
+		for _, el := range db.listIndex.idx.Range(k, 0, -1) {
+			v, err := db.elemValue(el)
+			if err != nil {
+				m.discard()
 				return err
 			}
-			if err := os.Remove(f.fd.Name()); err != nil {
+			e := NewEntry(db.splice([]byte(k), []byte(el.Seq)), v, List, ListSeqSet, uint32(len(k)))
+			if err := m.write(e, el.Value.(*Index)); err != nil {
+				m.discard()
 				return err
 			}
 		}
-		f := db.activeFiles[1]
-		if err := f.Close(true); err != nil {
-			return err
-		}
-		if err := os.Remove(f.fd.Name()); err != nil {
-			return err
-		}
 
-		if err := db.buildFromMerged(mergedActiveFile, mergedArchedFiles, 1, mergePath); err != nil {
-			return err
+		// keep the timeout after values
+		if d, ok := db.listIndex.exp[k]; ok {
+			e := NewEntry([]byte(k), util.IntToBytes(int(d)), List, ListExpire, 0)
+			if err := m.write(e, l.exps[k]); err != nil {
+				m.discard()
+				return err
+			}
 		}
 	}
+	if err := m.commit(); err != nil {
+		return err
+	}
 
+	// the active file is replaced too, open a new one after all
+	for _, f := range m.files {
+		if f.id > last {
+			last = f.id
+		}
+	}
+	f, err := NewFile(db.config.DBDir, last+1, List, db.config.MaxFileSize, db.checksum())
+	if err != nil {
+		return err
+	}
+	db.activeFiles[List] = f
 	return nil
 }
@@ -0,0 +1,396 @@
+package CaskDB
+
+import (
+	"github.com/k-si/CaskDB/util"
+	"sort"
+	"sync/atomic"
+)
+
+// statistics of a data file
+type FileStat struct {
+	DataType uint16
+	FileId   uint32
+	Active   bool
+	Size     int64 // bytes of entries
+	Live     int64 // bytes of live entries, the rest are garbage
+}
+
+func (s *FileStat) Dead() int64 {
+	return s.Size - s.Live
+}
+
+func (s *FileStat) DeadRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.Dead()) / float64(s.Size)
+}
+
+// where the live entries of a data type are. an entry is live until it is
+// overwritten, deleted or expired, other entries are garbage, such as
+// remove entries and batch marks.
+// the str index and the list index know where str entries and
+// list elements are
+type liveSet struct {
+	bytes map[uint32]int64             // file id -> bytes of live entries
+	subs  map[string]map[string]*Index // key -> field or member -> entry of hash, set and zset
+	exps  map[string]*Index            // key -> expire entry
+}
+
+func newLiveSets() []*liveSet {
+	ls := make([]*liveSet, DataTypeNum)
+	for i := range ls {
+		ls[i] = newLiveSet()
+	}
+	return ls
+}
+
+func newLiveSet() *liveSet {
+	return &liveSet{
+		bytes: make(map[uint32]int64),
+		subs:  make(map[string]map[string]*Index),
+		exps:  make(map[string]*Index),
+	}
+}
+
+func (l *liveSet) add(idx *Index) {
+	l.bytes[idx.fileId] += int64(idx.size)
+}
+
+func (l *liveSet) drop(idx *Index) {
+	if idx != nil {
+		l.bytes[idx.fileId] -= int64(idx.size)
+	}
+}
+
+func (l *liveSet) sub(key, sub string) *Index {
+	return l.subs[key][sub]
+}
+
+// replace the live entry of key and sub, nil means it is removed
+func (l *liveSet) setSub(key, sub string, idx *Index) {
+	subs, ok := l.subs[key]
+	if !ok {
+		if idx == nil {
+			return
+		}
+		subs = make(map[string]*Index)
+		l.subs[key] = subs
+	}
+	l.drop(subs[sub])
+	if idx == nil {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(l.subs, key)
+		}
+		return
+	}
+	subs[sub] = idx
+	l.add(idx)
+}
+
+func (l *liveSet) setExp(key string, idx *Index) {
+	l.drop(l.exps[key])
+	delete(l.exps, key)
+	if idx != nil {
+		l.exps[key] = idx
+		l.add(idx)
+	}
+}
+
+// track the entry at idx, it is called before the entry takes effect in memory,
+// caller must hold the write lock of its data type
+func (db *DB) track(e *Entry, idx *Index) {
+	dt := e.GetDataType()
+	mt := e.GetMarkType()
+	l := db.lives[dt]
+	key := string(e.key)
+
+	if mt == expireMarks[dt] {
+		if !db.keyInIndex(dt, key) {
+			return
+		}
+		if util.BytesToInt(e.value) == 0 {
+			l.setExp(key, nil)
+		} else {
+			l.setExp(key, idx)
+		}
+		return
+	}
+
+	switch dt {
+	case Str:
+		if mt != StrSet && mt != StrRemove {
+			return
+		}
+		if v := db.strIndex.idx.Get(e.key); v != nil {
+			l.drop(v.(*Index))
+		}
+		l.setExp(key, nil)
+		if mt == StrSet {
+			l.add(idx)
+		}
+	case List:
+		if mt != ListSeqSet && mt != ListSeqDel {
+			return
+		}
+		if v := db.listIndex.idx.Get(e.GetPreKey(), e.GetPostKey()); v != nil {
+			l.drop(v.(*Index))
+		}
+		if mt == ListSeqSet {
+			l.add(idx)
+		}
+	case Hash:
+		switch mt {
+		case HashHSet:
+			l.setSub(e.GetPreKey(), e.GetPostKey(), idx)
+		case HashHDel:
+			l.setSub(e.GetPreKey(), e.GetPostKey(), nil)
+		}
+	case Set:
+		switch mt {
+		case SetSAdd:
+			l.setSub(key, string(e.value), idx)
+		case SetSRem:
+			l.setSub(key, string(e.value), nil)
+		case SetSMove:
+			l.setSub(e.GetPreKey(), string(e.value), nil)
+			l.setSub(e.GetPostKey(), string(e.value), idx)
+		}
+	case ZSet:
+		switch mt {
+		case ZSetZAdd:
+			l.setSub(e.GetPreKey(), string(e.value), idx)
+		case ZSetZRem:
+			l.setSub(key, string(e.value), nil)
+		}
+	}
+}
+
+// all entries of key are garbage, caller must hold the write lock
+func (db *DB) untrackKey(dataType uint16, key string) {
+	l := db.lives[dataType]
+	switch dataType {
+	case Str:
+		if v := db.strIndex.idx.Get([]byte(key)); v != nil {
+			l.drop(v.(*Index))
+		}
+	case List:
+		for _, el := range db.listIndex.idx.Range(key, 0, -1) {
+			l.drop(el.Value.(*Index))
+		}
+	}
+	for _, idx := range l.subs[key] {
+		l.drop(idx)
+	}
+	delete(l.subs, key)
+	l.setExp(key, nil)
+}
+
+// where the live entry that e would be is, nil if e can not be live.
+// the key which the live entry belongs to is returned too
+func (db *DB) liveIndex(e *Entry) (*Index, string) {
+	dt := e.GetDataType()
+	mt := e.GetMarkType()
+	l := db.lives[dt]
+	key := string(e.key)
+
+	if mt == expireMarks[dt] {
+		return l.exps[key], key
+	}
+
+	switch dt {
+	case Str:
+		if mt == StrSet {
+			if v := db.strIndex.idx.Get(e.key); v != nil {
+				return v.(*Index), key
+			}
+		}
+	case List:
+		if mt == ListSeqSet {
+			if v := db.listIndex.idx.Get(e.GetPreKey(), e.GetPostKey()); v != nil {
+				return v.(*Index), e.GetPreKey()
+			}
+		}
+	case Hash:
+		if mt == HashHSet {
+			return l.sub(e.GetPreKey(), e.GetPostKey()), e.GetPreKey()
+		}
+	case Set:
+		switch mt {
+		case SetSAdd:
+			return l.sub(key, string(e.value)), key
+		case SetSMove:
+			return l.sub(e.GetPostKey(), string(e.value)), e.GetPostKey()
+		}
+	case ZSet:
+		if mt == ZSetZAdd {
+			return l.sub(e.GetPreKey(), string(e.value)), e.GetPreKey()
+		}
+	}
+	return nil, ""
+}
+
+// statistics of files of a data type sorted by id
+func (db *DB) fileStats(dataType uint16) []*FileStat {
+	mu := db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	files := []*File{db.activeFiles[dataType]}
+	for _, f := range db.archedFiles[dataType] {
+		files = append(files, f)
+	}
+	sortFiles(files)
+
+	var stats []*FileStat
+	for _, f := range files {
+		s := &FileStat{
+			DataType: dataType,
+			FileId:   f.id,
+			Active:   f == db.activeFiles[dataType],
+			Size:     f.offset - f.start(),
+			Live:     db.lives[dataType].bytes[f.id],
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// statistics of all data files, sorted by data type and file id
+func (db *DB) FileStats() []*FileStat {
+	var stats []*FileStat
+	for i := uint16(0); i < DataTypeNum; i++ {
+		stats = append(stats, db.fileStats(i)...)
+	}
+	return stats
+}
+
+// a file is worth merging if it has enough garbage, all files with garbage
+// are worth merging when there is too much garbage in total
+func (db *DB) worthMerging(s *FileStat, totalDead int64) bool {
+	if s.Dead() <= 0 || s.Active {
+		return false
+	}
+	if s.DeadRatio() >= db.config.MergeRatio {
+		return true
+	}
+	return db.config.MergeDeadBytes > 0 && totalDead >= db.config.MergeDeadBytes
+}
+
+// garbage which can be merged, active files are never merged
+func mergeableDead(stats []*FileStat) int64 {
+	var dead int64
+	for _, s := range stats {
+		if !s.Active {
+			dead += s.Dead()
+		}
+	}
+	return dead
+}
+
+// whether there is any file worth merging
+func (db *DB) needGC() bool {
+	stats := db.FileStats()
+	dead := mergeableDead(stats)
+	for _, s := range stats {
+		if db.worthMerging(s, dead) {
+			return true
+		}
+	}
+	return false
+}
+
+// arched files worth merging in runs of adjacent files,
+// and whether the first run begins with the oldest file
+func (db *DB) mergeRuns(stats []*FileStat, dataType uint16, totalDead int64) ([][]*File, bool) {
+	var ids []uint32
+	for _, s := range stats {
+		if s.DataType == dataType && !s.Active {
+			ids = append(ids, s.FileId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	worth := make(map[uint32]bool)
+	for _, s := range stats {
+		if s.DataType == dataType && db.worthMerging(s, totalDead) {
+			worth[s.FileId] = true
+		}
+	}
+
+	mu := db.lockerOf(dataType)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var runs [][]*File
+	var run []*File
+	oldest := false
+	for i, id := range ids {
+		f, ok := db.archedFiles[dataType][id]
+		if !ok || !worth[id] {
+			if len(run) > 0 {
+				runs = append(runs, run)
+				run = nil
+			}
+			continue
+		}
+		if i == 0 {
+			oldest = true
+		}
+		run = append(run, f)
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+	return runs, oldest
+}
+
+// progress of gc, the counts are of the running gc, or the last one
+type GCStatus struct {
+	Running        bool
+	FilesTotal     int64 // arched files to merge
+	FilesDone      int64
+	BytesRead      int64
+	BytesWritten   int64
+	EntriesKept    int64
+	EntriesDropped int64
+}
+
+// counters of gc, updated by merging goroutines with atomic
+type gcStat struct {
+	filesTotal     int64
+	filesDone      int64
+	bytesRead      int64
+	bytesWritten   int64
+	entriesKept    int64
+	entriesDropped int64
+	running        uint32
+}
+
+func (s *gcStat) start(files int) {
+	atomic.StoreInt64(&s.filesTotal, int64(files))
+	atomic.StoreInt64(&s.filesDone, 0)
+	atomic.StoreInt64(&s.bytesRead, 0)
+	atomic.StoreInt64(&s.bytesWritten, 0)
+	atomic.StoreInt64(&s.entriesKept, 0)
+	atomic.StoreInt64(&s.entriesDropped, 0)
+	atomic.StoreUint32(&s.running, 1)
+}
+
+func (s *gcStat) stop() {
+	atomic.StoreUint32(&s.running, 0)
+}
+
+func (db *DB) GCStatus() GCStatus {
+	s := db.gcStat
+	return GCStatus{
+		Running:        atomic.LoadUint32(&s.running) == 1,
+		FilesTotal:     atomic.LoadInt64(&s.filesTotal),
+		FilesDone:      atomic.LoadInt64(&s.filesDone),
+		BytesRead:      atomic.LoadInt64(&s.bytesRead),
+		BytesWritten:   atomic.LoadInt64(&s.bytesWritten),
+		EntriesKept:    atomic.LoadInt64(&s.entriesKept),
+		EntriesDropped: atomic.LoadInt64(&s.entriesDropped),
+	}
+}
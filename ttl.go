@@ -0,0 +1,320 @@
+package CaskDB
+
+import (
+	"github.com/k-si/CaskDB/util"
+	"sync"
+	"time"
+)
+
+// deadline of keys in unix nano, key without deadline is not in it
+type expires map[string]int64
+
+func (ex expires) expired(key string) bool {
+	return ex.expiredAt(key, time.Now().UnixNano())
+}
+
+// whether key is expired at the time t in unix nano
+func (ex expires) expiredAt(key string, t int64) bool {
+	d, ok := ex[key]
+	return ok && d <= t
+}
+
+// count the keys which are expired but not removed yet
+func (ex expires) expiredNum() int {
+	now := time.Now().UnixNano()
+	n := 0
+	for _, d := range ex {
+		if d <= now {
+			n++
+		}
+	}
+	return n
+}
+
+// expire mark of every data type
+var expireMarks = []uint16{StrExpire, ListExpire, HashExpire, SetExpire, ZSetExpire}
+
+// marks of entries which remove the whole key
+var clearMarks = []uint16{StrRemove, ListClear, HashClear, SetClear, ZSetClear}
+
+// the greatest mark of every data type
+var lastMarks = []uint16{StrExpire, ListClear, HashClear, SetClear, ZSetClear}
+
+func (db *DB) lockerOf(dataType uint16) *sync.RWMutex {
+	switch dataType {
+	case Str:
+		return db.strIndex.mu
+	case List:
+		return db.listIndex.mu
+	case Hash:
+		return db.hashIndex.mu
+	case Set:
+		return db.setIndex.mu
+	default:
+		return db.zsetIndex.mu
+	}
+}
+
+func (db *DB) expiresOf(dataType uint16) expires {
+	switch dataType {
+	case Str:
+		return db.strIndex.exp
+	case List:
+		return db.listIndex.exp
+	case Hash:
+		return db.hashIndex.exp
+	case Set:
+		return db.setIndex.exp
+	default:
+		return db.zsetIndex.exp
+	}
+}
+
+// whether key is in memory index, no matter it is expired or not
+func (db *DB) keyInIndex(dataType uint16, key string) bool {
+	switch dataType {
+	case Str:
+		return db.strIndex.idx.Get([]byte(key)) != nil
+	case List:
+		return db.listIndex.idx.KeyExist(key) || db.listIndex.legacy.KeyExist(key)
+	case Hash:
+		return db.hashIndex.idx.KeyExist(key)
+	case Set:
+		return db.setIndex.idx.KeyExist(key)
+	default:
+		return db.zsetIndex.idx.KeyExist(key)
+	}
+}
+
+// remove key and its deadline from memory index
+func (db *DB) clearKey(dataType uint16, key string) {
+	db.watch.touch(key)
+	db.snaps.save(dataType, key)
+	db.untrackKey(dataType, key)
+	switch dataType {
+	case Str:
+		db.strIndex.idx.Remove([]byte(key))
+	case List:
+		db.listIndex.idx.Clear(key)
+		db.listIndex.legacy.Clear(key)
+	case Hash:
+		db.hashIndex.idx.Clear(key)
+	case Set:
+		db.setIndex.idx.Clear(key)
+	case ZSet:
+		db.zsetIndex.idx.Clear(key)
+	}
+	delete(db.expiresOf(dataType), key)
+}
+
+// a collection is removed with its last element, its deadline goes with it.
+// caller must hold the write lock
+func (db *DB) forgetEmpty(dataType uint16, key string) {
+	if !db.keyInIndex(dataType, key) {
+		db.untrackKey(dataType, key)
+		delete(db.expiresOf(dataType), key)
+	}
+}
+
+// lazy remove, caller must hold the write lock of dataType.
+// we do not append any entry here, because every entry has a timestamp,
+// loading will remove the key in the same way when it meets an entry
+// which is written after the deadline.
+func (db *DB) expireIfNeeded(dataType uint16, keys ...[]byte) {
+	ex := db.expiresOf(dataType)
+	for _, k := range keys {
+		if ex.expired(string(k)) {
+			db.clearKey(dataType, string(k))
+		}
+	}
+}
+
+// remove all expired keys of dataType, caller must hold the write lock
+func (db *DB) removeExpired(dataType uint16) {
+	ex := db.expiresOf(dataType)
+	now := time.Now().UnixNano()
+	for k, d := range ex {
+		if d <= now {
+			db.clearKey(dataType, k)
+		}
+	}
+}
+
+// background sweeper, remove the keys that nobody visits after expired
+func (db *DB) listeningExpire() {
+	ticker := time.NewTicker(db.config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.expireChan:
+			return
+		case <-ticker.C:
+			for i := uint16(0); i < DataTypeNum; i++ {
+				mu := db.lockerOf(i)
+				mu.Lock()
+				db.removeExpired(i)
+				mu.Unlock()
+			}
+		}
+	}
+}
+
+// load deadline from expire entry
+func (db *DB) buildExpire(e *Entry) {
+	dt := e.GetDataType()
+	k := string(e.key)
+	if !db.keyInIndex(dt, k) {
+		return
+	}
+	ex := db.expiresOf(dt)
+	if d := int64(util.BytesToInt(e.value)); d == 0 {
+		delete(ex, k)
+	} else {
+		ex[k] = d
+	}
+}
+
+// user keys that the entry touches
+func entryKeys(e *Entry) []string {
+	dt := e.GetDataType()
+	mt := e.GetMarkType()
+	switch dt {
+	case List:
+		switch mt {
+		case ListLInsert, ListRInsert, ListLSet, ListLRem, ListSeqSet, ListSeqDel:
+			return []string{e.GetPreKey()}
+		}
+	case Hash:
+		if mt != HashExpire && mt != HashClear {
+			return []string{e.GetPreKey()}
+		}
+	case Set:
+		if mt == SetSMove {
+			return []string{e.GetPreKey(), e.GetPostKey()}
+		}
+	case ZSet:
+		if mt == ZSetZAdd {
+			return []string{e.GetPreKey()}
+		}
+	}
+	return []string{string(e.key)}
+}
+
+// while loading, an entry written after the deadline of its key means
+// the key had been removed lazily before the entry was written
+func (db *DB) expireBeforeEntry(e *Entry) {
+	dt := e.GetDataType()
+	ex := db.expiresOf(dt)
+	if len(ex) == 0 {
+		return
+	}
+	for _, k := range entryKeys(e) {
+		if d, ok := ex[k]; ok && d <= int64(e.timestamp) {
+			db.clearKey(dt, k)
+		}
+	}
+}
+
+// set deadline of key in all data types, 0 means persist
+func (db *DB) setDeadline(key []byte, deadline int64) error {
+	exist := false
+
+	for i := uint16(0); i < DataTypeNum; i++ {
+		err := func() error {
+			mu := db.lockerOf(i)
+			mu.Lock()
+			defer mu.Unlock()
+
+			db.expireIfNeeded(i, key)
+			k := string(key)
+			if !db.keyInIndex(i, k) {
+				return nil
+			}
+			exist = true
+
+			ex := db.expiresOf(i)
+			if _, ok := ex[k]; !ok && deadline == 0 {
+				return nil
+			}
+			return db.storeDeadline(i, key, deadline)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !exist {
+		return ErrorKeyNotExist
+	}
+	return nil
+}
+
+// write expire entry and update memory, caller must hold the write lock
+func (db *DB) storeDeadline(dataType uint16, key []byte, deadline int64) error {
+	e := NewEntry(key, util.IntToBytes(int(deadline)), dataType, expireMarks[dataType], 0)
+	if err := db.StoreFile(e); err != nil {
+		return err
+	}
+
+	k := string(key)
+	ex := db.expiresOf(dataType)
+	if deadline == 0 {
+		delete(ex, k)
+	} else {
+		ex[k] = deadline
+	}
+	if ex.expired(k) {
+		db.clearKey(dataType, k)
+	}
+	return nil
+}
+
+// set a timeout on key, the key will be removed after timeout
+func (db *DB) Expire(key []byte, ttl time.Duration) error {
+	if err := db.checkKeySize(key); err != nil {
+		return err
+	}
+	return db.setDeadline(key, time.Now().Add(ttl).UnixNano())
+}
+
+// same as Expire, but use an absolute time
+func (db *DB) ExpireAt(key []byte, t time.Time) error {
+	if err := db.checkKeySize(key); err != nil {
+		return err
+	}
+	return db.setDeadline(key, t.UnixNano())
+}
+
+// remove the timeout on key
+func (db *DB) Persist(key []byte) error {
+	if err := db.checkKeySize(key); err != nil {
+		return err
+	}
+	return db.setDeadline(key, 0)
+}
+
+// get remaining time to live of key, -1 means the key has no timeout
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if err := db.checkKeySize(key); err != nil {
+		return 0, err
+	}
+
+	k := string(key)
+	for i := uint16(0); i < DataTypeNum; i++ {
+		mu := db.lockerOf(i)
+		mu.RLock()
+		ex := db.expiresOf(i)
+		if db.keyInIndex(i, k) && !ex.expired(k) {
+			d, ok := ex[k]
+			mu.RUnlock()
+			if !ok {
+				return -1, nil
+			}
+			return time.Duration(d - time.Now().UnixNano()), nil
+		}
+		mu.RUnlock()
+	}
+
+	return 0, ErrorKeyNotExist
+}
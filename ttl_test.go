@@ -0,0 +1,217 @@
+package CaskDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Expire(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k := []byte("k")
+	err = db.Expire(k, time.Second)
+	assert.Equal(t, ErrorKeyNotExist, err)
+
+	err = db.Set(k, []byte("v"))
+	err = db.HSet(k, []byte("f"), []byte("v"))
+	err = db.RPush(k, []byte("v"))
+	err = db.SAdd(k, []byte("v"))
+	err = db.ZAdd(k, 1, []byte("v"))
+	assert.Nil(t, err)
+
+	err = db.Expire(k, 100*time.Millisecond)
+	assert.Nil(t, err)
+	ttl, err := db.TTL(k)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 100*time.Millisecond)
+
+	time.Sleep(150 * time.Millisecond)
+
+	v, err := db.Get(k)
+	assert.Nil(t, v)
+	v, err = db.HGet(k, []byte("f"))
+	assert.Nil(t, v)
+	assert.Equal(t, 0, db.LLen(k))
+	assert.False(t, db.SIsMember(k, []byte("v")))
+	assert.False(t, db.ZIsMember(k, []byte("v")))
+	assert.Equal(t, 0, db.StrLen())
+	_, err = db.TTL(k)
+	assert.Equal(t, ErrorKeyNotExist, err)
+
+	// write after expired, the old data is gone
+	err = db.HSet(k, []byte("f1"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.HLen(k))
+	ttl, err = db.TTL(k)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// same after restart
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.False(t, db.HExist(k, []byte("f")))
+	assert.True(t, db.HExist(k, []byte("f1")))
+	assert.False(t, db.StrKeyExist(k))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_SetEx_Persist(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k1, k2 := []byte("k1"), []byte("k2")
+	err = db.SetEx(k1, []byte("v"), time.Hour)
+	assert.Nil(t, err)
+	err = db.SetEx(k2, []byte("v"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Persist(k2)
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	ttl, err := db.TTL(k1)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+	ttl, err = db.TTL(k2)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// set clears the timeout
+	err = db.Set(k1, []byte("v1"))
+	ttl, err = db.TTL(k1)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+// a crash while SetEx is written never leaves the key without timeout
+func TestDB_SetEx_Torn(t *testing.T) {
+	k := []byte("k")
+	name := "/tmp/CaskDB" + PathSeparator + fmt.Sprintf(FileNameFormat[Str], 0)
+	for cut := int64(1); ; cut += 8 {
+		os.RemoveAll("/tmp/CaskDB")
+		db, err := Open(DefaultConfig())
+		assert.Nil(t, err)
+		start := db.activeFiles[Str].offset
+		assert.Nil(t, db.SetEx(k, []byte("v"), time.Hour))
+		end := db.activeFiles[Str].offset
+		assert.Nil(t, db.Close())
+		if start+cut >= end {
+			break
+		}
+
+		assert.Nil(t, os.Truncate(name, start+cut))
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+		if db.StrKeyExist(k) {
+			ttl, err := db.TTL(k)
+			assert.Nil(t, err)
+			assert.True(t, ttl > 0, "cut at %d", cut)
+		}
+		assert.Nil(t, db.Close())
+	}
+}
+
+func TestDB_ExpireAt_Restart(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	k := []byte("k")
+	err = db.ZAdd(k, 1, []byte("a"))
+	err = db.ExpireAt(k, time.Now().Add(100*time.Millisecond))
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	time.Sleep(150 * time.Millisecond)
+
+	db, err = Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.False(t, db.zsetIndex.idx.KeyExist(string(k)))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Expire_Sweep(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.SweepInterval = 50 * time.Millisecond
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	k := []byte("k")
+	err = db.SAdd(k, []byte("a"), []byte("b"))
+	err = db.Expire(k, 50*time.Millisecond)
+	assert.Nil(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	db.setIndex.mu.RLock()
+	assert.False(t, db.setIndex.idx.KeyExist(string(k)))
+	assert.Equal(t, 0, len(db.setIndex.exp))
+	db.setIndex.mu.RUnlock()
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_GC_Expire(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 80
+	db, err := Open(cfg)
+	assert.Nil(t, err)
+
+	err = db.Set([]byte("aa"), []byte("11"))
+	err = db.Set([]byte("bb"), []byte("22"))
+	err = db.Expire([]byte("aa"), 50*time.Millisecond)
+	err = db.Expire([]byte("bb"), time.Hour)
+	err = db.HSet([]byte("h"), []byte("f"), []byte("v"))
+	err = db.Expire([]byte("h"), 50*time.Millisecond)
+	err = db.RPush([]byte("l"), []byte("v"))
+	err = db.Expire([]byte("l"), time.Hour)
+	assert.Nil(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = db.GC()
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(cfg)
+	assert.Nil(t, err)
+
+	aa, err := db.Get([]byte("aa"))
+	assert.Nil(t, aa)
+	bb, err := db.Get([]byte("bb"))
+	assert.Equal(t, []byte("22"), bb)
+	ttl, err := db.TTL([]byte("bb"))
+	assert.True(t, ttl > 59*time.Minute)
+	ttl, err = db.TTL([]byte("l"))
+	assert.True(t, ttl > 59*time.Minute)
+	assert.False(t, db.HKeyExist([]byte("h")))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
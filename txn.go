@@ -0,0 +1,211 @@
+package CaskDB
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// times Update runs its function again when the transaction conflicts
+const TxnMaxRetries = 10
+
+// optimistic transaction. keys are watched before they are read, writes
+// are queued, and Exec writes them in a batch only if no watched key has
+// been changed since it was watched. a key is watched in all data types.
+// reads go through the db methods
+type Txn struct {
+	db       *DB
+	wb       *WriteBatch
+	watched  []string
+	dirty    uint32 // 1: a watched key is changed
+	readOnly bool
+	done     bool
+}
+
+func (db *DB) NewTxn() *Txn {
+	return &Txn{db: db, wb: db.NewWriteBatch()}
+}
+
+// changes of keys from now on abort the transaction
+func (tx *Txn) Watch(keys ...[]byte) {
+	if tx.done {
+		return
+	}
+	for _, k := range keys {
+		tx.watched = append(tx.watched, string(k))
+	}
+	tx.db.watch.add(tx, keys...)
+}
+
+func (tx *Txn) Unwatch() {
+	tx.db.watch.remove(tx)
+	tx.watched = nil
+	atomic.StoreUint32(&tx.dirty, 0)
+}
+
+// queue writes, the first error is returned by Exec
+
+func (tx *Txn) queue() *WriteBatch {
+	if tx.readOnly && tx.wb.err == nil {
+		tx.wb.err = ErrorTxnReadOnly
+	}
+	return tx.wb
+}
+
+func (tx *Txn) Set(key, value []byte)                    { tx.queue().Set(key, value) }
+func (tx *Txn) Remove(key []byte)                        { tx.queue().Remove(key) }
+func (tx *Txn) LPush(key []byte, values ...[]byte)       { tx.queue().LPush(key, values...) }
+func (tx *Txn) RPush(key []byte, values ...[]byte)       { tx.queue().RPush(key, values...) }
+func (tx *Txn) HSet(key, k, v []byte)                    { tx.queue().HSet(key, k, v) }
+func (tx *Txn) HDel(key []byte, fields ...[]byte)        { tx.queue().HDel(key, fields...) }
+func (tx *Txn) SAdd(key []byte, values ...[]byte)        { tx.queue().SAdd(key, values...) }
+func (tx *Txn) SRem(key []byte, values ...[]byte)        { tx.queue().SRem(key, values...) }
+func (tx *Txn) ZAdd(key []byte, score float64, m []byte) { tx.queue().ZAdd(key, score, m) }
+func (tx *Txn) ZRem(key, member []byte)                  { tx.queue().ZRem(key, member) }
+func (tx *Txn) Del(keys ...[]byte)                       { tx.queue().Del(keys...) }
+func (tx *Txn) Expire(key []byte, ttl time.Duration)     { tx.queue().Expire(key, ttl) }
+
+// write the queued writes, ErrorTxnConflict is returned and nothing is
+// written if a watched key has been changed. the transaction is finished
+func (tx *Txn) Exec() error {
+	if tx.done {
+		return ErrorTxnDone
+	}
+	tx.done = true
+	defer tx.Unwatch()
+
+	if tx.wb.err != nil {
+		return tx.wb.err
+	}
+	db := tx.db
+	if atomic.LoadUint32(&db.isClosed) == 1 {
+		return ErrorClosedDB
+	}
+	if err := db.writable(); err != nil && len(tx.wb.types()) > 0 {
+		return err
+	}
+
+	// nothing is written between the check and the batch
+	defer db.lockAll()()
+	for _, k := range tx.watched {
+		for i := uint16(0); i < DataTypeNum; i++ {
+			db.expireIfNeeded(i, []byte(k))
+		}
+	}
+	if atomic.LoadUint32(&tx.dirty) == 1 {
+		return ErrorTxnConflict
+	}
+	return tx.wb.write()
+}
+
+// drop the queued writes, the transaction is finished
+func (tx *Txn) Discard() {
+	tx.done = true
+	tx.Unwatch()
+}
+
+// run fn in a transaction and Exec it. fn is run again if the transaction
+// conflicts, at most TxnMaxRetries times, so it must watch the keys it reads
+func (db *DB) Update(fn func(tx *Txn) error) error {
+	for i := 0; ; i++ {
+		tx := db.NewTxn()
+		if err := fn(tx); err != nil {
+			tx.Discard()
+			return err
+		}
+		err := tx.Exec()
+		if err != ErrorTxnConflict || i == TxnMaxRetries {
+			return err
+		}
+	}
+}
+
+// run fn in a transaction which can not write, ErrorTxnReadOnly is
+// returned if fn queues any write. it tells whether the watched keys
+// were changed while fn was running
+func (db *DB) View(fn func(tx *Txn) error) error {
+	tx := db.NewTxn()
+	tx.readOnly = true
+	defer tx.Discard()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.wb.err != nil {
+		return tx.wb.err
+	}
+	if atomic.LoadUint32(&tx.dirty) == 1 {
+		return ErrorTxnConflict
+	}
+	return nil
+}
+
+// transactions watching keys
+type watchList struct {
+	mu   sync.Mutex
+	n    int32 // number of watched keys, writes skip the list when it is 0
+	keys map[string]map[*Txn]struct{}
+}
+
+func newWatchList() *watchList {
+	return &watchList{keys: make(map[string]map[*Txn]struct{})}
+}
+
+func (w *watchList) add(tx *Txn, keys ...[]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, k := range keys {
+		txs, ok := w.keys[string(k)]
+		if !ok {
+			txs = make(map[*Txn]struct{})
+			w.keys[string(k)] = txs
+		}
+		if _, ok := txs[tx]; !ok {
+			txs[tx] = struct{}{}
+			atomic.AddInt32(&w.n, 1)
+		}
+	}
+}
+
+func (w *watchList) remove(tx *Txn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, k := range tx.watched {
+		txs := w.keys[k]
+		if _, ok := txs[tx]; !ok {
+			continue
+		}
+		delete(txs, tx)
+		atomic.AddInt32(&w.n, -1)
+		if len(txs) == 0 {
+			delete(w.keys, k)
+		}
+	}
+}
+
+// key is changed, the transactions watching it will abort
+func (w *watchList) touch(keys ...string) {
+	if atomic.LoadInt32(&w.n) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, k := range keys {
+		for tx := range w.keys[k] {
+			atomic.StoreUint32(&tx.dirty, 1)
+		}
+	}
+}
+
+// all keys are changed
+func (w *watchList) touchAll() {
+	if atomic.LoadInt32(&w.n) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, txs := range w.keys {
+		for tx := range txs {
+			atomic.StoreUint32(&tx.dirty, 1)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package CaskDB
+
+import (
+	"github.com/k-si/CaskDB/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestTxn_Exec(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+
+	// watched key is changed, nothing is written
+	tx := db.NewTxn()
+	tx.Watch([]byte("h"))
+	tx.Set([]byte("k"), []byte("v"))
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Equal(t, ErrorTxnConflict, tx.Exec())
+	assert.Equal(t, ErrorTxnDone, tx.Exec())
+	v, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+
+	// other keys do not matter
+	tx = db.NewTxn()
+	tx.Watch([]byte("k"))
+	tx.Set([]byte("k"), []byte("v"))
+	tx.SAdd([]byte("s"), []byte("a"))
+	tx.Del([]byte("h"))
+	assert.Nil(t, db.Set([]byte("other"), []byte("v")))
+	assert.Nil(t, tx.Exec())
+
+	for i := 0; i < 2; i++ {
+		v, err := db.Get([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), v)
+		assert.True(t, db.SIsMember([]byte("s"), []byte("a")))
+		assert.False(t, db.HExist([]byte("h"), []byte("f")))
+
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(DefaultConfig())
+		assert.Nil(t, err)
+	}
+
+	// removing the key is a change too
+	tx = db.NewTxn()
+	tx.Watch([]byte("s"))
+	_, err = db.Del([]byte("s"))
+	assert.Nil(t, err)
+	tx.Set([]byte("k"), []byte("v2"))
+	assert.Equal(t, ErrorTxnConflict, tx.Exec())
+
+	// so is flushing the db
+	tx = db.NewTxn()
+	tx.Watch([]byte("k"))
+	tx.Set([]byte("k"), []byte("v2"))
+	assert.Nil(t, db.FlushDB())
+	assert.Equal(t, ErrorTxnConflict, tx.Exec())
+	v, _ = db.Get([]byte("k"))
+	assert.Nil(t, v)
+	assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+
+	// discarded
+	tx = db.NewTxn()
+	tx.Set([]byte("k"), []byte("v2"))
+	tx.Discard()
+	assert.Equal(t, ErrorTxnDone, tx.Exec())
+	v, _ = db.Get([]byte("k"))
+	assert.Equal(t, []byte("v"), v)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Update(t *testing.T) {
+	os.RemoveAll("/tmp/CaskDB")
+	db, err := Open(DefaultConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, db.Set([]byte("n"), util.IntToBytes(0)))
+
+	// increase n concurrently, no update is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				err := db.Update(func(tx *Txn) error {
+					tx.Watch([]byte("n"))
+					v, err := db.Get([]byte("n"))
+					if err != nil {
+						return err
+					}
+					tx.Set([]byte("n"), util.IntToBytes(util.BytesToInt(v)+1))
+					return nil
+				})
+				if err != nil && err != ErrorTxnConflict {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// conflicts after all retries are reported, the rest are counted
+	v, err := db.Get([]byte("n"))
+	assert.Nil(t, err)
+	assert.True(t, util.BytesToInt(v) > 0 && util.BytesToInt(v) <= 20)
+
+	// view can not write
+	err = db.View(func(tx *Txn) error {
+		tx.Set([]byte("n"), nil)
+		return nil
+	})
+	assert.Equal(t, ErrorTxnReadOnly, err)
+	err = db.View(func(tx *Txn) error {
+		tx.Watch([]byte("n"))
+		_, err := db.Get([]byte("n"))
+		return err
+	})
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
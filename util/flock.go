@@ -0,0 +1,40 @@
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+var ErrorLocked = errors.New("[file is locked by another process]")
+
+// an advisory lock on a file, it is released when the process exits
+type FileLock struct {
+	fd *os.File
+}
+
+// lock the file at path, the file is created if not exist.
+// shared locks can be held by many processes, an exclusive lock by only one.
+// ErrorLocked is returned at once if the lock can not be acquired
+func LockFile(path string, shared bool) (*FileLock, error) {
+	// a read-only dir is fine if the file exists
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFd(fd, shared); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &FileLock{fd: fd}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	if err := unlockFd(l.fd); err != nil {
+		l.fd.Close()
+		return err
+	}
+	return l.fd.Close()
+}
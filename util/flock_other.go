@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package util
+
+import "os"
+
+// no file lock on this platform
+func lockFd(fd *os.File, shared bool) error {
+	return nil
+}
+
+func unlockFd(fd *os.File) error {
+	return nil
+}
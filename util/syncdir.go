@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import "os"
+
+// flush a directory to disk, so that renames and removes in it survive a crash
+func SyncDir(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
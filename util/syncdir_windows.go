@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package util
+
+// directories can not be flushed on windows, renames are durable by the file system
+func SyncDir(path string) error {
+	return nil
+}